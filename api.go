@@ -0,0 +1,578 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	recentMatchesSize  = 1000
+	apiDefaultLimit    = 100
+	apiMaxRequestBytes = 64 << 10
+
+	eventsKeepAlive = 30 * time.Second
+	eventsRetry     = 5 * time.Second
+	// eventsBuffer is how many matches an /events client may fall behind
+	// before it is disconnected; it reconnects and catches up from the
+	// recent matches with Last-Event-ID.
+	eventsBuffer = 256
+)
+
+// APIConfig is the api section of the configuration file.
+type APIConfig struct {
+	Addr  string `yaml:"addr,omitempty"`
+	Token string `yaml:"token,omitempty"`
+	// AllowOrigin lets a dashboard on another origin open /events.
+	AllowOrigin string `yaml:"allow_origin,omitempty"`
+}
+
+var (
+	apiAddrFlag string
+	apiConfig   APIConfig
+	recent      *recentMatches
+	// apiCalls carries work from the API handlers to the monitor loop, the
+	// only goroutine that reads the profiles' targets.
+	apiCalls = make(chan func())
+)
+
+func addAPIFlags(fs *flag.FlagSet) {
+	fs.StringVar(&apiAddrFlag, "api-addr", "", "serve the HTTP API on this address, e.g. 127.0.0.1:8090; the token is read from api.token in the config")
+}
+
+// applyAPIConfig merges the API settings from the configuration file with
+// the -api-addr flag. Without a token the API is only served on loopback.
+func applyAPIConfig(cfg *Config) error {
+	var ac APIConfig
+	if cfg != nil {
+		ac = cfg.API
+	}
+	if apiAddrFlag != "" {
+		ac.Addr = apiAddrFlag
+	}
+	ac.Token = strings.TrimSpace(ac.Token)
+	if ac.Addr == "" {
+		return nil
+	}
+
+	if ac.Token == "" {
+		host, _, err := net.SplitHostPort(ac.Addr)
+		if err != nil {
+			return fmt.Errorf("invalid API address %q: %v", ac.Addr, err)
+		}
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return fmt.Errorf("the API on %s needs a token: set api.token in the configuration file", ac.Addr)
+		}
+		logger.Warn("API token not configured; the API is open to every local user", "addr", ac.Addr)
+	}
+	apiConfig = ac
+	recent = newRecentMatches(recentMatchesSize)
+	return nil
+}
+
+// recentMatches keeps the last matches for the API in a ring buffer and
+// passes new ones to the /events subscribers. Every match gets the next ID,
+// so clients can ask for matches after one they saw.
+type recentMatches struct {
+	mu      sync.Mutex
+	matches []recentMatch
+	start   int
+	nextID  int64
+	subs    map[chan recentMatch]bool
+}
+
+type recentMatch struct {
+	ID    int64 `json:"id"`
+	Muted bool  `json:"muted,omitempty"`
+	matchEvent
+}
+
+func newRecentMatches(size int) *recentMatches {
+	return &recentMatches{
+		matches: make([]recentMatch, 0, size),
+		nextID:  1,
+		subs:    make(map[chan recentMatch]bool),
+	}
+}
+
+func (r *recentMatches) add(event matchEvent, muted bool) recentMatch {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m := recentMatch{ID: r.nextID, Muted: muted, matchEvent: event}
+	r.nextID++
+	if len(r.matches) < cap(r.matches) {
+		r.matches = append(r.matches, m)
+	} else {
+		r.matches[r.start] = m
+		r.start = (r.start + 1) % len(r.matches)
+	}
+
+	for sub := range r.subs {
+		select {
+		case sub <- m:
+		default:
+			close(sub)
+			delete(r.subs, sub)
+		}
+	}
+	return m
+}
+
+// subscribe returns a channel receiving every new match. The channel is
+// closed when the subscriber falls more than eventsBuffer matches behind.
+func (r *recentMatches) subscribe() (<-chan recentMatch, func()) {
+	sub := make(chan recentMatch, eventsBuffer)
+	r.mu.Lock()
+	r.subs[sub] = true
+	r.mu.Unlock()
+
+	return sub, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.subs[sub] {
+			close(sub)
+			delete(r.subs, sub)
+		}
+	}
+}
+
+// list returns the matches after the given ID, oldest first, that pass keep.
+func (r *recentMatches) list(after int64, keep func(recentMatch) bool) []recentMatch {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var list []recentMatch
+	for i := range r.matches {
+		m := r.matches[(r.start+i)%len(r.matches)]
+		if m.ID > after && keep(m) {
+			list = append(list, m)
+		}
+	}
+	return list
+}
+
+// onMonitorLoop runs fn on the monitor loop and waits for it to finish.
+func onMonitorLoop(ctx context.Context, fn func()) error {
+	done := make(chan struct{})
+	select {
+	case apiCalls <- func() { fn(); close(done) }:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	<-done
+	return nil
+}
+
+func startAPIServer() {
+	api := &apiServer{status: newStatusReporter()}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/targets", api.listTargets)
+	mux.HandleFunc("POST /api/targets", api.addTarget)
+	mux.HandleFunc("DELETE /api/targets/{target}", api.removeTarget)
+	mux.HandleFunc("POST /api/targets/{target}/mute", api.muteTarget(true))
+	mux.HandleFunc("DELETE /api/targets/{target}/mute", api.muteTarget(false))
+	mux.HandleFunc("GET /api/matches", api.listMatches)
+	mux.HandleFunc("GET /api/logs", api.listLogs)
+	mux.HandleFunc("POST /api/notify", api.notify)
+	mux.HandleFunc("GET /events", api.events)
+
+	server := &http.Server{Addr: apiConfig.Addr, Handler: api.authorize(mux), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatal("API server failed", "addr", apiConfig.Addr, "error", err)
+		}
+	}()
+	logger.Info("serving API", "addr", apiConfig.Addr)
+}
+
+type apiServer struct {
+	mu     sync.Mutex
+	status *statusReporter
+}
+
+type apiTarget struct {
+	Profile string `json:"profile"`
+	Target  string `json:"target"`
+	Muted   bool   `json:"muted"`
+	Matches int64  `json:"matches"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// apiDelivery is the outcome of a test notification for one provider.
+type apiDelivery struct {
+	Sent  bool   `json:"sent"`
+	Error string `json:"error,omitempty"`
+}
+
+// authorize checks the bearer token. /events also takes it as ?token=
+// because browsers cannot set headers on an EventSource.
+func (a *apiServer) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if apiConfig.Token != "" {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok && r.URL.Path == "/events" {
+				token = r.URL.Query().Get("token")
+				ok = token != ""
+			}
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(apiConfig.Token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="crtmon"`)
+				writeAPIError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *apiServer) listTargets(w http.ResponseWriter, r *http.Request) {
+	targets := []apiTarget{}
+	err := onMonitorLoop(r.Context(), func() {
+		matchCounts.mu.Lock()
+		defer matchCounts.mu.Unlock()
+		for _, p := range profiles {
+			for _, t := range p.targets {
+				targets = append(targets, apiTarget{
+					Profile: p.name,
+					Target:  t,
+					Muted:   p.muted[t],
+					Matches: matchCounts.counts[matchKey{p.name, t}],
+				})
+			}
+		}
+	})
+	if err != nil {
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, targets)
+}
+
+func (a *apiServer) addTarget(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Target  string `json:"target"`
+		Profile string `json:"profile"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiMaxRequestBytes)).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	target := strings.TrimSpace(req.Target)
+	if target == "" {
+		writeAPIError(w, http.StatusBadRequest, "target is required")
+		return
+	}
+
+	status, message := http.StatusCreated, ""
+	err := onMonitorLoop(r.Context(), func() {
+		p, err := apiProfile(req.Profile)
+		switch {
+		case err != nil:
+			status, message = http.StatusBadRequest, err.Error()
+		case slices.Contains(p.targets, target):
+			status, message = http.StatusConflict, fmt.Sprintf("target %q already exists in profile %q", target, p.name)
+		default:
+			p.targets = append(slices.Clip(p.targets), target)
+			req.Profile = p.name
+		}
+	})
+	if err != nil {
+		return
+	}
+	if message != "" {
+		writeAPIError(w, status, message)
+		return
+	}
+	logger.Info("target added through the API for this run", "target", target, "profile", req.Profile)
+	writeAPIJSON(w, status, apiTarget{Profile: req.Profile, Target: target})
+}
+
+func (a *apiServer) removeTarget(w http.ResponseWriter, r *http.Request) {
+	target := r.PathValue("target")
+	var removed []string
+	err := onMonitorLoop(r.Context(), func() {
+		for _, p := range apiProfiles(r.URL.Query().Get("profile")) {
+			if i := slices.Index(p.targets, target); i >= 0 {
+				p.targets = slices.Delete(slices.Clone(p.targets), i, i+1)
+				delete(p.muted, target)
+				removed = append(removed, p.name)
+			}
+		}
+	})
+	if err != nil {
+		return
+	}
+	if len(removed) == 0 {
+		writeAPIError(w, http.StatusNotFound, fmt.Sprintf("target %q not found", target))
+		return
+	}
+	logger.Info("target removed through the API for this run", "target", target, "profiles", strings.Join(removed, ","))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// muteTarget stops or resumes notifications for a target. Matches are still
+// printed, written and listed while it is muted.
+func (a *apiServer) muteTarget(mute bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target := r.PathValue("target")
+		var changed []apiTarget
+		err := onMonitorLoop(r.Context(), func() {
+			for _, p := range apiProfiles(r.URL.Query().Get("profile")) {
+				if !slices.Contains(p.targets, target) {
+					continue
+				}
+				if mute {
+					if p.muted == nil {
+						p.muted = make(map[string]bool)
+					}
+					p.muted[target] = true
+				} else {
+					delete(p.muted, target)
+				}
+				changed = append(changed, apiTarget{Profile: p.name, Target: target, Muted: mute})
+			}
+		})
+		if err != nil {
+			return
+		}
+		if len(changed) == 0 {
+			writeAPIError(w, http.StatusNotFound, fmt.Sprintf("target %q not found", target))
+			return
+		}
+		logger.Info("target mute changed through the API", "target", target, "muted", mute)
+		writeAPIJSON(w, http.StatusOK, changed)
+	}
+}
+
+func (a *apiServer) listMatches(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit, after := apiDefaultLimit, int64(0)
+	var err error
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			writeAPIError(w, http.StatusBadRequest, "limit must be a positive number")
+			return
+		}
+	}
+	if v := query.Get("after"); v != "" {
+		if after, err = strconv.ParseInt(v, 10, 64); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "after must be a match ID")
+			return
+		}
+	}
+
+	matches := recent.list(after, matchFilter(query.Get("target"), query.Get("profile")))
+	if len(matches) > limit {
+		matches = matches[len(matches)-limit:]
+	}
+	if matches == nil {
+		matches = []recentMatch{}
+	}
+	writeAPIJSON(w, http.StatusOK, matches)
+}
+
+// matchFilter keeps matches of the given targets, comma separated, and
+// profile; empty values keep everything.
+func matchFilter(targets, profile string) func(recentMatch) bool {
+	var want []string
+	for _, t := range strings.Split(targets, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			want = append(want, t)
+		}
+	}
+	return func(m recentMatch) bool {
+		if len(want) > 0 && !slices.Contains(want, m.Target) {
+			return false
+		}
+		name := m.Profile
+		if name == "" {
+			name = defaultProfile
+		}
+		return profile == "" || profile == name
+	}
+}
+
+// events streams matches as Server-Sent Events. A client that reconnects
+// with Last-Event-ID first gets the matches it missed that are still among
+// the recent matches.
+func (a *apiServer) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeAPIError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+	query := r.URL.Query()
+	keep := matchFilter(query.Get("target"), query.Get("profile"))
+
+	var last int64
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = query.Get("last_event_id")
+	}
+	if lastID != "" {
+		id, err := strconv.ParseInt(lastID, 10, 64)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "Last-Event-ID must be a match ID")
+			return
+		}
+		last = id
+	}
+
+	// subscribe before reading the backlog so no match falls in between
+	sub, unsubscribe := recent.subscribe()
+	defer unsubscribe()
+
+	if apiConfig.AllowOrigin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", apiConfig.AllowOrigin)
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", eventsRetry.Milliseconds())
+	if lastID != "" {
+		for _, m := range recent.list(last, keep) {
+			if err := writeEvent(w, m); err != nil {
+				return
+			}
+			last = m.ID
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case m, ok := <-sub:
+			if !ok {
+				return
+			}
+			if m.ID <= last || !keep(m) {
+				continue
+			}
+			if err := writeEvent(w, m); err != nil {
+				return
+			}
+			last = m.ID
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, m recentMatch) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", m.ID, m.Event, data)
+	return err
+}
+
+func (a *apiServer) listLogs(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	snapshot := a.status.snapshot(time.Now())
+	a.mu.Unlock()
+	writeAPIJSON(w, http.StatusOK, snapshot)
+}
+
+func (a *apiServer) notify(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Message string `json:"message"`
+		Profile string `json:"profile"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiMaxRequestBytes)).Decode(&req); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+			return
+		}
+	}
+	if req.Message == "" {
+		req.Message = "crtmon test notification"
+	}
+
+	var sent []*profile
+	for _, p := range apiProfiles(req.Profile) {
+		if (p.notifyDiscord && p.discordConfigured()) || (p.notifyTelegram && p.telegramConfigured()) {
+			sent = append(sent, p)
+		}
+	}
+	if len(sent) == 0 {
+		writeAPIError(w, http.StatusConflict, "no notification providers enabled; start crtmon with -notify")
+		return
+	}
+
+	// the result of every provider is reported; a failed delivery turns
+	// the response into 502 so callers do not mistake it for success
+	status := http.StatusOK
+	result := make(map[string]map[string]apiDelivery)
+	for _, p := range sent {
+		logger.Info("sending test notification through the API", "profile", p.name, "notification", p.notificationStatus())
+		deliveries := make(map[string]apiDelivery)
+		for provider, err := range p.notifier.sendText("crtmon", []string{req.Message}) {
+			if err != nil {
+				deliveries[provider] = apiDelivery{Error: err.Error()}
+				status = http.StatusBadGateway
+			} else {
+				deliveries[provider] = apiDelivery{Sent: true}
+			}
+		}
+		result[p.name] = deliveries
+	}
+	writeAPIJSON(w, status, result)
+}
+
+// apiProfile returns the named profile, or the only one when name is empty.
+func apiProfile(name string) (*profile, error) {
+	if name == "" {
+		if len(profiles) == 1 {
+			return profiles[0], nil
+		}
+		return nil, errors.New("profile is required when several profiles are running")
+	}
+	for _, p := range profiles {
+		if p.name == name {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("profile %q is not running", name)
+}
+
+// apiProfiles returns the named profile, or every profile when name is empty.
+func apiProfiles(name string) []*profile {
+	if name == "" {
+		return profiles
+	}
+	if p, err := apiProfile(name); err == nil {
+		return []*profile{p}
+	}
+	return nil
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeAPIJSON(w, status, apiError{Error: message})
+}
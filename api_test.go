@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testMatch(profile, target, domain string) matchEvent {
+	return matchEvent{Event: eventMatch, SchemaVersion: schemaVersion, Profile: profile, Target: target, Domain: domain}
+}
+
+func matchIDs(matches []recentMatch) string {
+	var ids []string
+	for _, m := range matches {
+		ids = append(ids, fmt.Sprint(m.ID))
+	}
+	return strings.Join(ids, ",")
+}
+
+func keepAll(recentMatch) bool { return true }
+
+func TestRecentMatchesRing(t *testing.T) {
+	tests := []struct {
+		name  string
+		size  int
+		adds  int
+		after int64
+		want  string
+	}{
+		{name: "empty", size: 3, adds: 0, want: ""},
+		{name: "not full", size: 3, adds: 2, want: "1,2"},
+		{name: "full", size: 3, adds: 3, want: "1,2,3"},
+		{name: "wrapped keeps the newest, oldest first", size: 3, adds: 7, want: "5,6,7"},
+		{name: "after an id", size: 3, adds: 7, after: 5, want: "6,7"},
+		{name: "after an evicted id", size: 3, adds: 7, after: 2, want: "5,6,7"},
+		{name: "after the newest", size: 3, adds: 7, after: 7, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRecentMatches(tt.size)
+			for i := 1; i <= tt.adds; i++ {
+				if m := r.add(testMatch("", "example", fmt.Sprintf("%d.example.com", i)), false); m.ID != int64(i) {
+					t.Fatalf("add() assigned ID %d, want %d", m.ID, i)
+				}
+			}
+			if got := matchIDs(r.list(tt.after, keepAll)); got != tt.want {
+				t.Errorf("list(%d) = %s, want %s", tt.after, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecentMatchesSubscribe(t *testing.T) {
+	r := newRecentMatches(10)
+	sub, unsubscribe := r.subscribe()
+
+	r.add(testMatch("", "example", "a.example.com"), true)
+	if m := <-sub; m.ID != 1 || !m.Muted || m.Domain != "a.example.com" {
+		t.Errorf("received %+v", m)
+	}
+
+	// a subscriber that falls more than eventsBuffer behind is closed
+	for i := 0; i <= eventsBuffer; i++ {
+		r.add(testMatch("", "example", "b.example.com"), false)
+	}
+	n := 0
+	for range sub {
+		n++
+	}
+	if n != eventsBuffer {
+		t.Errorf("slow subscriber got %d matches before being closed, want %d", n, eventsBuffer)
+	}
+	// unsubscribing after the channel was closed must not close it again
+	unsubscribe()
+}
+
+func TestMatchFilter(t *testing.T) {
+	tests := []struct {
+		targets, profile string
+		match            matchEvent
+		want             bool
+	}{
+		{"", "", testMatch("red", "a", "x"), true},
+		{"a, b", "", testMatch("", "b", "x"), true},
+		{"a,b", "", testMatch("", "c", "x"), false},
+		{"", "red", testMatch("red", "a", "x"), true},
+		{"", "red", testMatch("", "a", "x"), false},
+		{"", defaultProfile, testMatch("", "a", "x"), true},
+		{"a", "red", testMatch("blue", "a", "x"), false},
+	}
+	for _, tt := range tests {
+		if got := matchFilter(tt.targets, tt.profile)(recentMatch{matchEvent: tt.match}); got != tt.want {
+			t.Errorf("matchFilter(%q, %q) on %s/%s = %v, want %v", tt.targets, tt.profile, tt.match.Profile, tt.match.Target, got, tt.want)
+		}
+	}
+}
+
+// readEvents reads n SSE events from the stream and returns their IDs.
+func readEvents(t *testing.T, scanner *bufio.Scanner, n int) []string {
+	t.Helper()
+	var ids []string
+	for len(ids) < n && scanner.Scan() {
+		line := scanner.Text()
+		if id, ok := strings.CutPrefix(line, "id: "); ok {
+			ids = append(ids, id)
+		}
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			var m recentMatch
+			if err := json.Unmarshal([]byte(data), &m); err != nil {
+				t.Fatalf("invalid event data %q: %v", data, err)
+			}
+			if fmt.Sprint(m.ID) != ids[len(ids)-1] {
+				t.Errorf("event id %s carries match %d", ids[len(ids)-1], m.ID)
+			}
+		}
+	}
+	return ids
+}
+
+func TestEventsReplay(t *testing.T) {
+	saved := recent
+	defer func() { recent = saved }()
+
+	tests := []struct {
+		name        string
+		query       string
+		lastEventID string
+		wantReplay  string
+	}{
+		{name: "new client gets only live matches", wantReplay: ""},
+		{name: "reconnect replays missed matches", lastEventID: "2", wantReplay: "3,4,5"},
+		{name: "replay is filtered", lastEventID: "2", query: "?target=b", wantReplay: "4"},
+		{name: "last event id as query parameter", query: "?last_event_id=4", wantReplay: "5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recent = newRecentMatches(recentMatchesSize)
+			for i, target := range []string{"a", "a", "a", "b", "a"} {
+				recent.add(testMatch("", target, fmt.Sprintf("%d.example.com", i+1)), false)
+			}
+
+			server := httptest.NewServer(http.HandlerFunc((&apiServer{}).events))
+			defer server.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+tt.query, nil)
+			if tt.lastEventID != "" {
+				req.Header.Set("Last-Event-ID", tt.lastEventID)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+				t.Fatalf("Content-Type = %q", ct)
+			}
+
+			// a live match for every filter ends the replay
+			scanner := bufio.NewScanner(resp.Body)
+			want := tt.wantReplay
+			var n int
+			if want != "" {
+				n = strings.Count(want, ",") + 1
+			}
+			live := recent.add(testMatch("", "b", "live.example.com"), false)
+			if want != "" {
+				want += ","
+			}
+			want += fmt.Sprint(live.ID)
+			if got := strings.Join(readEvents(t, scanner, n+1), ","); got != want {
+				t.Errorf("events = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestEventsBadLastEventID(t *testing.T) {
+	saved := recent
+	defer func() { recent = saved }()
+	recent = newRecentMatches(10)
+
+	req := httptest.NewRequest(http.MethodGet, "/events", nil)
+	req.Header.Set("Last-Event-ID", "abc")
+	w := httptest.NewRecorder()
+	(&apiServer{}).events(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestNotifyReportsDelivery(t *testing.T) {
+	saved := profiles
+	sent, failures, retries := discordStats.sent.Load(), discordStats.failures.Load(), discordStats.retries.Load()
+	defer func() {
+		profiles = saved
+		discordStats.sent.Store(sent)
+		discordStats.failures.Store(failures)
+		discordStats.retries.Store(retries)
+	}()
+
+	webhook := func(status int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}))
+	}
+	ok, failing := webhook(http.StatusNoContent), webhook(http.StatusInternalServerError)
+	defer ok.Close()
+	defer failing.Close()
+
+	newTestProfile := func(name, url string, enabled bool) *profile {
+		p := newProfile(name, &ProfileConfig{Webhook: url})
+		p.notifyDiscord = enabled
+		return p
+	}
+
+	tests := []struct {
+		name       string
+		profiles   []*profile
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "delivered",
+			profiles:   []*profile{newTestProfile("red", ok.URL, true)},
+			wantStatus: http.StatusOK,
+			wantBody:   `{"red":{"discord":{"sent":true}}}`,
+		},
+		{
+			name:       "one provider fails",
+			profiles:   []*profile{newTestProfile("red", ok.URL, true), newTestProfile("blue", failing.URL, true)},
+			wantStatus: http.StatusBadGateway,
+			wantBody:   `{"blue":{"discord":{"sent":false,"error":"discord webhook returned 500 Internal Server Error"}},"red":{"discord":{"sent":true}}}`,
+		},
+		{
+			name:       "only the requested profile",
+			profiles:   []*profile{newTestProfile("red", ok.URL, true), newTestProfile("blue", failing.URL, true)},
+			body:       `{"profile":"red","message":"hello"}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"red":{"discord":{"sent":true}}}`,
+		},
+		{
+			name:       "no provider enabled",
+			profiles:   []*profile{newTestProfile("red", ok.URL, false)},
+			wantStatus: http.StatusConflict,
+			wantBody:   `{"error":"no notification providers enabled; start crtmon with -notify"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profiles = tt.profiles
+			req := httptest.NewRequest(http.MethodPost, "/api/notify", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			(&apiServer{}).notify(w, req)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tt.wantBody {
+				t.Errorf("body = %s, want %s", got, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	ct "github.com/google/certificate-transparency-go"
+	cttls "github.com/google/certificate-transparency-go/tls"
+	"golang.org/x/mod/sumdb/tlog"
+)
+
+const sthCheckInterval = time.Minute
+
+var verifySTH bool
+
+var (
+	errBadSignature     = errors.New("invalid tree head signature")
+	errInconsistentTree = errors.New("tree head does not match the log's tiles")
+	errTileFetch        = errors.New("failed to fetch hash tile")
+)
+
+func addVerifyFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&verifySTH, "verify-sth", false, "verify tree head signatures and consistency between tree heads, alerting if a log misbehaves")
+}
+
+// treeHead is a tree head observed from a log, from an RFC 6962 STH or a
+// static-ct-api checkpoint.
+type treeHead struct {
+	size      int64
+	rootHash  tlog.Hash
+	timestamp uint64
+}
+
+// logKey is a log's public key from the log list.
+type logKey struct {
+	der      []byte
+	verifier *ct.SignatureVerifier
+}
+
+// logAuditor is implemented by fetchers whose tree heads can be verified.
+type logAuditor interface {
+	// signedTreeHead fetches the current tree head. When key is set the
+	// signature is checked and errBadSignature is returned if it is wrong.
+	signedTreeHead(ctx context.Context, key *logKey) (*treeHead, error)
+	// consistencyProof returns a proof that old is a prefix of cur.
+	consistencyProof(ctx context.Context, old, cur *treeHead) (tlog.TreeProof, error)
+}
+
+func parseLogKey(der []byte) (*logKey, error) {
+	if len(der) == 0 {
+		return nil, nil
+	}
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := ct.NewSignatureVerifier(pub)
+	if err != nil {
+		return nil, err
+	}
+	return &logKey{der: der, verifier: verifier}, nil
+}
+
+// auditLog periodically fetches the tree head of a log, verifies its
+// signature and checks it is consistent with the previous one. Failures to
+// reach the log are left to the fetcher; only misbehaviour raises alerts.
+func (m *CTMonitor) auditLog(w *logWorker) {
+	auditor, ok := w.fetcher.(logAuditor)
+	if !ok {
+		return
+	}
+
+	key, err := parseLogKey(w.log.Key)
+	if err != nil {
+		logger.Warn("invalid log public key; tree head signatures not verified", "log", w.log.Description, "error", err)
+	} else if key == nil {
+		logger.Warn("no public key for log; tree head signatures not verified", "log", w.log.Description)
+	}
+
+	var prev *treeHead
+	alerted := make(map[string]bool)
+	alert := func(kind, detail string) {
+		if !alerted[kind] {
+			alerted[kind] = true
+			w.stats.alerts.Add(1)
+			raiseLogAlert(w.log, kind, detail)
+		}
+	}
+
+	check := func() {
+		th, err := auditor.signedTreeHead(m.ctx, key)
+		if errors.Is(err, errBadSignature) {
+			alert("bad_signature", err.Error())
+			return
+		}
+		if err != nil {
+			logger.Debug("failed to fetch tree head for verification", "log", w.log.Description, "error", err)
+			return
+		}
+		w.stats.treeSize.Store(th.size)
+
+		switch {
+		case prev == nil || prev.size == 0:
+		case th.size < prev.size:
+			alert("tree_shrunk", fmt.Sprintf("tree size went from %d to %d", prev.size, th.size))
+		case th.size == prev.size:
+			if th.rootHash != prev.rootHash {
+				alert("root_hash_changed", fmt.Sprintf("two different root hashes for tree size %d", th.size))
+			}
+		default:
+			proof, err := auditor.consistencyProof(m.ctx, prev, th)
+			if errors.Is(err, errInconsistentTree) {
+				alert("inconsistent_tree", err.Error())
+				break
+			}
+			if err != nil {
+				logger.Debug("failed to fetch consistency proof", "log", w.log.Description, "error", err)
+				return
+			}
+			if err := tlog.CheckTree(proof, th.size, th.rootHash, prev.size, prev.rootHash); err != nil {
+				alert("inconsistent_tree", fmt.Sprintf("tree size %d is not consistent with tree size %d: %v", th.size, prev.size, err))
+			}
+		}
+		prev = th
+	}
+
+	check()
+	ticker := time.NewTicker(sthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+			check()
+		}
+	}
+}
+
+// raiseLogAlert reports a misbehaving log through the logger, the JSON
+// output and the notification providers of every selected profile.
+func raiseLogAlert(l *ctLog, kind, detail string) {
+	if jsonOutput {
+		outputJSONAlert(l, kind, detail)
+	} else {
+		logger.Error("CT log misbehaving", "log", l.Description, "alert", kind, "detail", detail)
+	}
+
+	for _, p := range profiles {
+		if p.notifyDiscord || p.notifyTelegram {
+			go p.notifier.sendText("CT log alert: "+l.Description, []string{kind + ": " + detail})
+		}
+	}
+}
+
+func (f *rfc6962Fetcher) signedTreeHead(ctx context.Context, key *logKey) (*treeHead, error) {
+	sth, err := f.client.GetSTH(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if key != nil {
+		if err := key.verifier.VerifySTHSignature(*sth); err != nil {
+			return nil, fmt.Errorf("%w: %v", errBadSignature, err)
+		}
+	}
+	return &treeHead{size: int64(sth.TreeSize), rootHash: tlog.Hash(sth.SHA256RootHash), timestamp: sth.Timestamp}, nil
+}
+
+func (f *rfc6962Fetcher) consistencyProof(ctx context.Context, old, cur *treeHead) (tlog.TreeProof, error) {
+	hashes, err := f.client.GetSTHConsistency(ctx, uint64(old.size), uint64(cur.size))
+	if err != nil {
+		return nil, err
+	}
+	proof := make(tlog.TreeProof, len(hashes))
+	for i, h := range hashes {
+		if len(h) != tlog.HashSize {
+			return nil, fmt.Errorf("malformed consistency proof hash of %d bytes", len(h))
+		}
+		proof[i] = tlog.Hash(h)
+	}
+	return proof, nil
+}
+
+func (f *tiledFetcher) signedTreeHead(ctx context.Context, key *logKey) (*treeHead, error) {
+	cp, err := f.checkpoint(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(cp.rootHash) != tlog.HashSize {
+		return nil, fmt.Errorf("malformed checkpoint root hash of %d bytes", len(cp.rootHash))
+	}
+
+	th := &treeHead{size: cp.size, rootHash: tlog.Hash(cp.rootHash)}
+	if key != nil {
+		if th.timestamp, err = verifyCheckpoint(cp, th, key); err != nil {
+			return nil, fmt.Errorf("%w: %v", errBadSignature, err)
+		}
+	}
+	return th, nil
+}
+
+// consistencyProof builds the proof from the log's hash tiles. The tiles are
+// authenticated against the current root hash as they are read, so tiles
+// that do not match the signed checkpoint are reported as inconsistent.
+func (f *tiledFetcher) consistencyProof(ctx context.Context, old, cur *treeHead) (tlog.TreeProof, error) {
+	reader := tlog.TileHashReader(tlog.Tree{N: cur.size, Hash: cur.rootHash}, &hashTileReader{ctx: ctx, fetcher: f})
+	proof, err := tlog.ProveTree(cur.size, old.size, reader)
+	if err != nil && !errors.Is(err, errTileFetch) {
+		return nil, fmt.Errorf("%w: tree size %d: %v", errInconsistentTree, cur.size, err)
+	}
+	return proof, err
+}
+
+// verifyCheckpoint checks the RFC 6962 note signature of a checkpoint and
+// returns the timestamp it carries. The signature is a TreeHeadSignature,
+// so it is verified the same way as an STH.
+func verifyCheckpoint(cp *checkpoint, th *treeHead, key *logKey) (uint64, error) {
+	hash := sha256.New()
+	hash.Write([]byte(cp.origin + "\n\x05"))
+	hash.Write(key.der)
+	keyID := hash.Sum(nil)[:4]
+
+	_, sigs, found := strings.Cut(string(cp.raw), "\n\n")
+	if !found {
+		return 0, errors.New("checkpoint is not signed")
+	}
+	for _, line := range strings.Split(sigs, "\n") {
+		name, sig, ok := parseNoteSignature(line)
+		if !ok || name != cp.origin || len(sig) < 12 || string(sig[:4]) != string(keyID) {
+			continue
+		}
+
+		var ds ct.DigitallySigned
+		if rest, err := cttls.Unmarshal(sig[12:], &ds); err != nil || len(rest) > 0 {
+			return 0, errors.New("malformed checkpoint signature")
+		}
+		sth := ct.SignedTreeHead{
+			Version:           ct.V1,
+			TreeSize:          uint64(th.size),
+			Timestamp:         binary.BigEndian.Uint64(sig[4:12]),
+			SHA256RootHash:    ct.SHA256Hash(th.rootHash),
+			TreeHeadSignature: ds,
+		}
+		if err := key.verifier.VerifySTHSignature(sth); err != nil {
+			return 0, err
+		}
+		return sth.Timestamp, nil
+	}
+	return 0, errors.New("no checkpoint signature from the log key")
+}
+
+// hashTileReader reads static-ct-api hash tiles for tlog.
+type hashTileReader struct {
+	ctx     context.Context
+	fetcher *tiledFetcher
+}
+
+func (r *hashTileReader) Height() int {
+	return 8
+}
+
+func (r *hashTileReader) ReadTiles(tiles []tlog.Tile) ([][]byte, error) {
+	data := make([][]byte, len(tiles))
+	for i, t := range tiles {
+		path := fmt.Sprintf("%s/tile/%d/%s", r.fetcher.logURL, t.L, tilePath(t.N))
+		var err error
+		if t.W < tileWidth {
+			data[i], err = r.fetcher.get(r.ctx, fmt.Sprintf("%s.p/%d", path, t.W))
+			if errors.Is(err, errTileNotFound) {
+				data[i], err = r.fetcher.get(r.ctx, path)
+			}
+		} else {
+			data[i], err = r.fetcher.get(r.ctx, path)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w %d/%d: %v", errTileFetch, t.L, t.N, err)
+		}
+		if want := t.W * tlog.HashSize; len(data[i]) > want {
+			data[i] = data[i][:want]
+		}
+	}
+	return data, nil
+}
+
+func (r *hashTileReader) SaveTiles([]tlog.Tile, [][]byte) {}
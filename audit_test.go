@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	ct "github.com/google/certificate-transparency-go"
+	cttls "github.com/google/certificate-transparency-go/tls"
+	"golang.org/x/mod/sumdb/tlog"
+)
+
+const testOrigin = "log.example.com/2025h2"
+
+func newTestLogKey(t *testing.T) (*ecdsa.PrivateKey, *logKey) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := parseLogKey(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, key
+}
+
+// noteSignature signs a tree head the way static-ct-api logs sign their
+// checkpoints: key ID, timestamp and a TLS-encoded TreeHeadSignature.
+func noteSignature(t *testing.T, priv *ecdsa.PrivateKey, key *logKey, origin string, size int64, root tlog.Hash, timestamp uint64) string {
+	t.Helper()
+	input, err := ct.SerializeSTHSignatureInput(ct.SignedTreeHead{
+		Version:        ct.V1,
+		TreeSize:       uint64(size),
+		Timestamp:      timestamp,
+		SHA256RootHash: ct.SHA256Hash(root),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(input)
+	sig, err := priv.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, err := cttls.Marshal(ct.DigitallySigned{
+		Algorithm: cttls.SignatureAndHashAlgorithm{Hash: cttls.SHA256, Signature: cttls.ECDSA},
+		Signature: sig,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := sha256.New()
+	h.Write([]byte(origin + "\n\x05"))
+	h.Write(key.der)
+	blob := append(h.Sum(nil)[:4], binary.BigEndian.AppendUint64(nil, timestamp)...)
+	blob = append(blob, ds...)
+	return fmt.Sprintf("— %s %s\n", origin, base64.StdEncoding.EncodeToString(blob))
+}
+
+func checkpointNote(size int64, root tlog.Hash, sigs ...string) []byte {
+	note := fmt.Sprintf("%s\n%d\n%s\n", testOrigin, size, base64.StdEncoding.EncodeToString(root[:]))
+	if len(sigs) > 0 {
+		note += "\n"
+		for _, sig := range sigs {
+			note += sig
+		}
+	}
+	return []byte(note)
+}
+
+func TestVerifyCheckpoint(t *testing.T) {
+	priv, key := newTestLogKey(t)
+	otherPriv, otherKey := newTestLogKey(t)
+	root := tlog.Hash(sha256.Sum256([]byte("root")))
+	const size, timestamp = 1000, 1700000000000
+
+	valid := noteSignature(t, priv, key, testOrigin, size, root, timestamp)
+	tests := []struct {
+		name    string
+		note    []byte
+		wantErr bool
+	}{
+		{name: "valid", note: checkpointNote(size, root, valid)},
+		{name: "valid among other signatures", note: checkpointNote(size, root,
+			noteSignature(t, otherPriv, otherKey, testOrigin, size, root, timestamp), valid)},
+		{name: "unsigned", note: checkpointNote(size, root), wantErr: true},
+		{name: "signed by another key", note: checkpointNote(size, root,
+			noteSignature(t, otherPriv, otherKey, testOrigin, size, root, timestamp)), wantErr: true},
+		{name: "signed for another origin", note: checkpointNote(size, root,
+			noteSignature(t, priv, key, "other.example.com", size, root, timestamp)), wantErr: true},
+		{name: "size does not match signature", note: checkpointNote(size+1, root, valid), wantErr: true},
+		{name: "root does not match signature", note: checkpointNote(size, tlog.Hash{}, valid), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cp, err := parseCheckpoint(tt.note)
+			if err != nil {
+				t.Fatal(err)
+			}
+			th := &treeHead{size: cp.size, rootHash: tlog.Hash(cp.rootHash)}
+			got, err := verifyCheckpoint(cp, th, key)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("verifyCheckpoint() succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verifyCheckpoint() error = %v", err)
+			}
+			if got != timestamp {
+				t.Errorf("verifyCheckpoint() timestamp = %d, want %d", got, timestamp)
+			}
+		})
+	}
+}
+
+// testTree is an in-memory Merkle tree served as static-ct-api hash tiles.
+type testTree struct {
+	hashes []tlog.Hash
+}
+
+func newTestTree(t *testing.T, n int64) *testTree {
+	t.Helper()
+	tree := &testTree{}
+	for i := int64(0); i < n; i++ {
+		hashes, err := tlog.StoredHashes(i, []byte(fmt.Sprintf("leaf %d", i)), tree.reader())
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree.hashes = append(tree.hashes, hashes...)
+	}
+	return tree
+}
+
+func (tree *testTree) reader() tlog.HashReaderFunc {
+	return func(indexes []int64) ([]tlog.Hash, error) {
+		out := make([]tlog.Hash, len(indexes))
+		for i, idx := range indexes {
+			out[i] = tree.hashes[idx]
+		}
+		return out, nil
+	}
+}
+
+func (tree *testTree) root(t *testing.T, n int64) tlog.Hash {
+	t.Helper()
+	h, err := tlog.TreeHash(n, tree.reader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// handler serves the hash tiles of a tree of n entries, partial tiles under
+// their .p/<width> path as tiled logs do.
+func (tree *testTree) handler(t *testing.T, n int64) http.Handler {
+	t.Helper()
+	tiles := make(map[string][]byte)
+	for _, tile := range tlog.NewTiles(8, 0, n) {
+		data, err := tlog.ReadTileData(tile, tree.reader())
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := fmt.Sprintf("/tile/%d/%s", tile.L, tilePath(tile.N))
+		if tile.W < tileWidth {
+			path += fmt.Sprintf(".p/%d", tile.W)
+		}
+		tiles[path] = data
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := tiles[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	})
+}
+
+func TestTiledConsistencyProof(t *testing.T) {
+	const oldSize, curSize = 100, 700
+	tree := newTestTree(t, curSize)
+	oldHead := &treeHead{size: oldSize, rootHash: tree.root(t, oldSize)}
+	curHead := &treeHead{size: curSize, rootHash: tree.root(t, curSize)}
+	forged := &treeHead{size: curSize, rootHash: tlog.Hash(sha256.Sum256([]byte("forged")))}
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		cur     *treeHead
+		wantErr error
+	}{
+		{name: "consistent", handler: tree.handler(t, curSize), cur: curHead},
+		{name: "tiles do not match the tree head", handler: tree.handler(t, curSize), cur: forged, wantErr: errInconsistentTree},
+		{name: "tiles missing", handler: http.NotFoundHandler(), cur: curHead, wantErr: errTileFetch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.handler)
+			defer server.Close()
+			f := &tiledFetcher{logURL: server.URL, client: server.Client()}
+
+			proof, err := f.consistencyProof(context.Background(), oldHead, tt.cur)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("consistencyProof() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("consistencyProof() error = %v", err)
+			}
+			if err := tlog.CheckTree(proof, curSize, curHead.rootHash, oldSize, oldHead.rootHash); err != nil {
+				t.Errorf("proof does not verify: %v", err)
+			}
+		})
+	}
+}
+
+func TestTiledSignedTreeHead(t *testing.T) {
+	priv, key := newTestLogKey(t)
+	_, otherKey := newTestLogKey(t)
+	root := tlog.Hash(sha256.Sum256([]byte("root")))
+	note := checkpointNote(42, root, noteSignature(t, priv, key, testOrigin, 42, root, 1234))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(note)
+	}))
+	defer server.Close()
+	f := &tiledFetcher{logURL: server.URL, client: server.Client()}
+
+	tests := []struct {
+		name    string
+		key     *logKey
+		wantErr error
+		wantTS  uint64
+	}{
+		{name: "no key", key: nil},
+		{name: "log key", key: key, wantTS: 1234},
+		{name: "wrong key", key: otherKey, wantErr: errBadSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			th, err := f.signedTreeHead(context.Background(), tt.key)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("signedTreeHead() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("signedTreeHead() error = %v", err)
+			}
+			if th.size != 42 || th.rootHash != root || th.timestamp != tt.wantTS {
+				t.Errorf("signedTreeHead() = %+v", th)
+			}
+		})
+	}
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultBackfillChunk   = 10000
+	defaultBackfillWorkers = 8
+	backfillProgressEvery  = 30 * time.Second
+)
+
+var backfillFlags struct {
+	log        string
+	from, to   string
+	workers    int
+	chunk      int64
+	checkpoint string
+	tiled      bool
+}
+
+func addBackfillFlags(fs *flag.FlagSet) {
+	fs.StringVar(&backfillFlags.log, "log", "", "URL of the CT log to scan")
+	fs.StringVar(&backfillFlags.from, "from", "", "first entry to scan: an index, a date (2006-01-02, RFC 3339) or a duration ago (36h, 7d)")
+	fs.StringVar(&backfillFlags.to, "to", "", "scan up to this entry, exclusive: an index, a date or a duration ago (default: the current tree size)")
+	fs.IntVar(&backfillFlags.workers, "workers", defaultBackfillWorkers, "chunks fetched in parallel")
+	fs.Int64Var(&backfillFlags.chunk, "chunk", defaultBackfillChunk, "entries per chunk; progress is checkpointed per chunk")
+	fs.StringVar(&backfillFlags.checkpoint, "checkpoint", "", "`file` recording finished chunks to resume from (default: in the cache directory)")
+	fs.BoolVar(&backfillFlags.tiled, "tiled", false, "the log serves the static-ct-api; only needed for logs missing from the log list")
+}
+
+// backfillCheckpoint records which chunks of a scan are finished. It is keyed
+// by the log and the indices -from and -to resolved to, so the same range
+// resumes whether it is given as dates or indices, while a relative date
+// such as 7d names a new range once time moves on. Without -to the scan
+// runs to the tree head seen when it started, kept in End.
+type backfillCheckpoint struct {
+	Log   string  `json:"log"`
+	Start int64   `json:"start"`
+	End   int64   `json:"end"`
+	Head  bool    `json:"head,omitempty"`
+	Chunk int64   `json:"chunk"`
+	Done  []int64 `json:"done"`
+
+	path string
+	mu   sync.Mutex
+}
+
+// backfillCheckpointPath returns where the checkpoint of a scan from start
+// to end is kept; end is negative for a scan to the tree head.
+func backfillCheckpointPath(logURL string, start, end int64) (string, error) {
+	if backfillFlags.checkpoint != "" {
+		return backfillFlags.checkpoint, nil
+	}
+	dir, err := getCacheDir()
+	if err != nil {
+		return "", err
+	}
+	to := "head"
+	if end >= 0 {
+		to = strconv.FormatInt(end, 10)
+	}
+	sum := sha256.Sum256([]byte(normalizeLogURL(logURL) + "\n" + strconv.FormatInt(start, 10) + "\n" + to))
+	return filepath.Join(dir, "backfill-"+hex.EncodeToString(sum[:6])+".json"), nil
+}
+
+// loadBackfillCheckpoint returns the checkpoint of an interrupted scan of the
+// same range, or nil when there is none. end is negative for a scan to the
+// tree head, which resumes up to the head of the interrupted scan.
+func loadBackfillCheckpoint(path, logURL string, start, end int64) (*backfillCheckpoint, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cp backfillCheckpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint %s: %w", path, err)
+	}
+	if normalizeLogURL(cp.Log) != normalizeLogURL(logURL) || cp.Start != start || cp.Head != (end < 0) || (end >= 0 && cp.End != end) {
+		return nil, fmt.Errorf("checkpoint %s belongs to a scan of %s from %d to %d", path, cp.Log, cp.Start, cp.End)
+	}
+	cp.path = path
+	return &cp, nil
+}
+
+func (cp *backfillCheckpoint) finish(chunk int64) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.Done = append(cp.Done, chunk)
+	sort.Slice(cp.Done, func(i, j int) bool { return cp.Done[i] < cp.Done[j] })
+	if err := cp.save(); err != nil {
+		logger.Warn("failed to save backfill checkpoint", "path", cp.path, "error", err)
+	}
+}
+
+func (cp *backfillCheckpoint) save() error {
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(cp.path), 0755); err != nil {
+		return err
+	}
+	tmp := cp.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, cp.path)
+}
+
+func normalizeLogURL(u string) string {
+	u = strings.TrimPrefix(strings.TrimPrefix(u, "https://"), "http://")
+	return strings.ToLower(strings.TrimSuffix(u, "/"))
+}
+
+// findBackfillLog looks the log up in the log list and the configured extra
+// logs for its key and API. Logs found in neither are read as RFC 6962 logs,
+// or static-ct-api logs with -tiled.
+func findBackfillLog(logURL string) *ctLog {
+	var candidates []*ctLog
+	if ll, err := loadLogList(); err != nil {
+		logger.Warn("failed to load CT log list", "error", err)
+	} else {
+		candidates = allLogs(ll)
+		registerSCTLogs(candidates)
+	}
+	for _, extra := range logFilter.Extra {
+		if l, err := extraLog(extra); err == nil {
+			candidates = append(candidates, l)
+		}
+	}
+	for _, l := range candidates {
+		if normalizeLogURL(l.URL) == normalizeLogURL(logURL) {
+			return l
+		}
+	}
+
+	logger.Warn("log not in the log list; SCTs and tree heads cannot be checked against its key", "log", logURL, "tiled", backfillFlags.tiled)
+	l, _ := extraLog(ExtraLogConfig{URL: logURL, Tiled: backfillFlags.tiled})
+	return l
+}
+
+// resolveLogIndex turns a -from or -to argument into a log index. Dates are
+// resolved by binary search over entry timestamps to the first entry logged
+// at or after the date. Logs are only roughly ordered by timestamp, so the
+// result is approximate around the boundary.
+func resolveLogIndex(ctx context.Context, f logFetcher, value string, size int64) (int64, error) {
+	if index, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if index < 0 || index > size {
+			return 0, fmt.Errorf("index %d is outside the log, which has %d entries", index, size)
+		}
+		return index, nil
+	}
+
+	t, err := parseTimeFlag(value, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	target := uint64(t.UnixMilli())
+
+	var searchErr error
+	index := int64(sort.Search(int(size), func(i int) bool {
+		if searchErr != nil {
+			return true
+		}
+		var ts uint64
+		found := false
+		err := f.fetch(ctx, int64(i), int64(i)+1, func(entry rawEntry) {
+			if entry.index == int64(i) {
+				ts, found = entry.timestamp, true
+			}
+		})
+		if err == nil && !found {
+			err = fmt.Errorf("log did not return entry %d", i)
+		}
+		if err != nil {
+			searchErr = err
+			return true
+		}
+		return ts >= target
+	}))
+	if searchErr != nil {
+		return 0, fmt.Errorf("failed to find the entry logged at %s: %w", t.Format(time.RFC3339), searchErr)
+	}
+	return index, nil
+}
+
+type backfillJob struct {
+	log        *ctLog
+	fetcher    logFetcher
+	checkpoint *backfillCheckpoint
+
+	// set by runBackfill once the scan has stopped
+	stats    *logStats
+	finished int64
+	failed   int64
+	total    int64
+}
+
+// runBackfill scans the checkpoint's range with parallel workers, each
+// fetching whole chunks and passing entries through processEntry. Failed
+// chunks are retried with the same backoff as log workers and left out of
+// the checkpoint when they keep failing, so running the scan again retries
+// them.
+func (m *CTMonitor) runBackfill(job *backfillJob) {
+	defer m.closeOnce.Do(func() { close(m.entryChan) })
+
+	cp := job.checkpoint
+	w := &logWorker{log: job.log, fetcher: job.fetcher, stats: registerLogStats(job.log, job.fetcher.url())}
+	w.stats.active.Store(true)
+	defer w.stats.active.Store(false)
+
+	// position counts finished entries from the start of the range, so the
+	// lag reported by status is what is left to scan
+	position := cp.Start
+	done := make(map[int64]bool)
+	for _, chunk := range cp.Done {
+		done[chunk] = true
+		position += min(chunk+cp.Chunk, cp.End) - chunk
+	}
+	w.stats.treeSize.Store(cp.End)
+	w.stats.next.Store(position)
+	var chunks []int64
+	for start := cp.Start; start < cp.End; start += cp.Chunk {
+		if !done[start] {
+			chunks = append(chunks, start)
+		}
+	}
+	total := (cp.End - cp.Start + cp.Chunk - 1) / cp.Chunk
+	logger.Info("backfilling CT log", "log", job.log.Description, "from", cp.Start, "to", cp.End, "chunks", len(chunks), "already_done", len(cp.Done))
+
+	queue := make(chan int64)
+	var finished, failed atomic.Int64
+	finished.Store(int64(len(cp.Done)))
+
+	var wg sync.WaitGroup
+	for i := 0; i < backfillFlags.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for start := range queue {
+				end := min(start+cp.Chunk, cp.End)
+				if m.backfillChunk(w, start, end) {
+					finished.Add(1)
+					w.stats.next.Add(end - start)
+					cp.finish(start)
+				} else if m.ctx.Err() == nil {
+					failed.Add(1)
+				}
+			}
+		}()
+	}
+
+	progress := time.NewTicker(backfillProgressEvery)
+	defer progress.Stop()
+	started := time.Now()
+	startFinished := finished.Load()
+feed:
+	for _, start := range chunks {
+		for {
+			select {
+			case queue <- start:
+				continue feed
+			case <-m.ctx.Done():
+				break feed
+			case <-progress.C:
+				logBackfillProgress(finished.Load(), startFinished, total, started, w.stats)
+			}
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	job.stats, job.finished, job.failed, job.total = w.stats, finished.Load(), failed.Load(), total
+	if m.ctx.Err() == nil && job.failed == 0 {
+		os.Remove(cp.path)
+	}
+}
+
+// backfillChunk fetches [start, end) and reports whether it was completed.
+// Entries may arrive out of order, so a failed chunk is fetched again from
+// its start.
+func (m *CTMonitor) backfillChunk(w *logWorker, start, end int64) bool {
+	for attempt := 1; ; attempt++ {
+		err := w.fetcher.fetch(m.ctx, start, end, func(entry rawEntry) {
+			m.processEntry(w, entry)
+			w.stats.entries.Add(1)
+			w.stats.lastEntry.Store(time.Now().UnixNano())
+		})
+		if err == nil || m.ctx.Err() != nil {
+			return err == nil
+		}
+		w.stats.restarts.Add(1)
+		if attempt >= degradedAfter {
+			logger.Error("giving up on backfill chunk", "from", start, "to", end, "error", err)
+			return false
+		}
+
+		delay := restartBackoff(attempt)
+		logger.Warn("backfill chunk failed, retrying", "from", start, "to", end, "error", err, "attempt", attempt, "retry_in", delay.Round(time.Second))
+		select {
+		case <-m.ctx.Done():
+			return false
+		case <-time.After(delay):
+		}
+	}
+}
+
+func logBackfillProgress(finished, startFinished, total int64, started time.Time, stats *logStats) {
+	elapsed := time.Since(started)
+	args := []interface{}{"chunks", fmt.Sprintf("%d/%d", finished, total), "entries", stats.entries.Load(), "matches", stats.matches.Load()}
+	if rate := float64(finished-startFinished) / elapsed.Seconds(); rate > 0 {
+		eta := time.Duration(float64(total-finished) / rate * float64(time.Second))
+		args = append(args, "eta", eta.Round(time.Second))
+	}
+	logger.Info("backfill progress", args...)
+}
+
+func runBackfill(args []string) {
+	if backfillFlags.log == "" || backfillFlags.from == "" {
+		logger.Fatal("-log and -from are required")
+	}
+	if backfillFlags.workers < 1 || backfillFlags.chunk < 1 {
+		logger.Fatal("-workers and -chunk must be at least 1")
+	}
+
+	// chunks are checkpointed as done, so none of their entries may be dropped
+	dropPolicy = dropPolicyBlock
+	setupProfiles(false)
+
+	monitor := NewCTMonitor()
+	monitor.continuous = false
+	ctx := monitor.ctx
+
+	// entries already queued are still matched after an interrupt, since
+	// their chunks may be recorded as finished
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		logger.Info("shutting down...")
+		monitor.cancel()
+	}()
+
+	l := findBackfillLog(backfillFlags.log)
+	fetcher, err := newLogFetcher(l)
+	if err != nil {
+		logger.Fatal("failed to create log client", "log", l.Description, "error", err)
+	}
+
+	size, err := fetcher.treeSize(ctx)
+	if err != nil {
+		logger.Fatal("failed to get tree size", "log", l.Description, "error", err)
+	}
+	start, err := resolveLogIndex(ctx, fetcher, backfillFlags.from, size)
+	if err != nil {
+		logger.Fatal("invalid -from", "error", err)
+	}
+	end := int64(-1)
+	if backfillFlags.to != "" {
+		if end, err = resolveLogIndex(ctx, fetcher, backfillFlags.to, size); err != nil {
+			logger.Fatal("invalid -to", "error", err)
+		}
+	}
+
+	path, err := backfillCheckpointPath(l.URL, start, end)
+	if err != nil {
+		logger.Fatal("failed to locate backfill checkpoint", "error", err)
+	}
+	cp, err := loadBackfillCheckpoint(path, l.URL, start, end)
+	if err != nil {
+		logger.Fatal("failed to load backfill checkpoint", "error", err)
+	}
+	if cp != nil {
+		logger.Info("resuming backfill from checkpoint", "path", path, "finished_chunks", len(cp.Done))
+	} else {
+		cp = &backfillCheckpoint{Log: l.URL, Start: start, End: end, Head: end < 0, Chunk: backfillFlags.chunk, path: path}
+		if cp.Head {
+			cp.End = size
+		}
+		if cp.Start >= cp.End {
+			logger.Fatal("nothing to scan: -from is not before -to", "from", cp.Start, "to", cp.End)
+		}
+	}
+
+	job := &backfillJob{log: l, fetcher: fetcher, checkpoint: cp}
+	started := time.Now()
+	go monitor.runBackfill(job)
+	go newStatusReporter().run(ctx, false)
+	defer closeOutput()
+	defer closeHistory()
+	defer closeRecorder()
+	defer monitor.cancel()
+
+	for entry := range monitor.entryChan {
+		processEntry(entry)
+	}
+
+	switch {
+	case ctx.Err() != nil:
+		logger.Warn("backfill interrupted; run the same command again to resume", "finished_chunks", job.finished, "chunks", job.total, "checkpoint", cp.path)
+	case job.failed > 0:
+		logger.Error("backfill finished with failed chunks; run the same command again to retry them", "failed_chunks", job.failed, "checkpoint", cp.path)
+	default:
+		logger.Info("backfill complete", "entries", job.stats.entries.Load(), "matches", job.stats.matches.Load(), "elapsed", time.Since(started).Round(time.Second))
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// errFetcher is a log that cannot be read.
+type errFetcher struct{ fakeFetcher }
+
+func (f *errFetcher) fetch(context.Context, int64, int64, func(rawEntry)) error {
+	return errors.New("connection refused")
+}
+
+func TestResolveLogIndex(t *testing.T) {
+	first := time.Date(2025, 6, 10, 0, 0, 0, 0, time.UTC)
+	log := &fakeFetcher{logURL: "https://ct.example/log/"}
+	for i := range 10 {
+		ts := first.Add(time.Duration(i) * time.Hour)
+		log.entries = append(log.entries, rawEntry{index: int64(i), timestamp: uint64(ts.UnixMilli())})
+	}
+	size := int64(len(log.entries))
+	gap := &fakeFetcher{entries: []rawEntry{{index: 0}, {index: 0}, {index: 0}}}
+
+	tests := []struct {
+		name    string
+		fetcher logFetcher
+		value   string
+		want    int64
+		wantErr string
+	}{
+		{name: "date before the first entry", fetcher: log, value: "2025-06-01", want: 0},
+		{name: "date after the last entry", fetcher: log, value: "2025-07-01", want: size},
+		{name: "exact timestamp", fetcher: log, value: first.Add(3 * time.Hour).Format(time.RFC3339), want: 3},
+		{name: "between entries", fetcher: log, value: first.Add(3*time.Hour + time.Minute).Format(time.RFC3339), want: 4},
+		{name: "first entry", fetcher: log, value: first.Format(time.RFC3339), want: 0},
+		{name: "index", fetcher: log, value: "7", want: 7},
+		{name: "tree size", fetcher: log, value: "10", want: size},
+		{name: "index past the end", fetcher: log, value: "11", wantErr: "index 11 is outside the log, which has 10 entries"},
+		{name: "negative index", fetcher: log, value: "-1", wantErr: "index -1 is outside the log"},
+		{name: "invalid", fetcher: log, value: "yesterday", wantErr: `invalid time "yesterday"`},
+		{name: "unreadable log", fetcher: &errFetcher{}, value: "2025-06-01", wantErr: "connection refused"},
+		{name: "missing entry", fetcher: gap, value: "2025-06-01", wantErr: "log did not return entry"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := size
+			if f, ok := tt.fetcher.(*fakeFetcher); ok {
+				s = int64(len(f.entries))
+			}
+			got, err := resolveLogIndex(context.Background(), tt.fetcher, tt.value, s)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %d, %v, want error %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got index %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackfillCheckpointPath(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	path := func(logURL string, start, end int64) string {
+		t.Helper()
+		p, err := backfillCheckpointPath(logURL, start, end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	base := path("https://ct.example/log/", 100, 200)
+	if got := path("ct.example/LOG", 100, 200); got != base {
+		t.Errorf("the same log spelled differently has checkpoint %s, want %s", got, base)
+	}
+	for _, other := range []string{
+		path("https://ct.example/log/", 100, 300),
+		path("https://ct.example/log/", 150, 200),
+		path("https://ct.example/log/", 100, -1),
+		path("https://ct.example/other/", 100, 200),
+	} {
+		if other == base {
+			t.Errorf("different scans share checkpoint %s", base)
+		}
+	}
+
+	backfillFlags.checkpoint = "custom.json"
+	t.Cleanup(func() { backfillFlags.checkpoint = "" })
+	if got := path("https://ct.example/log/", 100, 200); got != "custom.json" {
+		t.Errorf("-checkpoint gave %s, want custom.json", got)
+	}
+}
+
+func TestLoadBackfillCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	const logURL = "https://ct.example/log/"
+
+	save := func(name string, cp *backfillCheckpoint) string {
+		t.Helper()
+		cp.path = dir + "/" + name
+		cp.finish(2)
+		cp.finish(0)
+		return cp.path
+	}
+	fixed := save("fixed.json", &backfillCheckpoint{Log: logURL, Start: 100, End: 200, Chunk: 25})
+	head := save("head.json", &backfillCheckpoint{Log: logURL, Start: 100, End: 500, Head: true, Chunk: 25})
+
+	tests := []struct {
+		name     string
+		path     string
+		logURL   string
+		start    int64
+		end      int64
+		wantNone bool
+		wantEnd  int64
+		wantErr  string
+	}{
+		{name: "no checkpoint", path: dir + "/missing.json", logURL: logURL, start: 100, end: 200, wantNone: true},
+		{name: "same range", path: fixed, logURL: "ct.example/log", start: 100, end: 200, wantEnd: 200},
+		{name: "to the tree head", path: head, logURL: logURL, start: 100, end: -1, wantEnd: 500},
+		{name: "other start", path: fixed, logURL: logURL, start: 101, end: 200, wantErr: "belongs to a scan of https://ct.example/log/ from 100 to 200"},
+		{name: "other end", path: fixed, logURL: logURL, start: 100, end: 300, wantErr: "belongs to a scan"},
+		{name: "fixed end for a head scan", path: fixed, logURL: logURL, start: 100, end: -1, wantErr: "belongs to a scan"},
+		{name: "head scan for a fixed end", path: head, logURL: logURL, start: 100, end: 500, wantErr: "belongs to a scan"},
+		{name: "other log", path: fixed, logURL: "https://ct.example/other/", start: 100, end: 200, wantErr: "belongs to a scan"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cp, err := loadBackfillCheckpoint(tt.path, tt.logURL, tt.start, tt.end)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want error %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantNone {
+				if cp != nil {
+					t.Fatalf("got checkpoint %+v, want none", cp)
+				}
+				return
+			}
+			if cp.End != tt.wantEnd || cp.Chunk != 25 || cp.path != tt.path {
+				t.Errorf("got end %d chunk %d path %s, want end %d chunk 25 path %s", cp.End, cp.Chunk, cp.path, tt.wantEnd, tt.path)
+			}
+			if len(cp.Done) != 2 || cp.Done[0] != 0 || cp.Done[1] != 2 {
+				t.Errorf("finished chunks %v, want [0 2]", cp.Done)
+			}
+		})
+	}
+}
@@ -2,36 +2,99 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
+	"math/rand/v2"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	ct "github.com/google/certificate-transparency-go"
-	"github.com/google/certificate-transparency-go/client"
-	"github.com/google/certificate-transparency-go/jsonclient"
-	"github.com/google/certificate-transparency-go/loglist3"
-	"github.com/google/certificate-transparency-go/scanner"
 	"github.com/google/certificate-transparency-go/x509"
+	"k8s.io/klog/v2"
 )
 
 type CertEntry struct {
-	Domains   []string
-	NotBefore time.Time
-	NotAfter  time.Time
-	Issuer    string
-	LogURL    string
+	Domains            []string
+	NotBefore          time.Time
+	NotAfter           time.Time
+	Issuer             string
+	LogURL             string
+	SCTs               []SCT
+	SerialNumber       string
+	Fingerprint        string
+	Subject            string
+	IssuerDN           string
+	IssuerOrg          string
+	KeyAlgorithm       string
+	KeySize            int
+	SignatureAlgorithm string
+	IPAddresses        []string
+	EmailAddresses     []string
+	URIs               []string
+	EntryType          string
+	LogIndex           int64
+	LogTimestamp       time.Time
+	Validation         string
+
+	cert   *x509.Certificate
+	der    []byte
+	issuer []byte
 }
 
 type CTMonitor struct {
-	entryChan chan CertEntry
-	ctx       context.Context
-	cancel    context.CancelFunc
-	wg        sync.WaitGroup
+	entryChan  chan CertEntry
+	ctx        context.Context
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+	closeOnce  sync.Once
+	backfill   int64
+	continuous bool
+	started    time.Time
+	mu         sync.Mutex
+	logListErr error
+	logCount   int
+}
+
+const (
+	restartMinBackoff = 5 * time.Second
+	restartMaxBackoff = 10 * time.Minute
+	degradedAfter     = 5
+	defaultBufferSize = 5000
+	dropWarnInterval  = 30 * time.Second
+	treeSizeInterval  = time.Minute
+)
+
+const (
+	dropPolicyBlock = "block"
+	dropPolicyDrop  = "drop"
+)
+
+var (
+	bufferFlag     int
+	dropPolicyFlag string
+	bufferSize     = defaultBufferSize
+	dropPolicy     = dropPolicyDrop
+)
+
+// logWorker is the supervised state of a single monitored log.
+type logWorker struct {
+	log      *ctLog
+	fetcher  logFetcher
+	stats    *logStats
+	end      int64
+	failures int
+	lastWarn atomic.Int64
+	warned   atomic.Int64
+
+	// entries processed ahead of stats.next while earlier batches of a
+	// parallel fetch are still in flight
+	mu      sync.Mutex
+	pending map[int64]bool
 }
 
 func NewCTMonitor() *CTMonitor {
@@ -39,11 +102,17 @@ func NewCTMonitor() *CTMonitor {
 	
 	log.SetOutput(io.Discard)
 	log.SetFlags(0)
+	// the CT scanner logs through klog, e.g. cancelled fetches on shutdown
+	klog.LogToStderr(false)
+	klog.SetOutput(io.Discard)
 	
 	return &CTMonitor{
-		entryChan: make(chan CertEntry, 5000),
-		ctx:       ctx,
-		cancel:    cancel,
+		entryChan:  make(chan CertEntry, bufferSize),
+		ctx:        ctx,
+		cancel:     cancel,
+		backfill:   1000,
+		continuous: true,
+		started:    time.Now(),
 	}
 }
 
@@ -55,139 +124,286 @@ func (m *CTMonitor) Start() <-chan CertEntry {
 func (m *CTMonitor) Stop() {
 	m.cancel()
 	m.wg.Wait()
-	close(m.entryChan)
+	m.closeOnce.Do(func() { close(m.entryChan) })
 }
 
 func (m *CTMonitor) run() {
-	logs, err := fetchLogList()
-	if err != nil {
-		logger.Error("failed to fetch CT log list", "error", err)
-		return
+	var logs []*ctLog
+	for {
+		var err error
+		logs, err = fetchLogList()
+		m.mu.Lock()
+		m.logListErr = err
+		m.logCount = len(logs)
+		m.mu.Unlock()
+		if err == nil {
+			break
+		}
+		if !m.continuous {
+			logger.Error("failed to fetch CT log list", "error", err)
+			m.closeOnce.Do(func() { close(m.entryChan) })
+			return
+		}
+		logger.Error("failed to fetch CT log list", "error", err, "retry_in", logListRetry)
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-time.After(logListRetry):
+		}
 	}
 
-	logger.Info("fetched CT log list", "usable_logs", len(logs))
+	logger.Info("fetched CT log list", "selected_logs", len(logs))
 
 	for _, logInfo := range logs {
 		m.wg.Add(1)
 		go m.monitorLog(logInfo)
 	}
+
+	if !m.continuous {
+		m.wg.Wait()
+		m.closeOnce.Do(func() { close(m.entryChan) })
+	}
 }
 
-func fetchLogList() ([]*loglist3.Log, error) {
-	resp, err := http.Get(loglist3.LogListURL)
+func fetchLogList() ([]*ctLog, error) {
+	ll, err := loadLogList()
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch log list: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
+	selected, err := selectLogs(ll)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read log list: %w", err)
+		return nil, err
 	}
+	registerSCTLogs(allLogs(ll))
+	registerSCTLogs(selected)
+	return selected, nil
+}
 
-	var ll loglist3.LogList
-	if err := json.Unmarshal(body, &ll); err != nil {
-		return nil, fmt.Errorf("failed to parse log list: %w", err)
+// monitorLog supervises the worker for a single log, restarting it with
+// jittered exponential backoff from the last processed index when it fails.
+func (m *CTMonitor) monitorLog(logInfo *ctLog) {
+	defer m.wg.Done()
+
+	fetcher, err := newLogFetcher(logInfo)
+	if err != nil {
+		logger.Warn("failed to create log client", "log", logInfo.Description, "error", err)
+		return
 	}
 
-	var usableLogs []*loglist3.Log
-	for _, op := range ll.Operators {
-		for _, log := range op.Logs {
-			if log.State != nil && log.State.Usable != nil {
-				usableLogs = append(usableLogs, log)
-			}
+	w := &logWorker{log: logInfo, fetcher: fetcher, stats: registerLogStats(logInfo, fetcher.url())}
+
+	defer func() {
+		if total := w.stats.dropped.Load(); total > w.warned.Load() {
+			logger.Warn("CT entries were dropped", "log", logInfo.Description, "total_dropped", total)
 		}
+	}()
+
+	logger.Debug("monitoring CT log", "from", logInfo.Description)
+
+	switch {
+	case m.continuous && verifySTH:
+		go m.auditLog(w)
+	case m.continuous:
+		go m.trackTreeSize(w)
 	}
 
-	return usableLogs, nil
-}
+	for {
+		before := w.stats.next.Load()
+		err := m.runWorker(w)
+		if m.ctx.Err() != nil || err == nil {
+			return
+		}
 
-func (m *CTMonitor) monitorLog(logInfo *loglist3.Log) {
-	defer m.wg.Done()
+		if w.stats.next.Load() != before {
+			w.failures = 0
+		}
+		w.failures++
+		w.stats.restarts.Add(1)
 
-	logURL := logInfo.URL
-	if !strings.HasPrefix(logURL, "https://") {
-		logURL = "https://" + logURL
-	}
-	logURL = strings.TrimSuffix(logURL, "/")
-	httpClient := &http.Client{Timeout: 180 * time.Second}
+		if w.failures >= degradedAfter && !w.stats.degraded.Swap(true) {
+			logger.Error("CT log degraded", "log", logInfo.Description, "failures", w.failures, "error", err)
+		}
+		if !m.continuous && w.failures >= degradedAfter {
+			return
+		}
 
-	logClient, err := client.New(logURL, httpClient, jsonclient.Options{})
-	if err != nil {
-		logger.Warn("failed to create log client", "log", logInfo.Description, "error", err)
-		return
+		delay := restartBackoff(w.failures)
+		logger.Warn("fetcher stopped, restarting", "log", logInfo.Description, "error", err, "attempt", w.failures, "retry_in", delay.Round(time.Second))
+
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
 	}
+}
 
-	sth, err := logClient.GetSTH(m.ctx)
+// runWorker fetches entries until the fetcher stops. The first run starts
+// backfill entries behind the head of the log; later runs resume after the
+// last processed entry.
+func (m *CTMonitor) runWorker(w *logWorker) error {
+	size, err := w.fetcher.treeSize(m.ctx)
 	if err != nil {
-		logger.Warn("failed to get STH", "log", logInfo.Description, "error", err)
-		return
+		return fmt.Errorf("failed to get STH: %w", err)
 	}
-
-	const backfill = 1000
-	start := int64(0)
-	if sth.TreeSize > backfill {
-		start = int64(sth.TreeSize - backfill)
+	w.stats.treeSize.Store(size)
+	w.stats.active.Store(true)
+	defer w.stats.active.Store(false)
+
+	start := w.stats.next.Load()
+	if start < 0 {
+		start = max(0, size-m.backfill)
+		if !m.continuous {
+			w.end = size
+		}
+		w.stats.next.Store(start)
 	}
+	w.mu.Lock()
+	w.pending = nil
+	w.mu.Unlock()
 
-	opts := scanner.FetcherOptions{
-		BatchSize:     1,
-		ParallelFetch: 1,
-		StartIndex:    start,
-		Continuous:    true,
-	}
+	return w.fetcher.fetch(m.ctx, start, w.end, func(entry rawEntry) {
+		if w.stats.degraded.Swap(false) {
+			logger.Info("CT log recovered", "log", w.log.Description)
+		}
+		m.processEntry(w, entry)
+		w.stats.entries.Add(1)
+		w.stats.lastEntry.Store(time.Now().UnixNano())
+		w.advance(entry.index)
+	})
+}
 
-	fetcher := scanner.NewFetcher(logClient, &opts)
+// advance marks an entry as processed and moves the resume point past every
+// entry processed without a gap, so a restart never skips entries that were
+// still being fetched by another batch.
+func (w *logWorker) advance(index int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-	logger.Debug("monitoring CT log", "from", logInfo.Description)
+	next := w.stats.next.Load()
+	switch {
+	case index < next:
+		return
+	case index > next:
+		if w.pending == nil {
+			w.pending = make(map[int64]bool)
+		}
+		w.pending[index] = true
+		return
+	}
+	for next++; w.pending[next]; next++ {
+		delete(w.pending, next)
+	}
+	w.stats.next.Store(next)
+}
 
-	err = fetcher.Run(m.ctx, func(batch scanner.EntryBatch) {
-		for i, entry := range batch.Entries {
-			m.processEntry(entry, batch.Start+int64(i), logURL)
+// trackTreeSize refreshes the tree size of a followed log so its lag can be
+// reported independently of how far the fetcher has got.
+func (m *CTMonitor) trackTreeSize(w *logWorker) {
+	ticker := time.NewTicker(treeSizeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+			if size, err := w.fetcher.treeSize(m.ctx); err == nil {
+				w.stats.treeSize.Store(size)
+			}
 		}
-	})
+	}
+}
 
-	if err != nil && m.ctx.Err() == nil {
-		logger.Warn("fetcher stopped", "log", logInfo.Description, "error", err)
+// restartBackoff returns the delay before the given consecutive restart:
+// exponential from restartMinBackoff, capped at restartMaxBackoff, with
+// the upper half randomised so failed workers do not retry in lockstep.
+func restartBackoff(failures int) time.Duration {
+	delay := restartMaxBackoff
+	if failures < 16 {
+		delay = min(restartMinBackoff<<(failures-1), restartMaxBackoff)
 	}
+	return delay/2 + rand.N(delay/2+1)
 }
 
-func (m *CTMonitor) processEntry(entry ct.LeafEntry, index int64, logURL string) {
-	rle, err := ct.RawLogEntryFromLeaf(index, &entry)
+func (m *CTMonitor) processEntry(w *logWorker, entry rawEntry) {
+	certEntry, err := newCertEntry(w.fetcher.url(), entry)
 	if err != nil {
+		w.stats.parseFailures.Add(1)
+		return
+	}
+	if len(certEntry.Domains) == 0 {
+		return
+	}
+	if recorder != nil {
+		recorder.write(w.fetcher.url(), entry)
+	}
+
+	if dropPolicy == dropPolicyBlock {
+		select {
+		case m.entryChan <- certEntry:
+		case <-m.ctx.Done():
+		}
 		return
 	}
 
+	select {
+	case m.entryChan <- certEntry:
+	default:
+		w.recordDrop()
+	}
+}
+
+// newCertEntry parses a raw log entry. Entries that are neither certificates
+// nor precertificates are returned empty, without domains.
+func newCertEntry(logURL string, entry rawEntry) (CertEntry, error) {
 	var cert *x509.Certificate
+	var err error
 
-	switch rle.Leaf.TimestampedEntry.EntryType {
+	switch entry.entryType {
 	case ct.X509LogEntryType:
-		cert, err = x509.ParseCertificate(rle.Cert.Data)
+		cert, err = x509.ParseCertificate(entry.cert)
 	case ct.PrecertLogEntryType:
-		cert, err = x509.ParseTBSCertificate(rle.Cert.Data)
+		cert, err = x509.ParseTBSCertificate(entry.cert)
 	default:
-		return
+		return CertEntry{}, nil
 	}
 
-	if err != nil {
-		return
-	}
-
-	domains := extractDomains(cert)
-	if len(domains) == 0 {
-		return
+	if x509.IsFatal(err) || cert == nil {
+		if err == nil {
+			err = errors.New("no certificate in entry")
+		}
+		return CertEntry{}, err
 	}
 
-	select {
-	case m.entryChan <- CertEntry{
-		Domains:   domains,
+	certEntry := CertEntry{
+		Domains:   extractDomains(cert),
 		NotBefore: cert.NotBefore,
 		NotAfter:  cert.NotAfter,
 		Issuer:    cert.Issuer.CommonName,
 		LogURL:    logURL,
-	}:
-	default:
+		der:       entry.der,
+	}
+	certEntry.setMetadata(cert, entry)
+	if entry.entryType == ct.X509LogEntryType {
+		certEntry.cert = cert
+		certEntry.issuer = entry.issuer
 	}
+	return certEntry, nil
+}
+
+// recordDrop counts an entry discarded because the buffer was full and warns
+// at most once per dropWarnInterval with the number dropped since then.
+func (w *logWorker) recordDrop() {
+	total := w.stats.dropped.Add(1)
+
+	now := time.Now().UnixNano()
+	last := w.lastWarn.Load()
+	if now-last < int64(dropWarnInterval) || !w.lastWarn.CompareAndSwap(last, now) {
+		return
+	}
+	since := total - w.warned.Swap(total)
+	logger.Warn("entry buffer full, dropping CT entries", "log", w.log.Description, "dropped", since, "total_dropped", total, "buffer", bufferSize)
 }
 
 func extractDomains(cert *x509.Certificate) []string {
@@ -209,7 +425,69 @@ func extractDomains(cert *x509.Certificate) []string {
 	return domains
 }
 
-func CertStreamEventStream() <-chan CertEntry {
-	monitor := NewCTMonitor()
-	return monitor.Start()
+func addBufferFlags(fs *flag.FlagSet) {
+	fs.IntVar(&bufferFlag, "buffer", 0, fmt.Sprintf("number of parsed entries buffered between the CT logs and matching (default: %d)", defaultBufferSize))
+	fs.StringVar(&dropPolicyFlag, "drop-policy", "", "what to do when the buffer is full: drop, block (slow the log readers down) (default: drop; block for search and backfill)")
+}
+
+// applyBufferConfig merges the entry buffer settings from the configuration
+// file with the command line flags, which take precedence.
+func applyBufferConfig(cfg *Config) error {
+	if cfg != nil {
+		if cfg.Buffer != 0 {
+			bufferSize = cfg.Buffer
+		}
+		if cfg.DropPolicy != "" {
+			dropPolicy = cfg.DropPolicy
+		}
+	}
+	if bufferFlag != 0 {
+		bufferSize = bufferFlag
+	}
+	if dropPolicyFlag != "" {
+		dropPolicy = dropPolicyFlag
+	}
+
+	dropPolicy = strings.ToLower(strings.TrimSpace(dropPolicy))
+	if dropPolicy != dropPolicyBlock && dropPolicy != dropPolicyDrop {
+		return fmt.Errorf("invalid drop policy %q. valid options are: block, drop", dropPolicy)
+	}
+	if bufferSize < 1 {
+		return fmt.Errorf("buffer size must be at least 1, got %d", bufferSize)
+	}
+	return nil
+}
+
+func runLogs(args []string) {
+	if configFlag != "" {
+		setConfigPath(configFlag)
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		logger.Fatal("failed to load config", "error", err)
+	}
+	applyLogListConfig(cfg)
+
+	logs, err := fetchLogList()
+	if err != nil {
+		logger.Fatal("failed to fetch CT log list", "error", err)
+	}
+
+	for _, l := range logs {
+		var details []string
+		api := "rfc6962"
+		if l.Tiled {
+			api = "static-ct"
+		}
+		for _, d := range []string{l.Operator, l.State, api} {
+			if d != "" {
+				details = append(details, d)
+			}
+		}
+		if l.Interval != nil {
+			details = append(details, l.Interval.StartInclusive.Format("2006-01-02")+" → "+l.Interval.EndExclusive.Format("2006-01-02"))
+		}
+		fmt.Printf("%s  %s  %s\n", cmdStyle.Render(l.Description), l.URL, argStyle.Render(strings.Join(details, ", ")))
+	}
+	logger.Info("selected logs", "count", len(logs))
 }
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ct "github.com/google/certificate-transparency-go"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	certstreamDialTimeout  = 30 * time.Second
+	certstreamPingInterval = 30 * time.Second
+	// certstreamReadTimeout is how long the connection may stay silent.
+	// Servers send a heartbeat every 30 seconds even when no certificates
+	// are issued, so missing a few means the connection is dead.
+	certstreamReadTimeout = 90 * time.Second
+	certstreamMaxMessage  = 4 << 20
+)
+
+// certstreamMessage is a message of the certstream protocol, as sent by
+// certstream-server and certstream-server-go.
+type certstreamMessage struct {
+	MessageType string          `json:"message_type"`
+	Data        json.RawMessage `json:"data"`
+}
+
+type certstreamUpdate struct {
+	UpdateType string         `json:"update_type"`
+	LeafCert   certstreamCert `json:"leaf_cert"`
+	Chain      []struct {
+		AsDER string `json:"as_der"`
+	} `json:"chain"`
+	CertIndex int64   `json:"cert_index"`
+	Seen      float64 `json:"seen"`
+	Source    struct {
+		URL  string `json:"url"`
+		Name string `json:"name"`
+	} `json:"source"`
+}
+
+type certstreamCert struct {
+	Subject            certstreamName `json:"subject"`
+	Issuer             certstreamName `json:"issuer"`
+	AllDomains         []string       `json:"all_domains"`
+	NotBefore          float64        `json:"not_before"`
+	NotAfter           float64        `json:"not_after"`
+	SerialNumber       string         `json:"serial_number"`
+	SHA256             string         `json:"sha256"`
+	SignatureAlgorithm string         `json:"signature_algorithm"`
+	AsDER              string         `json:"as_der"`
+}
+
+type certstreamName struct {
+	CN         string `json:"CN"`
+	O          string `json:"O"`
+	Aggregated string `json:"aggregated"`
+}
+
+// certstreamSource reads entries from a certstream server over WebSocket,
+// reconnecting with backoff when the connection fails. Servers on the full
+// stream send the certificate DER, which is parsed like a log entry; on the
+// lite stream the entry is built from the JSON fields.
+type certstreamSource struct {
+	url       string
+	entries   chan CertEntry
+	ctx       context.Context
+	cancel    context.CancelFunc
+	done      chan struct{}
+	started   time.Time
+	connected atomic.Bool
+	lastEntry atomic.Int64
+
+	mu      sync.Mutex
+	lastErr error
+	warned  bool
+}
+
+func newCertstreamSource(url string) *certstreamSource {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &certstreamSource{
+		url:     url,
+		entries: make(chan CertEntry, bufferSize),
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		started: time.Now(),
+	}
+}
+
+func (c *certstreamSource) Start() <-chan CertEntry {
+	go c.run()
+	return c.entries
+}
+
+func (c *certstreamSource) Stop() {
+	c.cancel()
+	<-c.done
+}
+
+func (c *certstreamSource) healthy() error {
+	if !c.connected.Load() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.lastErr != nil {
+			return fmt.Errorf("certstream server unavailable: %w", c.lastErr)
+		}
+	}
+	last := c.started
+	if t := time.Unix(0, c.lastEntry.Load()); t.After(last) {
+		last = t
+	}
+	if idle := time.Since(last); stallTimeout > 0 && idle > stallTimeout {
+		return fmt.Errorf("no entries processed for %s", idle.Round(time.Second))
+	}
+	return nil
+}
+
+func (c *certstreamSource) ready() error {
+	if !c.connected.Load() {
+		return errors.New("not connected to the certstream server")
+	}
+	return nil
+}
+
+func (c *certstreamSource) queue() (int, int) {
+	return len(c.entries), cap(c.entries)
+}
+
+func (c *certstreamSource) run() {
+	defer close(c.done)
+	defer close(c.entries)
+
+	failures := 0
+	for {
+		start := time.Now()
+		err := c.stream()
+		c.connected.Store(false)
+		if c.ctx.Err() != nil {
+			return
+		}
+
+		c.mu.Lock()
+		c.lastErr = err
+		c.mu.Unlock()
+
+		// a connection that lasted a while resets the backoff
+		if time.Since(start) > restartMinBackoff {
+			failures = 0
+		}
+		failures++
+		delay := restartBackoff(failures)
+		logger.Warn("certstream connection lost, reconnecting", "url", c.url, "error", err, "retry_in", delay.Round(time.Second))
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// stream reads messages until the connection fails or the source is stopped.
+func (c *certstreamSource) stream() error {
+	dialer := websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: certstreamDialTimeout,
+	}
+	header := http.Header{"User-Agent": []string{"crtmon/" + Version}}
+	conn, _, err := dialer.DialContext(c.ctx, c.url, header)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	c.connected.Store(true)
+	logger.Info("connected to certstream server", "url", c.url)
+
+	conn.SetReadLimit(certstreamMaxMessage)
+	extend := func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(certstreamReadTimeout))
+	}
+	extend("")
+	conn.SetPongHandler(extend)
+	conn.SetPingHandler(func(data string) error {
+		extend(data)
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(certstreamDialTimeout))
+	})
+
+	// pings keep proxies from closing an idle connection; closing the
+	// connection on stop unblocks the read below
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(certstreamPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.ctx.Done():
+				conn.Close()
+				return
+			case <-stop:
+				return
+			case <-ticker.C:
+				conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(certstreamDialTimeout))
+			}
+		}
+	}()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		extend("")
+
+		var msg certstreamMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			logger.Debug("ignoring invalid certstream message", "error", err)
+			continue
+		}
+		for _, entry := range c.parse(msg) {
+			c.lastEntry.Store(time.Now().UnixNano())
+			if dropPolicy == dropPolicyBlock {
+				select {
+				case c.entries <- entry:
+				case <-c.ctx.Done():
+					return nil
+				}
+				continue
+			}
+			select {
+			case c.entries <- entry:
+			default:
+				if stats := findLogStats(entry.LogURL); stats != nil {
+					stats.dropped.Add(1)
+				}
+			}
+		}
+	}
+}
+
+func (c *certstreamSource) parse(msg certstreamMessage) []CertEntry {
+	switch msg.MessageType {
+	case "certificate_update":
+		var update certstreamUpdate
+		if err := json.Unmarshal(msg.Data, &update); err != nil {
+			logger.Debug("ignoring invalid certstream update", "error", err)
+			return nil
+		}
+		entry, ok := c.certEntry(update)
+		if !ok {
+			return nil
+		}
+		return []CertEntry{entry}
+	case "dns_entries":
+		// the domains-only stream carries nothing but the names
+		var domains []string
+		if err := json.Unmarshal(msg.Data, &domains); err != nil || len(domains) == 0 {
+			return nil
+		}
+		return []CertEntry{{Domains: dedupDomains(domains), LogURL: c.url}}
+	}
+	// heartbeats only keep the connection alive
+	return nil
+}
+
+func (c *certstreamSource) certEntry(update certstreamUpdate) (CertEntry, bool) {
+	logURL := update.Source.URL
+	switch {
+	case logURL == "":
+		logURL = c.url
+	case !strings.Contains(logURL, "://"):
+		logURL = "https://" + logURL
+	}
+	stats := c.logStats(logURL, update.Source.Name)
+	stats.entries.Add(1)
+	stats.lastEntry.Store(time.Now().UnixNano())
+	if update.CertIndex+1 > stats.next.Load() {
+		stats.next.Store(update.CertIndex + 1)
+	}
+
+	raw := rawEntry{
+		index:     update.CertIndex,
+		timestamp: uint64(update.Seen * 1000),
+		entryType: ct.X509LogEntryType,
+	}
+	if update.UpdateType == "PrecertLogEntry" {
+		raw.entryType = ct.PrecertLogEntryType
+	}
+
+	if der, err := base64.StdEncoding.DecodeString(update.LeafCert.AsDER); err == nil && len(der) > 0 {
+		if entry, ok := c.parseDER(logURL, raw, der, update); ok {
+			return entry, len(entry.Domains) > 0
+		}
+		stats.parseFailures.Add(1)
+		return CertEntry{}, false
+	}
+
+	if recorder != nil {
+		c.mu.Lock()
+		if !c.warned {
+			c.warned = true
+			logger.Warn("the certstream server sends no certificates, nothing is recorded; use its full stream with -record", "url", c.url)
+		}
+		c.mu.Unlock()
+	}
+	return c.liteEntry(logURL, raw, update.LeafCert), len(update.LeafCert.AllDomains) > 0
+}
+
+// parseDER builds the entry from the certificate the full stream carries.
+// Precertificates come with the poison extension, so the log entry's TBS
+// is taken from the parsed certificate.
+func (c *certstreamSource) parseDER(logURL string, raw rawEntry, der []byte, update certstreamUpdate) (CertEntry, bool) {
+	raw.cert, raw.der = der, der
+	if len(update.Chain) > 0 {
+		raw.issuer, _ = base64.StdEncoding.DecodeString(update.Chain[0].AsDER)
+	}
+	if raw.entryType == ct.PrecertLogEntryType {
+		cert, err := x509.ParseCertificate(der)
+		if x509.IsFatal(err) || cert == nil {
+			return CertEntry{}, false
+		}
+		raw.cert = cert.RawTBSCertificate
+	}
+
+	entry, err := newCertEntry(logURL, raw)
+	if err != nil {
+		return CertEntry{}, false
+	}
+	if recorder != nil && len(entry.Domains) > 0 {
+		recorder.write(logURL, raw)
+	}
+	return entry, true
+}
+
+func (c *certstreamSource) liteEntry(logURL string, raw rawEntry, leaf certstreamCert) CertEntry {
+	entry := CertEntry{
+		Domains:            dedupDomains(leaf.AllDomains),
+		NotBefore:          time.Unix(int64(leaf.NotBefore), 0).UTC(),
+		NotAfter:           time.Unix(int64(leaf.NotAfter), 0).UTC(),
+		Issuer:             leaf.Issuer.CN,
+		IssuerDN:           aggregatedDN(leaf.Issuer.Aggregated),
+		IssuerOrg:          leaf.Issuer.O,
+		Subject:            aggregatedDN(leaf.Subject.Aggregated),
+		SerialNumber:       serialHex(leaf.SerialNumber),
+		Fingerprint:        strings.ToLower(strings.ReplaceAll(leaf.SHA256, ":", "")),
+		SignatureAlgorithm: leaf.SignatureAlgorithm,
+		EntryType:          entryTypeCert,
+		LogURL:             logURL,
+		LogIndex:           raw.index,
+		LogTimestamp:       time.UnixMilli(int64(raw.timestamp)).UTC(),
+	}
+	if raw.entryType == ct.PrecertLogEntryType {
+		entry.EntryType = entryTypePrecert
+	}
+	return entry
+}
+
+// serialHex normalizes certstream's upper case serial to the hex of its
+// bytes, as used for parsed certificates, so that "00AB12" and "0ABC" become
+// "ab12" and "0abc".
+func serialHex(serial string) string {
+	serial = strings.ToLower(strings.TrimLeft(serial, "0"))
+	if len(serial)%2 == 1 {
+		serial = "0" + serial
+	}
+	return serial
+}
+
+// aggregatedDN turns certstream's "/C=US/O=Org/CN=Name" into the RFC 2253
+// order used for parsed certificates, "CN=Name,O=Org,C=US".
+func aggregatedDN(dn string) string {
+	parts := strings.Split(strings.TrimPrefix(dn, "/"), "/")
+	slices.Reverse(parts)
+	return strings.Join(parts, ",")
+}
+
+// dedupDomains drops empty and repeated names, keeping the first occurrence.
+func dedupDomains(domains []string) []string {
+	seen := make(map[string]bool, len(domains))
+	var unique []string
+	for _, d := range domains {
+		if d != "" && !seen[d] {
+			seen[d] = true
+			unique = append(unique, d)
+		}
+	}
+	return unique
+}
+
+// logStats returns the counters of the log an update came from, so status
+// and metrics show the logs behind the certstream server.
+func (c *certstreamSource) logStats(logURL, name string) *logStats {
+	if stats := findLogStats(logURL); stats != nil {
+		return stats
+	}
+	if name == "" {
+		name = logURL
+	}
+	stats := registerLogStats(&ctLog{Description: name}, logURL)
+	stats.active.Store(true)
+	return stats
+}
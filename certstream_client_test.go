@@ -0,0 +1,271 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAggregatedDN(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"/C=US/O=Let's Encrypt/CN=R11", "CN=R11,O=Let's Encrypt,C=US"},
+		{"/CN=example.com", "CN=example.com"},
+		{"CN=no leading slash", "CN=no leading slash"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := aggregatedDN(tt.in); got != tt.want {
+			t.Errorf("aggregatedDN(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestCertstreamDNRoundTrip checks that serve turns names back into the
+// aggregate the client reads.
+func TestCertstreamDNRoundTrip(t *testing.T) {
+	tests := []string{
+		"CN=R11,O=Let's Encrypt,C=US",
+		"CN=example.com",
+	}
+	for _, dn := range tests {
+		fields := certstreamDN(dn)
+		aggregated, _ := fields["aggregated"].(string)
+		if got := aggregatedDN(aggregated); got != dn {
+			t.Errorf("aggregatedDN(certstreamDN(%q)) = %q via %q", dn, got, aggregated)
+		}
+	}
+}
+
+func TestSerialHex(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"00AB12", "ab12"},
+		{"0ABC", "0abc"},
+		{"ABC", "0abc"},
+		{"03E8", "03e8"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := serialHex(tt.in); got != tt.want {
+			t.Errorf("serialHex(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDedupDomains(t *testing.T) {
+	tests := []struct {
+		in   []string
+		want []string
+	}{
+		{nil, nil},
+		{[]string{"a.com", "", "b.com", "a.com", "b.com"}, []string{"a.com", "b.com"}},
+		{[]string{"*.a.com", "a.com"}, []string{"*.a.com", "a.com"}},
+		{[]string{""}, nil},
+	}
+	for _, tt := range tests {
+		if got := dedupDomains(tt.in); strings.Join(got, ",") != strings.Join(tt.want, ",") || len(got) != len(tt.want) {
+			t.Errorf("dedupDomains(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func testCertDER(t *testing.T, names ...string) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(0x0abc),
+		Subject:      pkix.Name{CommonName: names[0]},
+		Issuer:       pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2025, 8, 30, 0, 0, 0, 0, time.UTC),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func certstreamUpdateMessage(t *testing.T, update map[string]any) certstreamMessage {
+	t.Helper()
+	data, err := json.Marshal(update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certstreamMessage{MessageType: "certificate_update", Data: data}
+}
+
+func TestCertstreamParse(t *testing.T) {
+	source := newCertstreamSource("wss://certstream.example.com/")
+	der := testCertDER(t, "api.example.com", "www.example.com")
+
+	lite := map[string]any{
+		"update_type": "X509LogEntry",
+		"cert_index":  41,
+		"seen":        1748736000.5,
+		"source":      map[string]any{"url": "ct.example.com/log/", "name": "Example log"},
+		"leaf_cert": map[string]any{
+			"subject":       map[string]any{"CN": "example.com", "aggregated": "/CN=example.com"},
+			"issuer":        map[string]any{"CN": "R11", "O": "Let's Encrypt", "aggregated": "/C=US/O=Let's Encrypt/CN=R11"},
+			"all_domains":   []string{"example.com", "www.example.com", "example.com"},
+			"not_before":    1748736000,
+			"not_after":     1756512000,
+			"serial_number": "00AB12",
+			"sha256":        "AA:BB:CC",
+		},
+	}
+	full := map[string]any{
+		"update_type": "X509LogEntry",
+		"cert_index":  7,
+		"source":      map[string]any{"url": "https://ct.example.com/other/"},
+		"leaf_cert":   map[string]any{"as_der": base64.StdEncoding.EncodeToString(der)},
+	}
+	noDomains := map[string]any{
+		"update_type": "X509LogEntry",
+		"leaf_cert":   map[string]any{"all_domains": []string{}},
+	}
+	badDER := map[string]any{
+		"update_type": "X509LogEntry",
+		"leaf_cert":   map[string]any{"as_der": base64.StdEncoding.EncodeToString([]byte("not a certificate"))},
+	}
+
+	tests := []struct {
+		name  string
+		msg   certstreamMessage
+		check func(t *testing.T, entries []CertEntry)
+	}{
+		{
+			name: "lite update",
+			msg:  certstreamUpdateMessage(t, lite),
+			check: func(t *testing.T, entries []CertEntry) {
+				e := entries[0]
+				if strings.Join(e.Domains, ",") != "example.com,www.example.com" {
+					t.Errorf("domains = %q", e.Domains)
+				}
+				if e.Issuer != "R11" || e.IssuerOrg != "Let's Encrypt" || e.IssuerDN != "CN=R11,O=Let's Encrypt,C=US" || e.Subject != "CN=example.com" {
+					t.Errorf("names = %q %q %q %q", e.Issuer, e.IssuerOrg, e.IssuerDN, e.Subject)
+				}
+				if e.SerialNumber != "ab12" || e.Fingerprint != "aabbcc" {
+					t.Errorf("serial %q, fingerprint %q", e.SerialNumber, e.Fingerprint)
+				}
+				if e.LogURL != "https://ct.example.com/log/" || e.LogIndex != 41 || e.LogTimestamp.UnixMilli() != 1748736000500 {
+					t.Errorf("log = %s %d %s", e.LogURL, e.LogIndex, e.LogTimestamp)
+				}
+				if !e.NotBefore.Equal(time.Unix(1748736000, 0)) || e.EntryType != entryTypeCert {
+					t.Errorf("not before %s, type %s", e.NotBefore, e.EntryType)
+				}
+				if stats := findLogStats("https://ct.example.com/log/"); stats == nil || stats.description != "Example log" {
+					t.Errorf("log stats = %+v", stats)
+				}
+			},
+		},
+		{
+			name: "full update",
+			msg:  certstreamUpdateMessage(t, full),
+			check: func(t *testing.T, entries []CertEntry) {
+				e := entries[0]
+				if strings.Join(e.Domains, ",") != "api.example.com,www.example.com" || e.Issuer != "api.example.com" {
+					t.Errorf("entry = %q issued by %q", e.Domains, e.Issuer)
+				}
+				if e.SerialNumber != "0abc" || e.LogIndex != 7 || e.LogURL != "https://ct.example.com/other/" {
+					t.Errorf("serial %q, index %d, log %s", e.SerialNumber, e.LogIndex, e.LogURL)
+				}
+			},
+		},
+		{
+			name: "dns entries",
+			msg:  certstreamMessage{MessageType: "dns_entries", Data: json.RawMessage(`["a.example.com","a.example.com","b.example.com"]`)},
+			check: func(t *testing.T, entries []CertEntry) {
+				if strings.Join(entries[0].Domains, ",") != "a.example.com,b.example.com" || entries[0].LogURL != source.url {
+					t.Errorf("entry = %+v", entries[0])
+				}
+			},
+		},
+		{name: "update without domains", msg: certstreamUpdateMessage(t, noDomains)},
+		{name: "unparsable certificate", msg: certstreamUpdateMessage(t, badDER)},
+		{name: "invalid update", msg: certstreamMessage{MessageType: "certificate_update", Data: json.RawMessage(`{"cert_index":"x"}`)}},
+		{name: "empty dns entries", msg: certstreamMessage{MessageType: "dns_entries", Data: json.RawMessage(`[]`)}},
+		{name: "heartbeat", msg: certstreamMessage{MessageType: "heartbeat"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := source.parse(tt.msg)
+			if tt.check == nil {
+				if len(entries) != 0 {
+					t.Fatalf("parse() = %+v, want nothing", entries)
+				}
+				return
+			}
+			if len(entries) != 1 {
+				t.Fatalf("parse() returned %d entries, want 1", len(entries))
+			}
+			tt.check(t, entries)
+		})
+	}
+}
+
+// TestCertstreamHistory records matches from updates without a sha256 and
+// from the domains-only stream, and checks that each keeps its own
+// certificate in the history database.
+func TestCertstreamHistory(t *testing.T) {
+	source := newCertstreamSource("wss://certstream.example.com/")
+	update := func(cn, org, serial, domain string) certstreamMessage {
+		return certstreamUpdateMessage(t, map[string]any{
+			"update_type": "X509LogEntry",
+			"source":      map[string]any{"url": "ct.example.com/log/"},
+			"leaf_cert": map[string]any{
+				"issuer":        map[string]any{"CN": cn, "O": org, "aggregated": "/O=" + org + "/CN=" + cn},
+				"all_domains":   []string{domain},
+				"serial_number": serial,
+			},
+		})
+	}
+	messages := []certstreamMessage{
+		update("R11", "Let's Encrypt", "01", "a.example.com"),
+		update("WR1", "Google Trust Services", "02", "b.example.com"),
+		{MessageType: "dns_entries", Data: json.RawMessage(`["c.example.com"]`)},
+		{MessageType: "dns_entries", Data: json.RawMessage(`["d.example.com"]`)},
+	}
+
+	db := openTestHistory(t)
+	var batch []historyRecord
+	for _, msg := range messages {
+		for _, entry := range source.parse(msg) {
+			if entry.Fingerprint != "" {
+				t.Fatalf("entry has fingerprint %q", entry.Fingerprint)
+			}
+			batch = append(batch, historyRecord{profile: defaultProfile, target: "example", domain: entry.Domains[0], entry: entry, observed: time.Now()})
+		}
+	}
+	if err := db.write(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	sightings, err := db.sightings("", "", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range sightings {
+		got = append(got, s.Domain+"="+issuerName(s)+"/"+s.SerialNumber)
+	}
+	want := "a.example.com=Let's Encrypt/01,b.example.com=Google Trust Services/02,c.example.com=/,d.example.com=/"
+	if strings.Join(got, ",") != want {
+		t.Errorf("sightings = %s, want %s", strings.Join(got, ","), want)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	ct "github.com/google/certificate-transparency-go"
+)
+
+// fakeFetcher serves entries from memory in place of a CT log.
+type fakeFetcher struct {
+	logURL  string
+	entries []rawEntry
+}
+
+func (f *fakeFetcher) url() string { return f.logURL }
+
+func (f *fakeFetcher) treeSize(context.Context) (int64, error) {
+	return int64(len(f.entries)), nil
+}
+
+func (f *fakeFetcher) fetch(ctx context.Context, start, end int64, fn func(rawEntry)) error {
+	if end == 0 || end > int64(len(f.entries)) {
+		end = int64(len(f.entries))
+	}
+	for i := start; i < end; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		fn(f.entries[i])
+	}
+	return nil
+}
+
+// testCertificate returns a self-signed DER certificate for the domains.
+func testCertificate(t *testing.T, domains ...string) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: domains[0]},
+		DNSNames:     domains,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func testWorker(logURL string) *logWorker {
+	return &logWorker{log: &ctLog{Description: logURL}, fetcher: &fakeFetcher{logURL: logURL}, stats: &logStats{}}
+}
+
+func TestLogWorkerAdvance(t *testing.T) {
+	tests := []struct {
+		name      string
+		start     int64
+		completed []int64
+		want      []int64
+	}{
+		{name: "in order", start: 10, completed: []int64{10, 11, 12}, want: []int64{11, 12, 13}},
+		{name: "out of order", start: 10, completed: []int64{12, 11, 10}, want: []int64{10, 10, 13}},
+		{name: "gap stays open", start: 10, completed: []int64{11, 13, 14}, want: []int64{10, 10, 10}},
+		{name: "gap filled", start: 10, completed: []int64{11, 13, 10, 12}, want: []int64{10, 10, 12, 14}},
+		{name: "later batch first", start: 0, completed: []int64{4, 5, 6, 7, 0, 1, 2, 3}, want: []int64{0, 0, 0, 0, 1, 2, 3, 8}},
+		{name: "below resume point", start: 10, completed: []int64{9, 10, 10}, want: []int64{10, 11, 11}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &logWorker{stats: &logStats{}}
+			w.stats.next.Store(tt.start)
+			for i, index := range tt.completed {
+				w.advance(index)
+				if got := w.stats.next.Load(); got != tt.want[i] {
+					t.Fatalf("after completing %v next = %d, want %d", tt.completed[:i+1], got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRestartBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 1, want: restartMinBackoff},
+		{failures: 2, want: 2 * restartMinBackoff},
+		{failures: 4, want: 8 * restartMinBackoff},
+		{failures: 8, want: restartMaxBackoff},
+		{failures: 100, want: restartMaxBackoff},
+	}
+
+	for _, tt := range tests {
+		for range 100 {
+			if got := restartBackoff(tt.failures); got < tt.want/2 || got > tt.want {
+				t.Fatalf("restartBackoff(%d) = %v, want between %v and %v", tt.failures, got, tt.want/2, tt.want)
+			}
+		}
+	}
+}
+
+func TestProcessEntryDropPolicy(t *testing.T) {
+	oldPolicy := dropPolicy
+	t.Cleanup(func() { dropPolicy = oldPolicy })
+
+	entry := rawEntry{entryType: ct.X509LogEntryType, cert: testCertificate(t, "www.example.com")}
+
+	t.Run("drop", func(t *testing.T) {
+		dropPolicy = dropPolicyDrop
+		m := &CTMonitor{entryChan: make(chan CertEntry, 1), ctx: context.Background()}
+		a, b := testWorker("https://a.test/"), testWorker("https://b.test/")
+
+		m.processEntry(a, entry)
+		m.processEntry(a, entry)
+		m.processEntry(a, entry)
+		m.processEntry(b, entry)
+
+		if got := a.stats.dropped.Load(); got != 2 {
+			t.Errorf("log a dropped %d entries, want 2", got)
+		}
+		if got := b.stats.dropped.Load(); got != 1 {
+			t.Errorf("log b dropped %d entries, want 1", got)
+		}
+		if got := (<-m.entryChan).LogURL; got != "https://a.test/" {
+			t.Errorf("buffered entry from %q, want the first one from log a", got)
+		}
+	})
+
+	t.Run("block", func(t *testing.T) {
+		dropPolicy = dropPolicyBlock
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		m := &CTMonitor{entryChan: make(chan CertEntry, 1), ctx: ctx}
+		w := testWorker("https://a.test/")
+
+		m.processEntry(w, entry)
+		done := make(chan struct{})
+		go func() {
+			m.processEntry(w, entry)
+			close(done)
+		}()
+
+		select {
+		case <-done:
+			t.Fatal("processEntry returned with a full buffer")
+		case <-time.After(50 * time.Millisecond):
+		}
+		<-m.entryChan
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("processEntry still blocked after the buffer drained")
+		}
+		if len(m.entryChan) != 1 {
+			t.Errorf("buffer holds %d entries, want 1", len(m.entryChan))
+		}
+		if got := w.stats.dropped.Load(); got != 0 {
+			t.Errorf("dropped %d entries with the block policy", got)
+		}
+	})
+}
+
+func TestApplyBufferConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        *Config
+		flagBuffer int
+		flagPolicy string
+		wantBuffer int
+		wantPolicy string
+		wantErr    bool
+	}{
+		{name: "defaults", wantBuffer: defaultBufferSize, wantPolicy: dropPolicyDrop},
+		{name: "config", cfg: &Config{Buffer: 10, DropPolicy: "Block"}, wantBuffer: 10, wantPolicy: dropPolicyBlock},
+		{name: "flags override config", cfg: &Config{Buffer: 10, DropPolicy: "block"}, flagBuffer: 20, flagPolicy: "drop", wantBuffer: 20, wantPolicy: dropPolicyDrop},
+		{name: "unknown policy", flagPolicy: "wait", wantErr: true},
+		{name: "negative buffer", flagBuffer: -1, wantErr: true},
+	}
+
+	t.Cleanup(func() {
+		bufferFlag, dropPolicyFlag = 0, ""
+		bufferSize, dropPolicy = defaultBufferSize, dropPolicyDrop
+	})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bufferSize, dropPolicy = defaultBufferSize, dropPolicyDrop
+			bufferFlag, dropPolicyFlag = tt.flagBuffer, tt.flagPolicy
+
+			err := applyBufferConfig(tt.cfg)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bufferSize != tt.wantBuffer || dropPolicy != tt.wantPolicy {
+				t.Errorf("got buffer %d policy %q, want %d %q", bufferSize, dropPolicy, tt.wantBuffer, tt.wantPolicy)
+			}
+		})
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"flag"
+	"strings"
+)
+
+type command struct {
+	name     string
+	args     string
+	summary  string
+	examples []string
+	words    []string
+	hidden   bool
+	flags    func(fs *flag.FlagSet)
+	run      func(args []string)
+}
+
+var searchEntries int64
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{
+			name:    "monitor",
+			summary: "monitor CT logs in real time (default)",
+			examples: []string{
+				"cat domains.txt | crtmon -target -",
+				"crtmon monitor -target example.com -config custom.yaml -notify=discord",
+				"crtmon -target domains.txt",
+				"echo \"@reboot nohup crtmon -target example.com > /tmp/crtmon.log 2>&1 &\" | crontab -",
+				"crtmon -target example.com -notify discord -replay entries.jsonl.gz -replay-speed 0",
+			},
+			flags: func(fs *flag.FlagSet) {
+				addTargetFlags(fs)
+				addConfigFlags(fs)
+				addNotifyFlag(fs)
+				addJSONFlag(fs)
+				addSilentFlags(fs)
+				addOutputFlags(fs)
+				addHistoryFlags(fs)
+				addKnownFlags(fs)
+				addLogListFlags(fs)
+				addLogFilterFlags(fs)
+				addBufferFlags(fs)
+				addStatusFlags(fs)
+				addMetricsFlags(fs)
+				addAPIFlags(fs)
+				addVerifyFlags(fs)
+				addSCTFlags(fs)
+				addSourceFlags(fs)
+				addRecordFlags(fs)
+				addReplayFlags(fs)
+			},
+			run: runMonitor,
+		},
+		{
+			name:    "search",
+			summary: "scan the most recent entries of every CT log once and exit",
+			examples: []string{
+				"crtmon search -target example.com -entries 50000",
+			},
+			flags: func(fs *flag.FlagSet) {
+				addTargetFlags(fs)
+				addConfigFlags(fs)
+				addJSONFlag(fs)
+				addSilentFlags(fs)
+				addOutputFlags(fs)
+				addHistoryFlags(fs)
+				addKnownFlags(fs)
+				addLogListFlags(fs)
+				addLogFilterFlags(fs)
+				addBufferFlags(fs)
+				addStatusFlags(fs)
+				addSCTFlags(fs)
+				addRecordFlags(fs)
+				fs.Int64Var(&searchEntries, "entries", 10000, "number of recent entries to scan per log")
+			},
+			run: runSearch,
+		},
+		{
+			name:    "backfill",
+			summary: "scan a range of one CT log, resuming interrupted scans",
+			examples: []string{
+				"crtmon backfill -target example.com -log https://ct.googleapis.com/logs/us1/argon2025h2/ -from 2025-06-01 -to 2025-06-08",
+				"crtmon backfill -target example.com -log https://ct.example.com/log/ -from 120000000 -workers 16 -json",
+			},
+			flags: func(fs *flag.FlagSet) {
+				addTargetFlags(fs)
+				addConfigFlags(fs)
+				addJSONFlag(fs)
+				addSilentFlags(fs)
+				addOutputFlags(fs)
+				addHistoryFlags(fs)
+				addKnownFlags(fs)
+				addLogListFlags(fs)
+				addBufferFlags(fs)
+				addStatusFlags(fs)
+				addSCTFlags(fs)
+				addRecordFlags(fs)
+				addBackfillFlags(fs)
+			},
+			run: runBackfill,
+		},
+		{
+			name:    "serve",
+			summary: "re-broadcast CT entries as a certstream-compatible WebSocket feed",
+			examples: []string{
+				"crtmon serve -listen 127.0.0.1:4000",
+				"crtmon serve -target targets.txt -listen :4000   # only certificates for the targets",
+			},
+			flags: func(fs *flag.FlagSet) {
+				addTargetFlags(fs)
+				fs.StringVar(&configFlag, "config", "", "path to configuration `file` (default: ~/.config/crtmon/provider.yaml)")
+				addServeFlags(fs)
+				addLogListFlags(fs)
+				addLogFilterFlags(fs)
+				addBufferFlags(fs)
+				addStatusFlags(fs)
+				addMetricsFlags(fs)
+				addSourceFlags(fs)
+				addRecordFlags(fs)
+				addReplayFlags(fs)
+			},
+			run: runServe,
+		},
+		{
+			name:    "import",
+			args:    "<file|->...",
+			summary: "mark domains from crt.sh or Censys exports as known so only new ones alert",
+			examples: []string{
+				"curl -s 'https://crt.sh/?q=%25.example.com&output=json' > crtsh.json && crtmon import crtsh.json",
+				"crtmon import -target example.com censys-certs.ndjson subdomains.txt",
+			},
+			flags: addImportFlags,
+			run:   runImport,
+		},
+		{
+			name:    "config",
+			args:    "[path|init|show|webhook <url>]",
+			summary: "show or edit the configuration file",
+			words:   []string{"path", "init", "show", "webhook"},
+			examples: []string{
+				"crtmon config show -profile all",
+				"crtmon config webhook https://discord.com/api/webhooks/...",
+			},
+			flags: addConfigFlags,
+			run:   runConfig,
+		},
+		{
+			name:    "notify",
+			args:    "[message]",
+			summary: "send a test notification through the configured providers",
+			examples: []string{
+				"crtmon notify -profile acme -notify telegram",
+			},
+			flags: func(fs *flag.FlagSet) {
+				addConfigFlags(fs)
+				addNotifyFlag(fs)
+			},
+			run: runNotify,
+		},
+		{
+			name:    "logs",
+			summary: "list the certificate transparency logs that would be monitored",
+			examples: []string{
+				"crtmon logs",
+				"crtmon logs -log-list log_list.json",
+				"crtmon logs -log-operator google -log-state usable,qualified -log-temporal all",
+			},
+			flags: func(fs *flag.FlagSet) {
+				fs.StringVar(&configFlag, "config", "", "path to configuration `file` (default: ~/.config/crtmon/provider.yaml)")
+				addLogListFlags(fs)
+				addLogFilterFlags(fs)
+			},
+			run: runLogs,
+		},
+		{
+			name:    "status",
+			summary: "show per-log lag and throughput of the running instance",
+			examples: []string{
+				"crtmon status",
+				"crtmon status -json",
+			},
+			flags: addJSONFlag,
+			run:   runStatus,
+		},
+		{
+			name:    "history",
+			args:    "[domains|issuers|sightings]",
+			summary: "query the matches recorded in the history database",
+			words:   []string{"domains", "issuers", "sightings"},
+			examples: []string{
+				"crtmon history -target example.com -since 7d -new",
+				"crtmon history issuers -target example.com",
+				"crtmon history sightings -match '^api\\.' -format csv",
+			},
+			flags: addHistoryQueryFlags,
+			run:   runHistory,
+		},
+		{
+			name:    "schema",
+			summary: "print the JSON Schema of the -json output",
+			examples: []string{
+				"crtmon schema > crtmon-events.schema.json",
+			},
+			run: runSchema,
+		},
+		{
+			name:    "version",
+			summary: "show version",
+			run:     func([]string) { displayVersion() },
+		},
+		{
+			name:    "update",
+			summary: "update to latest version",
+			run:     func([]string) { performUpdate() },
+		},
+		{
+			name:    "completion",
+			args:    "<bash|zsh|fish>",
+			summary: "generate a shell completion script",
+			examples: []string{
+				"source <(crtmon completion bash)",
+				"crtmon completion fish > ~/.config/fish/completions/crtmon.fish",
+			},
+			words: []string{"bash", "zsh", "fish"},
+			run:   runCompletion,
+		},
+		{
+			name:    "help",
+			args:    "[command]",
+			summary: "show help for crtmon or a command",
+			run:     runHelp,
+		},
+		{
+			name:   "__complete",
+			args:   "<providers|profiles>",
+			hidden: true,
+			flags:  addConfigFlags,
+			run:    runComplete,
+		},
+	}
+
+	help := findCommand("help")
+	for _, cmd := range commands {
+		if !cmd.hidden {
+			help.words = append(help.words, cmd.name)
+		}
+	}
+}
+
+func addTargetFlags(fs *flag.FlagSet) {
+	fs.StringVar(&targetFlag, "target", "", "target domain(s) to monitor:\nsingle domain: -target example.com\n`file` with domains: -target targets.txt\nstdin: -target -")
+	fs.StringVar(&scopeFlag, "scope", "", "scope keyword to filter subdomains")
+}
+
+func addConfigFlags(fs *flag.FlagSet) {
+	fs.StringVar(&configFlag, "config", "", "path to configuration `file` (default: ~/.config/crtmon/provider.yaml)")
+	fs.StringVar(&profileFlag, "profile", "", "configuration `profile`(s) to use, comma separated, or 'all'")
+}
+
+func addNotifyFlag(fs *flag.FlagSet) {
+	fs.StringVar(&notifyFlag, "notify", "", "notification `provider`: discord, telegram")
+}
+
+func addJSONFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&jsonOutput, "json", false, "output results in JSON format (suppresses all other output)")
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// resolveCommand picks the command to run from the raw arguments. Invocations
+// that start with a flag are treated as monitor for compatibility with the
+// original flag-only interface, including -version, -update and -help.
+func resolveCommand(args []string) (*command, []string) {
+	if len(args) == 0 {
+		return findCommand("monitor"), args
+	}
+
+	if !strings.HasPrefix(args[0], "-") || args[0] == "-" {
+		return findCommand(args[0]), args[1:]
+	}
+
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		switch name {
+		case "version":
+			return findCommand("version"), nil
+		case "update":
+			return findCommand("update"), nil
+		case "h", "help":
+			return findCommand("help"), nil
+		}
+	}
+
+	return findCommand("monitor"), args
+}
+
+func runHelp(args []string) {
+	if len(args) > 0 {
+		if cmd := findCommand(args[0]); cmd != nil {
+			displayCommandHelp(cmd)
+			return
+		}
+	}
+	displayHelp()
+}
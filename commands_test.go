@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResolveCommand(t *testing.T) {
+	tests := []struct {
+		args     []string
+		wantCmd  string
+		wantArgs []string
+	}{
+		{args: nil, wantCmd: "monitor"},
+		{args: []string{"search", "-target", "example.com"}, wantCmd: "search", wantArgs: []string{"-target", "example.com"}},
+		{args: []string{"help", "search"}, wantCmd: "help", wantArgs: []string{"search"}},
+		// the original flag-only interface
+		{args: []string{"-target", "example.com"}, wantCmd: "monitor", wantArgs: []string{"-target", "example.com"}},
+		{args: []string{"-version"}, wantCmd: "version"},
+		{args: []string{"--version"}, wantCmd: "version"},
+		{args: []string{"-update"}, wantCmd: "update"},
+		{args: []string{"-h"}, wantCmd: "help"},
+		{args: []string{"-target", "x", "-h"}, wantCmd: "help"},
+		{args: []string{"-target=x", "-help=true"}, wantCmd: "help"},
+		{args: []string{"-target", "version"}, wantCmd: "monitor", wantArgs: []string{"-target", "version"}},
+		{args: []string{"unknown"}},
+		{args: []string{"-"}},
+	}
+	for _, tt := range tests {
+		cmd, args := resolveCommand(tt.args)
+		var name string
+		if cmd != nil {
+			name = cmd.name
+		}
+		if name != tt.wantCmd || (cmd != nil && strings.Join(args, " ") != strings.Join(tt.wantArgs, " ")) {
+			t.Errorf("resolveCommand(%q) = %q %q, want %q %q", tt.args, name, args, tt.wantCmd, tt.wantArgs)
+		}
+	}
+}
+
+func TestCommandNames(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, cmd := range commands {
+		if seen[cmd.name] {
+			t.Errorf("command %q is defined twice", cmd.name)
+		}
+		seen[cmd.name] = true
+		if cmd.run == nil {
+			t.Errorf("command %q has nothing to run", cmd.name)
+		}
+	}
+}
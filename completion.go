@@ -0,0 +1,359 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// completionKinds maps the value name a flag's usage puts in back quotes,
+// as in "path to configuration `file`", to what completes after the flag.
+// Flags without one complete nothing after them unless they are boolean.
+var completionKinds = map[string]string{
+	"file":     "file",
+	"provider": "providers",
+	"profile":  "profiles",
+}
+
+type completionFlag struct {
+	name   string
+	usage  string
+	isBool bool
+	kind   string
+}
+
+func commandFlags(cmd *command) []completionFlag {
+	if cmd.flags == nil {
+		return nil
+	}
+
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	cmd.flags(fs)
+
+	var flags []completionFlag
+	fs.VisitAll(func(f *flag.Flag) {
+		valueName, usage := flag.UnquoteUsage(f)
+		usage, _, _ = strings.Cut(usage, "\n")
+		bf, ok := f.Value.(interface{ IsBoolFlag() bool })
+		flags = append(flags, completionFlag{
+			name:   f.Name,
+			usage:  strings.TrimSuffix(usage, ":"),
+			isBool: ok && bf.IsBoolFlag(),
+			kind:   completionKinds[valueName],
+		})
+	})
+	return flags
+}
+
+// flagsOfKind lists every command's flags that complete kind, as -name and
+// --name, for the shells that complete flag values by the previous word.
+func flagsOfKind(kind string) []string {
+	seen := make(map[string]bool)
+	var flags []string
+	for _, cmd := range commands {
+		for _, f := range commandFlags(cmd) {
+			if f.kind == kind && !seen[f.name] {
+				seen[f.name] = true
+				flags = append(flags, "-"+f.name, "--"+f.name)
+			}
+		}
+	}
+	sort.Strings(flags)
+	return flags
+}
+
+func visibleCommands() []*command {
+	var visible []*command
+	for _, cmd := range commands {
+		if !cmd.hidden {
+			visible = append(visible, cmd)
+		}
+	}
+	return visible
+}
+
+func runCompletion(args []string) {
+	if len(args) != 1 {
+		logger.Fatal("usage: crtmon completion <bash|zsh|fish>")
+	}
+
+	switch args[0] {
+	case "bash":
+		fmt.Print(bashCompletion())
+	case "zsh":
+		fmt.Print(zshCompletion())
+	case "fish":
+		fmt.Print(fishCompletion())
+	default:
+		logger.Fatal("unsupported shell. valid options are: bash, zsh, fish", "shell", args[0])
+	}
+}
+
+// runComplete prints dynamic completion candidates, one per line. It is
+// invoked by the generated scripts and must stay quiet on errors.
+func runComplete(args []string) {
+	if len(args) != 1 {
+		os.Exit(1)
+	}
+
+	if configFlag != "" {
+		setConfigPath(configFlag)
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "profiles":
+		if cfg == nil {
+			return
+		}
+		names := []string{defaultProfile}
+		for name := range cfg.Profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		names = append(names, "all")
+		fmt.Println(strings.Join(names, "\n"))
+	case "providers":
+		if cfg == nil {
+			return
+		}
+		selection := profileFlag
+		if selection == "" {
+			selection = "all"
+		}
+		selected, err := selectProfiles(cfg, selection)
+		if err != nil {
+			os.Exit(1)
+		}
+		var discord, telegram bool
+		for _, p := range selected {
+			discord = discord || p.discordConfigured()
+			telegram = telegram || p.telegramConfigured()
+		}
+		if discord {
+			fmt.Println("discord")
+		}
+		if telegram {
+			fmt.Println("telegram")
+		}
+	}
+}
+
+func bashCompletion() string {
+	var b strings.Builder
+	var names []string
+	for _, cmd := range visibleCommands() {
+		names = append(names, cmd.name)
+	}
+
+	b.WriteString("# bash completion for crtmon\n")
+	b.WriteString("# source <(crtmon completion bash)\n\n")
+	b.WriteString(`__crtmon_dynamic() {
+    local kind="$1" i args=()
+    for ((i = 1; i < ${#COMP_WORDS[@]} - 1; i++)); do
+        case "${COMP_WORDS[i]}" in
+            -config|--config|-profile|--profile)
+                # bash splits -config=path into "-config" "=" "path"
+                if [[ "${COMP_WORDS[i+1]}" == "=" ]]; then
+                    args+=("${COMP_WORDS[i]}=${COMP_WORDS[i+2]}")
+                else
+                    args+=("${COMP_WORDS[i]}" "${COMP_WORDS[i+1]}")
+                fi ;;
+            -config=*|--config=*|-profile=*|--profile=*) args+=("${COMP_WORDS[i]}") ;;
+        esac
+    done
+    crtmon __complete "${args[@]}" "$kind" 2>/dev/null
+}
+
+_crtmon() {
+    local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"
+    local cmd=monitor flags="" words=""
+    if [[ "$prev" == "=" && $COMP_CWORD -gt 2 ]]; then
+        prev="${COMP_WORDS[COMP_CWORD-2]}"
+    fi
+    if [[ ${#COMP_WORDS[@]} -gt 2 && "${COMP_WORDS[1]}" != -* ]]; then
+        cmd="${COMP_WORDS[1]}"
+    fi
+
+    case "$prev" in
+`)
+	for _, kind := range []string{"file", "providers", "profiles"} {
+		flags := flagsOfKind(kind)
+		if len(flags) == 0 {
+			continue
+		}
+		if kind == "file" {
+			fmt.Fprintf(&b, "        %s) COMPREPLY=($(compgen -f -- \"$cur\")); return ;;\n", strings.Join(flags, "|"))
+		} else {
+			fmt.Fprintf(&b, "        %s) COMPREPLY=($(compgen -W \"$(__crtmon_dynamic %s)\" -- \"$cur\")); return ;;\n", strings.Join(flags, "|"), kind)
+		}
+	}
+	b.WriteString("    esac\n\n")
+
+	fmt.Fprintf(&b, "    if [[ $COMP_CWORD -eq 1 && \"$cur\" != -* ]]; then\n        COMPREPLY=($(compgen -W \"%s\" -- \"$cur\"))\n        return\n    fi\n\n", strings.Join(names, " "))
+
+	b.WriteString("    case \"$cmd\" in\n")
+	for _, cmd := range visibleCommands() {
+		var flags []string
+		for _, f := range commandFlags(cmd) {
+			flags = append(flags, "-"+f.name)
+		}
+		fmt.Fprintf(&b, "        %s) flags=\"%s\"; words=\"%s\" ;;\n", cmd.name, strings.Join(append(flags, "-h"), " "), strings.Join(cmd.words, " "))
+	}
+	b.WriteString("    esac\n\n")
+	b.WriteString(`    if [[ "$cur" == -* ]]; then
+        COMPREPLY=($(compgen -W "$flags" -- "$cur"))
+    elif [[ -n "$words" ]]; then
+        COMPREPLY=($(compgen -W "$words" -- "$cur"))
+    fi
+}
+
+complete -o default -F _crtmon crtmon
+`)
+	return b.String()
+}
+
+func zshCompletion() string {
+	var b strings.Builder
+
+	b.WriteString("#compdef crtmon\n")
+	b.WriteString("# zsh completion for crtmon\n")
+	b.WriteString("# crtmon completion zsh > \"${fpath[1]}/_crtmon\"\n\n")
+	b.WriteString(`__crtmon_dynamic() {
+    local kind="$1" i
+    local -a args
+    for ((i = 2; i < CURRENT; i++)); do
+        case "${words[i]}" in
+            -config|--config|-profile|--profile) args+=("${words[i]}" "${words[i+1]}") ;;
+            -config=*|--config=*|-profile=*|--profile=*) args+=("${words[i]}") ;;
+        esac
+    done
+    crtmon __complete "${args[@]}" "$kind" 2>/dev/null
+}
+
+_crtmon() {
+    local cur="${words[CURRENT]}" prev="${words[CURRENT-1]}" cmd=monitor
+    local -a flags cmdwords
+    if (( CURRENT > 2 )) && [[ "${words[2]}" != -* ]]; then
+        cmd="${words[2]}"
+    fi
+
+    case "$prev" in
+`)
+	for _, kind := range []string{"file", "providers", "profiles"} {
+		flags := flagsOfKind(kind)
+		if len(flags) == 0 {
+			continue
+		}
+		if kind == "file" {
+			fmt.Fprintf(&b, "        %s) _files; return ;;\n", strings.Join(flags, "|"))
+		} else {
+			fmt.Fprintf(&b, "        %s) compadd -- ${(f)\"$(__crtmon_dynamic %s)\"}; return ;;\n", strings.Join(flags, "|"), kind)
+		}
+	}
+	b.WriteString("    esac\n\n")
+
+	b.WriteString("    if (( CURRENT == 2 )) && [[ \"$cur\" != -* ]]; then\n        local -a subcommands\n        subcommands=(\n")
+	for _, cmd := range visibleCommands() {
+		fmt.Fprintf(&b, "            '%s:%s'\n", cmd.name, zshEscape(cmd.summary))
+	}
+	b.WriteString("        )\n        _describe 'command' subcommands\n        return\n    fi\n\n")
+
+	b.WriteString("    case \"$cmd\" in\n")
+	for _, cmd := range visibleCommands() {
+		var flags []string
+		for _, f := range commandFlags(cmd) {
+			flags = append(flags, fmt.Sprintf("'-%s:%s'", f.name, zshEscape(f.usage)))
+		}
+		flags = append(flags, "'-h:show this help message'")
+		fmt.Fprintf(&b, "        %s) flags=(%s); cmdwords=(%s) ;;\n", cmd.name, strings.Join(flags, " "), strings.Join(cmd.words, " "))
+	}
+	b.WriteString("    esac\n\n")
+	b.WriteString(`    if [[ "$cur" == -* ]]; then
+        _describe 'flag' flags
+    elif (( ${#cmdwords} )); then
+        compadd -- $cmdwords
+    else
+        _files
+    fi
+}
+
+if [[ "$funcstack[1]" == "_crtmon" ]]; then
+    _crtmon "$@"
+else
+    compdef _crtmon crtmon
+fi
+`)
+	return b.String()
+}
+
+func fishCompletion() string {
+	var b strings.Builder
+
+	b.WriteString("# fish completion for crtmon\n")
+	b.WriteString("# crtmon completion fish > ~/.config/fish/completions/crtmon.fish\n\n")
+	b.WriteString(`function __crtmon_dynamic
+    set -l tokens (commandline -opc)
+    set -l args
+    for i in (seq 2 (math (count $tokens) - 1))
+        switch $tokens[$i]
+            case -config --config -profile --profile
+                set -a args $tokens[$i] $tokens[(math $i + 1)]
+            case '-config=*' '--config=*' '-profile=*' '--profile=*'
+                set -a args $tokens[$i]
+        end
+    end
+    crtmon __complete $args $argv[1] 2>/dev/null
+end
+
+function __crtmon_command_is
+    set -l tokens (commandline -opc)
+    if test (count $tokens) -lt 2; or string match -q -- '-*' $tokens[2]
+        contains -- monitor $argv
+        return
+    end
+    contains -- $tokens[2] $argv
+end
+
+complete -c crtmon -f
+`)
+	for _, cmd := range visibleCommands() {
+		fmt.Fprintf(&b, "complete -c crtmon -n 'test (count (commandline -opc)) -eq 1' -a %s -d '%s'\n", cmd.name, fishEscape(cmd.summary))
+	}
+	b.WriteString("\n")
+
+	for _, cmd := range visibleCommands() {
+		cond := fmt.Sprintf("__crtmon_command_is %s", cmd.name)
+		for _, f := range commandFlags(cmd) {
+			line := fmt.Sprintf("complete -c crtmon -n '%s' -o %s -l %s -d '%s'", cond, f.name, f.name, fishEscape(f.usage))
+			switch {
+			case f.isBool:
+			case f.kind == "file":
+				line += " -r -F"
+			case f.kind != "":
+				line += fmt.Sprintf(" -x -a '(__crtmon_dynamic %s)'", f.kind)
+			default:
+				line += " -x"
+			}
+			b.WriteString(line + "\n")
+		}
+		if len(cmd.words) > 0 {
+			fmt.Fprintf(&b, "complete -c crtmon -n '%s' -n 'test (count (commandline -opc)) -eq 2' -a '%s'\n", cond, strings.Join(cmd.words, " "))
+		}
+	}
+	return b.String()
+}
+
+func zshEscape(s string) string {
+	s = strings.ReplaceAll(s, ":", "\\:")
+	return strings.ReplaceAll(s, "'", "'\\''")
+}
+
+func fishEscape(s string) string {
+	return strings.ReplaceAll(s, "'", "\\'")
+}
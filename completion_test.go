@@ -0,0 +1,135 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCommandFlagKinds(t *testing.T) {
+	tests := []struct {
+		command, flag, want string
+	}{
+		{"monitor", "target", "file"},
+		{"monitor", "config", "file"},
+		{"monitor", "notify", "providers"},
+		{"monitor", "profile", "profiles"},
+		{"monitor", "json", ""},
+		{"search", "entries", ""},
+		{"notify", "notify", "providers"},
+		{"logs", "config", "file"},
+		{"logs", "log-list", "file"},
+		{"logs", "log-list-key", "file"},
+	}
+	for _, tt := range tests {
+		var found bool
+		for _, f := range commandFlags(findCommand(tt.command)) {
+			if f.name == tt.flag {
+				found = true
+				if f.kind != tt.want {
+					t.Errorf("%s -%s completes %q, want %q", tt.command, tt.flag, f.kind, tt.want)
+				}
+				if strings.Contains(f.usage, "`") {
+					t.Errorf("%s -%s usage %q keeps its back quotes", tt.command, tt.flag, f.usage)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("%s has no -%s flag", tt.command, tt.flag)
+		}
+	}
+}
+
+var pathUsage = regexp.MustCompile(`(?i)\b(file|path)\b`)
+
+// notPathFlags mention a file in their usage without taking a path.
+var notPathFlags = map[string]bool{
+	"output-format":   true,
+	"output-max-size": true,
+	"output-rotate":   true,
+}
+
+// TestFileFlagsCompleteFiles catches flags that take a path but do not mark
+// it as a `file` in their usage, which leaves them without file completion.
+func TestFileFlagsCompleteFiles(t *testing.T) {
+	for _, cmd := range commands {
+		for _, f := range commandFlags(cmd) {
+			if !f.isBool && f.kind == "" && !notPathFlags[f.name] && pathUsage.MatchString(f.usage) {
+				t.Errorf("%s -%s (%q) does not complete file names", cmd.name, f.name, f.usage)
+			}
+		}
+	}
+}
+
+func TestCompletionScripts(t *testing.T) {
+	tests := []struct {
+		shell  string
+		script string
+		want   []string
+	}{
+		{"bash", bashCompletion(), []string{
+			`) COMPREPLY=($(compgen -f -- "$cur")); return ;;`,
+			`--notify|-notify) COMPREPLY=($(compgen -W "$(__crtmon_dynamic providers)" -- "$cur"))`,
+			"complete -o default -F _crtmon crtmon",
+		}},
+		{"zsh", zshCompletion(), []string{
+			") _files; return ;;",
+			"--profile|-profile) compadd -- ${(f)\"$(__crtmon_dynamic profiles)\"}; return ;;",
+		}},
+		{"fish", fishCompletion(), []string{
+			"complete -c crtmon -n '__crtmon_command_is monitor' -o config -l config -d 'path to configuration file (default: ~/.config/crtmon/provider.yaml)' -r -F",
+			"complete -c crtmon -n '__crtmon_command_is monitor' -o notify -l notify -d 'notification provider: discord, telegram' -x -a '(__crtmon_dynamic providers)'",
+			"complete -c crtmon -n '__crtmon_command_is monitor' -o json -l json -d 'output results in JSON format (suppresses all other output)'\n",
+		}},
+	}
+	for _, tt := range tests {
+		for _, want := range tt.want {
+			if !strings.Contains(tt.script, want) {
+				t.Errorf("%s completion lacks %q", tt.shell, want)
+			}
+		}
+		if strings.Contains(tt.script, "`") {
+			t.Errorf("%s completion contains a back quote", tt.shell)
+		}
+
+		// check the syntax with the shell itself where it is installed
+		sh, err := exec.LookPath(tt.shell)
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(t.TempDir(), "crtmon."+tt.shell)
+		if err := os.WriteFile(path, []byte(tt.script), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if out, err := exec.Command(sh, "-n", path).CombinedOutput(); err != nil {
+			t.Errorf("%s -n: %v\n%s", tt.shell, err, out)
+		}
+	}
+}
+
+func TestFileCompletionCase(t *testing.T) {
+	want := []string{"-config", "--config", "-target", "--target", "-log-list", "--log-list", "-log-list-key", "--log-list-key", "-output", "--output", "-history-db", "--history-db", "-checkpoint", "--checkpoint", "-known", "--known", "-record", "--record", "-replay", "--replay"}
+
+	for _, tt := range []struct {
+		shell, script, action string
+	}{
+		{"bash", bashCompletion(), `) COMPREPLY=($(compgen -f -- "$cur")); return ;;`},
+		{"zsh", zshCompletion(), ") _files; return ;;"},
+	} {
+		var flags []string
+		for _, line := range strings.Split(tt.script, "\n") {
+			if pattern, ok := strings.CutSuffix(strings.TrimSpace(line), tt.action); ok {
+				flags = strings.Split(pattern, "|")
+			}
+		}
+		for _, flag := range want {
+			if !slices.Contains(flags, flag) {
+				t.Errorf("%s completes no file names after %s (file flags: %v)", tt.shell, flag, flags)
+			}
+		}
+	}
+}
@@ -4,11 +4,27 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
+	ProfileConfig `yaml:",inline"`
+	Profiles      map[string]*ProfileConfig `yaml:"profiles,omitempty"`
+	LogList       string                    `yaml:"log_list,omitempty"`
+	LogListKey    string                    `yaml:"log_list_key,omitempty"`
+	Logs          LogFilterConfig           `yaml:"logs,omitempty"`
+	Buffer        int                       `yaml:"buffer,omitempty"`
+	DropPolicy    string                    `yaml:"drop_policy,omitempty"`
+	Output        OutputConfig              `yaml:"output,omitempty"`
+	History       string                    `yaml:"history,omitempty"`
+	KnownDomains  string                    `yaml:"known_domains,omitempty"`
+	Source        SourceConfig              `yaml:"source,omitempty"`
+	API           APIConfig                 `yaml:"api,omitempty"`
+}
+
+type ProfileConfig struct {
 	Webhook          string   `yaml:"webhook"`
 	TelegramBotToken string   `yaml:"telegram_bot_token"`
 	TelegramChatID   string   `yaml:"telegram_chat_id"`
@@ -29,6 +45,20 @@ func getConfigDir() (string, error) {
 	return filepath.Join(home, ".config", "crtmon"), nil
 }
 
+// expandHome replaces a leading ~/ in a path from the configuration file with
+// the user's home directory.
+func expandHome(path string) (string, error) {
+	rest, ok := strings.CutPrefix(path, "~/")
+	if !ok {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, rest), nil
+}
+
 func getConfigPath() (string, error) {
 	if customConfigPath != "" {
 		return customConfigPath, nil
@@ -63,6 +93,72 @@ telegram_chat_id: ""
 
 # target wildcard to monitor
 targets:
+
+# CT log list file or URL and the PEM key that signs it (optional)
+# defaults to Google's log list, verified and cached for offline use
+# log_list: ""
+# log_list_key: ""
+
+# which CT logs to monitor (optional); list values match case-insensitively
+# logs:
+#   operators: [google, "let's encrypt"]
+#   exclude_operators: []
+#   urls: []
+#   exclude_urls: []
+#   match: ""              # description regex
+#   exclude_match: ""
+#   states: [usable]       # usable, qualified, readonly, retired, pending
+#   temporal: current      # current: skip shards that cannot receive new certs, all: keep them
+#   extra:                 # logs not in the log list, e.g. private or test logs
+#     - url: https://ct.example.com/log/
+#       description: private log
+#       key: ""            # base64 DER public key
+#       tiled: false       # true for static-ct-api (tiled) logs
+
+# entries buffered between the CT logs and matching, and what to do when the
+# buffer is full: drop discards and warns, block slows the log readers down
+# (search and backfill block unless this is set)
+# buffer: 5000
+# drop_policy: drop
+
+# write matches to a file as well (optional); {target} and {profile} in the
+# path give one file per target or profile
+# output:
+#   path: ~/crtmon/{target}.jsonl
+#   format: jsonl          # jsonl, csv or txt (domains only)
+#   max_size: 100          # rotate at this size in MB
+#   rotate: 24h            # rotate at this interval
+#   compress: true         # gzip rotated files
+
+# SQLite database recording every match for crtmon history (optional)
+# history: ~/.config/crtmon/history.db
+
+# domains that are not alerted on, one per line; filled by crtmon import and
+# by every new domain alerted on (default: ~/.config/crtmon/known_domains.txt)
+# known_domains: ~/.config/crtmon/known_domains.txt
+
+# where monitor reads entries from (optional): the CT logs (default), or a
+# certstream server when the logs cannot be reached directly
+# source:
+#   type: certstream
+#   url: wss://certstream.example.com/full-stream
+
+# HTTP API of the running monitor (optional); requests must send
+# "Authorization: Bearer <token>"
+# api:
+#   addr: 127.0.0.1:8090
+#   token: change-me
+#   allow_origin: https://dashboard.example.com   # lets that page open /events
+
+# named profiles with their own targets and providers (optional)
+# select with -profile <name>, or -profile all to run every profile at once
+# profiles:
+#   acme:
+#     webhook: ""
+#     telegram_bot_token: ""
+#     telegram_chat_id: ""
+#     targets:
+#       - acme.com
 `
 
 	return os.WriteFile(configPath, []byte(template), 0644)
@@ -88,6 +184,15 @@ func loadConfig() (*Config, error) {
 		return nil, err
 	}
 
+	if _, exists := config.Profiles[defaultProfile]; exists {
+		return nil, fmt.Errorf("profile name %q is reserved for the top-level settings", defaultProfile)
+	}
+	for name, pc := range config.Profiles {
+		if pc == nil {
+			config.Profiles[name] = &ProfileConfig{}
+		}
+	}
+
 	return &config, nil
 }
 
@@ -136,3 +241,78 @@ func updateWebhook(newWebhook string) error {
 	return os.WriteFile(configPath, newData, 0644)
 }
 
+func runConfig(args []string) {
+	if configFlag != "" {
+		setConfigPath(configFlag)
+	}
+
+	action := "show"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	configPath, err := getConfigPath()
+	if err != nil {
+		logger.Fatal("failed to resolve config path", "error", err)
+	}
+
+	switch action {
+	case "path":
+		fmt.Println(configPath)
+	case "init":
+		if configExists() {
+			logger.Fatal("configuration file already exists", "path", configPath)
+		}
+		if err := createConfigTemplate(); err != nil {
+			logger.Fatal("failed to create config template", "error", err)
+		}
+		logger.Info("created config template", "path", configPath)
+	case "show":
+		cfg, err := loadConfig()
+		if err != nil {
+			logger.Fatal("failed to load config", "error", err)
+		}
+		if cfg == nil {
+			logger.Fatal("no configuration file found. run 'crtmon config init' to create one", "path", configPath)
+		}
+		selection := profileFlag
+		if selection == "" {
+			selection = "all"
+		}
+		selected, err := selectProfiles(cfg, selection)
+		if err != nil {
+			logger.Fatal("failed to select profile", "error", err)
+		}
+		fmt.Printf("%s %s\n\n", cmdStyle.Render("config:"), configPath)
+		for _, p := range selected {
+			fmt.Println(successStyle.Render(" " + p.name + ":"))
+			fmt.Printf("    discord:  %s\n", configuredLabel(p.discordConfigured()))
+			fmt.Printf("    telegram: %s\n", configuredLabel(p.telegramConfigured()))
+			fmt.Printf("    targets:  %d\n", len(p.targets))
+			for i, t := range p.targets {
+				fmt.Printf("      %d. %s\n", i+1, t)
+			}
+			fmt.Println()
+		}
+	case "webhook":
+		if len(args) < 2 {
+			logger.Fatal("usage: crtmon config webhook <url>")
+		}
+		if !configExists() {
+			logger.Fatal("no configuration file found. run 'crtmon config init' to create one", "path", configPath)
+		}
+		if err := updateWebhook(args[1]); err != nil {
+			logger.Fatal("failed to update webhook", "error", err)
+		}
+		logger.Info("updated discord webhook", "path", configPath)
+	default:
+		logger.Fatal("unknown config action. valid actions are: path, init, show, webhook", "action", action)
+	}
+}
+
+func configuredLabel(ok bool) string {
+	if ok {
+		return successStyle.Render("configured")
+	}
+	return argStyle.Render("not configured")
+}
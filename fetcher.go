@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	ct "github.com/google/certificate-transparency-go"
+	"github.com/google/certificate-transparency-go/client"
+	"github.com/google/certificate-transparency-go/jsonclient"
+	"github.com/google/certificate-transparency-go/scanner"
+)
+
+// rawEntry is a log entry reduced to what crtmon needs, independent of the
+// API the log serves. For precertificates cert holds the TBSCertificate and
+// der the full precertificate. issuer is the DER of the issuing certificate
+// of X509 entries, which is only resolved for tiled logs when SCTs are
+// verified.
+type rawEntry struct {
+	index     int64
+	timestamp uint64
+	entryType ct.LogEntryType
+	cert      []byte
+	der       []byte
+	issuer    []byte
+}
+
+// logFetcher reads entries from a single CT log.
+type logFetcher interface {
+	url() string
+	// treeSize returns the number of entries currently in the log.
+	treeSize(ctx context.Context) (int64, error)
+	// fetch calls fn for each entry in [start, end). When end is zero it
+	// keeps following the log until ctx is cancelled or an error occurs.
+	fetch(ctx context.Context, start, end int64, fn func(rawEntry)) error
+}
+
+func newLogFetcher(l *ctLog) (logFetcher, error) {
+	logURL := l.URL
+	if !strings.HasPrefix(logURL, "https://") && !strings.HasPrefix(logURL, "http://") {
+		logURL = "https://" + logURL
+	}
+	logURL = strings.TrimSuffix(logURL, "/")
+	httpClient := &http.Client{Timeout: 180 * time.Second}
+
+	if l.Tiled {
+		return &tiledFetcher{logURL: logURL, client: httpClient, issuers: make(map[string][]byte)}, nil
+	}
+
+	logClient, err := client.New(logURL, httpClient, jsonclient.Options{})
+	if err != nil {
+		return nil, err
+	}
+	return &rfc6962Fetcher{logURL: logURL, client: logClient}, nil
+}
+
+// rfc6962Fetcher reads logs that serve the RFC 6962 get-entries API.
+type rfc6962Fetcher struct {
+	logURL string
+	client *client.LogClient
+}
+
+func (f *rfc6962Fetcher) url() string {
+	return f.logURL
+}
+
+func (f *rfc6962Fetcher) treeSize(ctx context.Context) (int64, error) {
+	sth, err := f.client.GetSTH(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return int64(sth.TreeSize), nil
+}
+
+func (f *rfc6962Fetcher) fetch(ctx context.Context, start, end int64, fn func(rawEntry)) error {
+	opts := scanner.FetcherOptions{
+		BatchSize:     1,
+		ParallelFetch: 1,
+		StartIndex:    start,
+		EndIndex:      end,
+		Continuous:    end == 0,
+	}
+	if end != 0 {
+		opts.BatchSize = 100
+		opts.ParallelFetch = 4
+	}
+
+	fetcher := scanner.NewFetcher(f.client, &opts)
+	return fetcher.Run(ctx, func(batch scanner.EntryBatch) {
+		for i := range batch.Entries {
+			rle, err := ct.RawLogEntryFromLeaf(batch.Start+int64(i), &batch.Entries[i])
+			if err != nil {
+				// passed on without a certificate so the resume point can
+				// move past it
+				fn(rawEntry{index: batch.Start + int64(i)})
+				continue
+			}
+
+			entry := rawEntry{
+				index:     rle.Index,
+				timestamp: rle.Leaf.TimestampedEntry.Timestamp,
+				entryType: rle.Leaf.TimestampedEntry.EntryType,
+				der:       rle.Cert.Data,
+			}
+			switch entry.entryType {
+			case ct.X509LogEntryType:
+				entry.cert = rle.Cert.Data
+				if len(rle.Chain) > 0 {
+					entry.issuer = rle.Chain[0].Data
+				}
+			case ct.PrecertLogEntryType:
+				entry.cert = rle.Leaf.TimestampedEntry.PrecertEntry.TBSCertificate
+			}
+			fn(entry)
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var (
+	readyLogs    int
+	stallTimeout time.Duration
+)
+
+func addHealthFlags(fs *flag.FlagSet) {
+	fs.IntVar(&readyLogs, "ready-logs", 1, "logs that must be actively fetching before /readyz reports ready")
+	fs.DurationVar(&stallTimeout, "stall-timeout", 10*time.Minute, "report unhealthy on /healthz when no entries were processed for this long")
+}
+
+// healthy reports whether the monitor is working: the log list loaded and
+// entries were processed recently. It returns the reason when it is not.
+func (m *CTMonitor) healthy() error {
+	m.mu.Lock()
+	logListErr := m.logListErr
+	m.mu.Unlock()
+	if logListErr != nil {
+		return fmt.Errorf("log list unavailable: %w", logListErr)
+	}
+
+	last := m.started
+	for _, s := range allLogStats() {
+		if t := time.Unix(0, s.lastEntry.Load()); t.After(last) {
+			last = t
+		}
+	}
+	if idle := time.Since(last); stallTimeout > 0 && idle > stallTimeout {
+		return fmt.Errorf("no entries processed for %s", idle.Round(time.Second))
+	}
+	return nil
+}
+
+// ready reports whether enough logs are fetching to be useful. When fewer
+// logs are selected than -ready-logs, all of them must be fetching.
+func (m *CTMonitor) ready() error {
+	m.mu.Lock()
+	logCount := m.logCount
+	m.mu.Unlock()
+
+	active := 0
+	for _, s := range allLogStats() {
+		if s.active.Load() {
+			active++
+		}
+	}
+
+	want := min(readyLogs, logCount)
+	if logCount == 0 || active < want {
+		return fmt.Errorf("%d of %d logs fetching, need %d", active, logCount, max(want, 1))
+	}
+	return nil
+}
+
+func writeProbe(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, err)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func (m *CTMonitor) queue() (int, int) {
+	return len(m.entryChan), cap(m.entryChan)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMonitorHealthy(t *testing.T) {
+	oldTimeout := stallTimeout
+	stallTimeout = 10 * time.Minute
+	t.Cleanup(func() { stallTimeout = oldTimeout })
+
+	now := time.Now()
+	tests := []struct {
+		name       string
+		started    time.Time
+		lastEntry  []time.Duration
+		logListErr error
+		wantErr    string
+	}{
+		{name: "just started", started: now},
+		{name: "recent entry", started: now.Add(-time.Hour), lastEntry: []time.Duration{time.Hour, time.Minute}},
+		{name: "stalled", started: now.Add(-time.Hour), lastEntry: []time.Duration{30 * time.Minute, 20 * time.Minute}, wantErr: "no entries processed for 20m0s"},
+		{name: "never processed", started: now.Add(-time.Hour), wantErr: "no entries processed for 1h0m0s"},
+		{name: "log list unavailable", started: now, logListErr: errors.New("offline"), wantErr: "log list unavailable: offline"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useLogStats(t)
+			for i, ago := range tt.lastEntry {
+				s := registerLogStats(&ctLog{}, "https://ct.example/"+string(rune('a'+i))+"/")
+				s.lastEntry.Store(now.Add(-ago).UnixNano())
+			}
+
+			m := &CTMonitor{started: tt.started, logListErr: tt.logListErr}
+			checkProbe(t, m.healthy(), tt.wantErr)
+		})
+	}
+}
+
+func TestMonitorReady(t *testing.T) {
+	oldReady := readyLogs
+	t.Cleanup(func() { readyLogs = oldReady })
+
+	tests := []struct {
+		name      string
+		readyLogs int
+		logCount  int
+		active    int
+		wantErr   string
+	}{
+		{name: "no logs yet", readyLogs: 1, wantErr: "0 of 0 logs fetching, need 1"},
+		{name: "none fetching", readyLogs: 1, logCount: 3, wantErr: "0 of 3 logs fetching, need 1"},
+		{name: "one fetching", readyLogs: 1, logCount: 3, active: 1},
+		{name: "too few fetching", readyLogs: 3, logCount: 5, active: 2, wantErr: "2 of 5 logs fetching, need 3"},
+		{name: "fewer logs than required", readyLogs: 5, logCount: 2, active: 2},
+		{name: "fewer logs, one down", readyLogs: 5, logCount: 2, active: 1, wantErr: "1 of 2 logs fetching, need 2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useLogStats(t)
+			readyLogs = tt.readyLogs
+			for i := range tt.logCount {
+				s := registerLogStats(&ctLog{}, "https://ct.example/"+string(rune('a'+i))+"/")
+				s.active.Store(i < tt.active)
+			}
+
+			m := &CTMonitor{logCount: tt.logCount}
+			checkProbe(t, m.ready(), tt.wantErr)
+		})
+	}
+}
+
+func checkProbe(t *testing.T, err error, wantErr string) {
+	t.Helper()
+	if wantErr == "" {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return
+	}
+	if err == nil || err.Error() != wantErr {
+		t.Fatalf("got error %v, want %q", err, wantErr)
+	}
+}
+
+func TestWriteProbe(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "ok", wantStatus: http.StatusOK, wantBody: "ok"},
+		{name: "failing", err: errors.New("0 of 3 logs fetching, need 1"), wantStatus: http.StatusServiceUnavailable, wantBody: "0 of 3 logs fetching, need 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			writeProbe(rec, tt.err)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.wantBody {
+				t.Errorf("body %q, want %q", got, tt.wantBody)
+			}
+		})
+	}
+}
@@ -1,13 +1,23 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"strings"
 
 	"github.com/charmbracelet/lipgloss"
 )
 
 var cyanCommands = []string{"crtmon", "nohup", "crontab", "echo", "cat", "reboot"}
 
+var (
+	cmdStyle     = lipgloss.NewStyle().Foreground(lipgloss.Color("14"))
+	argStyle     = lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+	flagStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("15"))
+	successStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("10"))
+	errorStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+)
+
 func isCyanCommand(cmd string) bool {
 	for _, c := range cyanCommands {
 		if c == cmd {
@@ -18,36 +28,112 @@ func isCyanCommand(cmd string) bool {
 }
 
 func displayHelp() {
-	cmdStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("14"))
-	argStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
-	flagStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("15"))
-	successStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("10"))
+	printBanner()
+	fmt.Println(successStyle.Render(" usage:"))
+	fmt.Printf("    %s %s %s\n", cmdStyle.Render("crtmon"), argStyle.Render("<command>"), argStyle.Render("[flags]"))
+	for _, example := range findCommand("monitor").examples {
+		fmt.Printf("    %s\n", renderExample(example))
+	}
+	fmt.Println()
 
+	fmt.Println(successStyle.Render(" commands:"))
+	width := 0
+	for _, cmd := range commands {
+		if !cmd.hidden {
+			width = max(width, len(cmd.name))
+		}
+	}
+	for _, cmd := range commands {
+		if cmd.hidden {
+			continue
+		}
+		fmt.Printf("    %s  %s\n", flagStyle.Render(pad(cmd.name, width)), cmd.summary)
+	}
+	fmt.Println()
+	fmt.Printf("    run %s or %s for command flags\n\n", cmdStyle.Render("crtmon help <command>"), cmdStyle.Render("crtmon <command> -h"))
+
+	displayFooter()
+}
+
+func displayCommandHelp(cmd *command) {
 	printBanner()
 	fmt.Println(successStyle.Render(" usage:"))
-	fmt.Printf("    %s domains.txt | %s -target -\n", cmdStyle.Render("cat"), cmdStyle.Render("crtmon"))
-	fmt.Printf("    %s -target %s -config %s -notify=%s\n", cmdStyle.Render("crtmon"), argStyle.Render("example.com"), argStyle.Render("custom.yaml"), argStyle.Render("discord"))
-	fmt.Printf("    %s -target %s\n", cmdStyle.Render("crtmon"), argStyle.Render("domains.txt"))
-	fmt.Printf("    %s \"@reboot %s %s -target %s > /tmp/crtmon.log 2>&1 &\" | %s -\n\n", cmdStyle.Render("echo"), cmdStyle.Render("nohup"), cmdStyle.Render("crtmon"), argStyle.Render("example.com"), cmdStyle.Render("crontab"))
-
-	fmt.Println(successStyle.Render(" options:"))
-	fmt.Printf("    %s      target domain(s) to monitor:\n", flagStyle.Render("-target"))
-	fmt.Printf("                   single domain: %s\n", argStyle.Render("-target example.com"))
-	fmt.Printf("                   file with domains: %s\n", argStyle.Render("-target targets.txt"))
-	fmt.Printf("                   stdin: %s\n", argStyle.Render("-target -"))
-	fmt.Printf("    %s       scope keyword to filter subdomains\n", flagStyle.Render("-scope"))
-	fmt.Printf("    %s      path to configuration file (default: ~/.config/crtmon/provider.yaml)\n", flagStyle.Render("-config"))
-	fmt.Printf("    %s      notification provider: discord, telegram, both\n", flagStyle.Render("-notify"))
-	fmt.Printf("    %s        output results in JSON format (suppresses all other output)\n", flagStyle.Render("-json"))
-	fmt.Printf("    %s     show version\n", flagStyle.Render("-version"))
-	fmt.Printf("    %s      update to latest version\n", flagStyle.Render("-update"))
-	fmt.Printf("    %s    show this help message\n\n", flagStyle.Render("-h, -help"))
+	usage := cmdStyle.Render("crtmon") + " " + cmd.name
+	if cmd.args != "" {
+		usage += " " + argStyle.Render(cmd.args)
+	}
+	if cmd.flags != nil {
+		usage += " " + argStyle.Render("[flags]")
+	}
+	fmt.Printf("    %s\n", usage)
+	for _, example := range cmd.examples {
+		fmt.Printf("    %s\n", renderExample(example))
+	}
+	fmt.Println()
+	fmt.Printf("    %s\n\n", cmd.summary)
+
+	if cmd.flags != nil {
+		fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+		cmd.flags(fs)
+
+		fmt.Println(successStyle.Render(" options:"))
+		width := len("-h, -help")
+		fs.VisitAll(func(f *flag.Flag) {
+			width = max(width, len(f.Name)+1)
+		})
+		fs.VisitAll(func(f *flag.Flag) {
+			_, usage := flag.UnquoteUsage(f)
+			lines := strings.Split(usage, "\n")
+			if f.DefValue != "" && f.DefValue != "false" && f.DefValue != "0" && !strings.Contains(f.Usage, "default") {
+				lines[0] += fmt.Sprintf(" (default: %s)", f.DefValue)
+			}
+			fmt.Printf("    %s  %s\n", flagStyle.Render(pad("-"+f.Name, width)), lines[0])
+			for _, line := range lines[1:] {
+				label, example, found := strings.Cut(line, ": ")
+				if found {
+					line = label + ": " + argStyle.Render(example)
+				}
+				fmt.Printf("    %s     %s\n", pad("", width), line)
+			}
+		})
+		fmt.Printf("    %s  show this help message\n\n", flagStyle.Render(pad("-h, -help", width)))
+	}
+
+	displayFooter()
+}
 
+func displayFooter() {
 	fmt.Println(successStyle.Render(" configuration:"))
 	fmt.Printf("    %s config file location: ~/.config/crtmon/provider.yaml\n", argStyle.Render("•"))
-	fmt.Printf("    %s supports multiple targets and notification providers\n\n", argStyle.Render("•"))
+	fmt.Printf("    %s supports multiple targets and notification providers\n", argStyle.Render("•"))
+	fmt.Printf("    %s named profiles route each client's matches to its own providers\n\n", argStyle.Render("•"))
 
 	fmt.Println(argStyle.Render(" monitor your targets real time via certificate transparency logs"))
 	fmt.Println(argStyle.Render(" powered by github.com/google/certificate-transparency-go"))
 	fmt.Println()
 }
+
+// renderExample colours an example invocation the same way the original
+// hand-written usage lines did: known commands in cyan, arguments dimmed.
+func renderExample(example string) string {
+	words := strings.Split(example, " ")
+	for i, word := range words {
+		switch {
+		case isCyanCommand(word):
+			words[i] = cmdStyle.Render(word)
+		case strings.HasPrefix(word, "-"), word == "|", word == ">", word == "&", word == "2>&1":
+		default:
+			if i > 0 && strings.HasPrefix(words[i-1], "-") {
+				words[i] = argStyle.Render(word)
+			}
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+func pad(s string, width int) string {
+	if len(s) >= width {
+		return s
+	}
+	return s + strings.Repeat(" ", width-len(s))
+}
@@ -0,0 +1,648 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	// pure-Go SQLite driver, so crtmon stays cgo-free and cross-compiles
+	_ "modernc.org/sqlite"
+)
+
+const (
+	historyTimeFormat = "2006-01-02T15:04:05Z"
+	historyBatchSize  = 500
+	historyQueueSize  = 1000
+)
+
+// historyDriver is the database/sql driver used for the history database.
+const historyDriver = "sqlite"
+
+const historySchema = `
+CREATE TABLE IF NOT EXISTS targets (
+	id      INTEGER PRIMARY KEY,
+	profile TEXT NOT NULL,
+	name    TEXT NOT NULL,
+	UNIQUE (profile, name)
+);
+CREATE TABLE IF NOT EXISTS domains (
+	id         INTEGER PRIMARY KEY,
+	name       TEXT NOT NULL UNIQUE,
+	first_seen TEXT NOT NULL,
+	last_seen  TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS certificates (
+	id                  INTEGER PRIMARY KEY,
+	fingerprint         TEXT NOT NULL UNIQUE,
+	serial_number       TEXT NOT NULL,
+	subject             TEXT NOT NULL,
+	issuer              TEXT NOT NULL,
+	issuer_dn           TEXT NOT NULL,
+	issuer_org          TEXT NOT NULL,
+	not_before          TEXT NOT NULL,
+	not_after           TEXT NOT NULL,
+	key_algorithm       TEXT NOT NULL,
+	key_size            INTEGER NOT NULL,
+	signature_algorithm TEXT NOT NULL,
+	validation          TEXT NOT NULL,
+	entry_type          TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS sightings (
+	id             INTEGER PRIMARY KEY,
+	target_id      INTEGER NOT NULL REFERENCES targets (id),
+	domain_id      INTEGER NOT NULL REFERENCES domains (id),
+	certificate_id INTEGER REFERENCES certificates (id), -- NULL when the source carried no certificate
+	log_url        TEXT NOT NULL,
+	log_index      INTEGER NOT NULL,
+	log_timestamp  TEXT NOT NULL,
+	observed_at    TEXT NOT NULL,
+	UNIQUE (target_id, domain_id, certificate_id, log_url)
+);
+CREATE INDEX IF NOT EXISTS sightings_observed ON sightings (observed_at);
+CREATE INDEX IF NOT EXISTS sightings_target ON sightings (target_id, observed_at);
+`
+
+var (
+	historyPath string
+	noHistory   bool
+	history     *historyDB
+)
+
+func addHistoryFlags(fs *flag.FlagSet) {
+	fs.StringVar(&historyPath, "history-db", "", "SQLite database `file` recording every match (default: ~/.config/crtmon/history.db)")
+	fs.BoolVar(&noHistory, "no-history", false, "do not record matches in the history database")
+}
+
+func historyDBPath() (string, error) {
+	if historyPath != "" {
+		return expandHome(historyPath)
+	}
+	dir, err := getConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "history.db"), nil
+}
+
+// applyHistoryConfig opens the history database for monitor, search and
+// import.
+func applyHistoryConfig(cfg *Config) error {
+	if cfg != nil && cfg.History != "" && historyPath == "" {
+		historyPath = cfg.History
+	}
+	if noHistory {
+		return nil
+	}
+
+	path, err := historyDBPath()
+	if err != nil {
+		return err
+	}
+	db, err := openHistory(path)
+	if err != nil {
+		return err
+	}
+	history = db
+	go history.run()
+	return nil
+}
+
+// historyDB records matches in the background, batching them into
+// transactions so a burst of matches does not hold up processEntry.
+type historyDB struct {
+	db      *sql.DB
+	records chan historyRecord
+	done    chan struct{}
+}
+
+type historyRecord struct {
+	profile, target, domain string
+	entry                   CertEntry
+	observed                time.Time
+}
+
+func openHistory(path string) (*historyDB, error) {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	db, err := sql.Open(historyDriver, path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer; a single connection avoids busy errors
+	// between the recorder and itself.
+	db.SetMaxOpenConns(1)
+	for _, stmt := range []string{"PRAGMA journal_mode = WAL", "PRAGMA busy_timeout = 5000", historySchema} {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to initialise history database %s: %w", path, err)
+		}
+	}
+	return &historyDB{
+		db:      db,
+		records: make(chan historyRecord, historyQueueSize),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+func (h *historyDB) record(p *profile, domain, target string, entry CertEntry) {
+	h.records <- historyRecord{profile: p.name, target: target, domain: strings.ToLower(domain), entry: entry, observed: time.Now()}
+}
+
+func (h *historyDB) run() {
+	defer close(h.done)
+	for rec := range h.records {
+		batch := []historyRecord{rec}
+	collect:
+		for len(batch) < historyBatchSize {
+			select {
+			case rec, ok := <-h.records:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, rec)
+			default:
+				break collect
+			}
+		}
+		if err := h.write(batch); err != nil {
+			logger.Error("failed to record match history", "matches", len(batch), "error", err)
+		}
+	}
+}
+
+func (h *historyDB) write(batch []historyRecord) error {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, rec := range batch {
+		e := rec.entry
+		observed := rec.observed.UTC().Format(historyTimeFormat)
+
+		var targetID, domainID int64
+		var certID sql.NullInt64
+		if err := tx.QueryRow(`INSERT INTO targets (profile, name) VALUES (?, ?)
+			ON CONFLICT (profile, name) DO UPDATE SET name = excluded.name RETURNING id`,
+			rec.profile, rec.target).Scan(&targetID); err != nil {
+			return err
+		}
+		// imported sightings can predate the ones already recorded
+		if err := tx.QueryRow(`INSERT INTO domains (name, first_seen, last_seen) VALUES (?, ?, ?)
+			ON CONFLICT (name) DO UPDATE SET first_seen = min(first_seen, excluded.first_seen),
+				last_seen = max(last_seen, excluded.last_seen) RETURNING id`,
+			rec.domain, observed, observed).Scan(&domainID); err != nil {
+			return err
+		}
+		if key := certificateKey(e); key != "" {
+			if err := tx.QueryRow(`INSERT INTO certificates (fingerprint, serial_number, subject, issuer, issuer_dn, issuer_org,
+					not_before, not_after, key_algorithm, key_size, signature_algorithm, validation, entry_type)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+				ON CONFLICT (fingerprint) DO UPDATE SET fingerprint = excluded.fingerprint RETURNING id`,
+				key, e.SerialNumber, e.Subject, e.Issuer, e.IssuerDN, e.IssuerOrg,
+				e.NotBefore.UTC().Format(historyTimeFormat), e.NotAfter.UTC().Format(historyTimeFormat),
+				e.KeyAlgorithm, e.KeySize, e.SignatureAlgorithm, e.Validation, e.EntryType).Scan(&certID); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(`INSERT INTO sightings (target_id, domain_id, certificate_id, log_url, log_index, log_timestamp, observed_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?) ON CONFLICT DO NOTHING`,
+			targetID, domainID, certID, e.LogURL, e.LogIndex, e.LogTimestamp.UTC().Format(historyTimeFormat), observed); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// certificateKey identifies the certificate of an entry in the certificates
+// table. Sources without a fingerprint, such as certstream's lite stream
+// and crt.sh exports, are keyed by issuer and serial number, which RFC 5280
+// makes unique; the prefix keeps these apart from real fingerprints. It
+// returns "" when the entry carries no certificate at all, as certstream's
+// domains-only stream does, so unrelated matches never share a row.
+func certificateKey(e CertEntry) string {
+	switch {
+	case e.Fingerprint != "":
+		return e.Fingerprint
+	case e.SerialNumber == "":
+		return ""
+	}
+	sum := sha256.Sum256([]byte(e.IssuerDN + "|" + e.Issuer + "|" + e.SerialNumber))
+	return "serial:" + hex.EncodeToString(sum[:])
+}
+
+// close records the matches still queued and closes the database.
+func (h *historyDB) close() {
+	close(h.records)
+	<-h.done
+	h.db.Close()
+}
+
+func closeHistory() {
+	if history != nil {
+		history.close()
+	}
+}
+
+var historyQuery struct {
+	target, issuer, match string
+	since, until          string
+	format                string
+	newOnly               bool
+	limit                 int
+}
+
+func addHistoryQueryFlags(fs *flag.FlagSet) {
+	fs.StringVar(&configFlag, "config", "", "path to configuration `file` (default: ~/.config/crtmon/provider.yaml)")
+	fs.StringVar(&historyPath, "history-db", "", "SQLite database `file` recording every match (default: ~/.config/crtmon/history.db)")
+	fs.StringVar(&historyQuery.target, "target", "", "only matches of this target")
+	fs.StringVar(&historyQuery.issuer, "issuer", "", "only certificates whose issuer name or organization contains this")
+	fs.StringVar(&historyQuery.match, "match", "", "only domains matching this regex")
+	fs.StringVar(&historyQuery.since, "since", "", "only matches seen since this date (2006-01-02, RFC 3339) or duration ago (36h, 7d)")
+	fs.StringVar(&historyQuery.until, "until", "", "only matches seen before this date or duration ago")
+	fs.BoolVar(&historyQuery.newOnly, "new", false, "with domains, only domains first seen for their target within -since/-until")
+	fs.StringVar(&historyQuery.format, "format", "table", "output format: table, json, csv, tsv")
+	fs.IntVar(&historyQuery.limit, "limit", 0, "show at most this many rows (default: all)")
+}
+
+// parseTimeFlag accepts a date, an RFC 3339 time or a duration before now,
+// where a d suffix counts days.
+func parseTimeFlag(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: use a date (2006-01-02), RFC 3339 or a duration (36h, 7d)", value)
+}
+
+type sighting struct {
+	Profile      string    `json:"profile"`
+	Target       string    `json:"target"`
+	Domain       string    `json:"domain"`
+	Issuer       string    `json:"issuer"`
+	IssuerOrg    string    `json:"issuer_org"`
+	Validation   string    `json:"validation,omitempty"`
+	Fingerprint  string    `json:"fingerprint_sha256"`
+	SerialNumber string    `json:"serial_number"`
+	EntryType    string    `json:"entry_type"`
+	NotBefore    time.Time `json:"not_before"`
+	NotAfter     time.Time `json:"not_after"`
+	LogURL       string    `json:"log_url"`
+	LogIndex     int64     `json:"log_index"`
+	ObservedAt   time.Time `json:"observed_at"`
+}
+
+func (h *historyDB) sightings(target, issuer string, since, until time.Time) ([]sighting, error) {
+	query := `SELECT t.profile, t.name, d.name, coalesce(c.issuer, ''), coalesce(c.issuer_org, ''), coalesce(c.validation, ''),
+			coalesce(c.fingerprint, ''), coalesce(c.serial_number, ''), coalesce(c.entry_type, ''),
+			coalesce(c.not_before, ''), coalesce(c.not_after, ''), s.log_url, s.log_index, s.observed_at
+		FROM sightings s
+		JOIN targets t ON t.id = s.target_id
+		JOIN domains d ON d.id = s.domain_id
+		LEFT JOIN certificates c ON c.id = s.certificate_id
+		WHERE 1 = 1`
+	var args []interface{}
+	if target != "" {
+		query += " AND t.name = ?"
+		args = append(args, target)
+	}
+	if issuer != "" {
+		query += " AND (instr(lower(c.issuer), lower(?)) > 0 OR instr(lower(c.issuer_org), lower(?)) > 0)"
+		args = append(args, issuer, issuer)
+	}
+	if !since.IsZero() {
+		query += " AND s.observed_at >= ?"
+		args = append(args, since.UTC().Format(historyTimeFormat))
+	}
+	if !until.IsZero() {
+		query += " AND s.observed_at < ?"
+		args = append(args, until.UTC().Format(historyTimeFormat))
+	}
+	query += " ORDER BY s.observed_at, s.id"
+
+	rows, err := h.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []sighting
+	for rows.Next() {
+		var s sighting
+		var notBefore, notAfter, observed string
+		if err := rows.Scan(&s.Profile, &s.Target, &s.Domain, &s.Issuer, &s.IssuerOrg, &s.Validation, &s.Fingerprint,
+			&s.SerialNumber, &s.EntryType, &notBefore, &notAfter, &s.LogURL, &s.LogIndex, &observed); err != nil {
+			return nil, err
+		}
+		s.NotBefore, _ = time.Parse(historyTimeFormat, notBefore)
+		s.NotAfter, _ = time.Parse(historyTimeFormat, notAfter)
+		s.ObservedAt, _ = time.Parse(historyTimeFormat, observed)
+		result = append(result, s)
+	}
+	return result, rows.Err()
+}
+
+type historyDomain struct {
+	Profile   string    `json:"profile"`
+	Target    string    `json:"target"`
+	Domain    string    `json:"domain"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Sightings int       `json:"sightings"`
+	Issuers   []string  `json:"issuers"`
+}
+
+type historyIssuer struct {
+	Profile      string    `json:"profile"`
+	Target       string    `json:"target"`
+	Issuer       string    `json:"issuer"`
+	Certificates int       `json:"certificates"`
+	Domains      int       `json:"domains"`
+	FirstSeen    time.Time `json:"first_seen"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+func issuerName(s sighting) string {
+	if s.IssuerOrg != "" {
+		return s.IssuerOrg
+	}
+	return s.Issuer
+}
+
+func groupDomains(sightings []sighting) []*historyDomain {
+	var domains []*historyDomain
+	byKey := make(map[[3]string]*historyDomain)
+	for _, s := range sightings {
+		key := [3]string{s.Profile, s.Target, s.Domain}
+		d := byKey[key]
+		if d == nil {
+			d = &historyDomain{Profile: s.Profile, Target: s.Target, Domain: s.Domain, FirstSeen: s.ObservedAt}
+			byKey[key] = d
+			domains = append(domains, d)
+		}
+		d.LastSeen = s.ObservedAt
+		d.Sightings++
+		if name := issuerName(s); name != "" && !containsString(d.Issuers, name) {
+			d.Issuers = append(d.Issuers, name)
+		}
+	}
+	return domains
+}
+
+func groupIssuers(sightings []sighting) []*historyIssuer {
+	var issuers []*historyIssuer
+	byKey := make(map[[3]string]*historyIssuer)
+	certs := make(map[[4]string]bool)
+	domains := make(map[[4]string]bool)
+	for _, s := range sightings {
+		// sightings without a certificate have no issuer to count
+		if s.Fingerprint == "" {
+			continue
+		}
+		name := issuerName(s)
+		key := [3]string{s.Profile, s.Target, name}
+		i := byKey[key]
+		if i == nil {
+			i = &historyIssuer{Profile: s.Profile, Target: s.Target, Issuer: name, FirstSeen: s.ObservedAt}
+			byKey[key] = i
+			issuers = append(issuers, i)
+		}
+		i.LastSeen = s.ObservedAt
+		if cert := [4]string{key[0], key[1], key[2], s.Fingerprint}; !certs[cert] {
+			certs[cert] = true
+			i.Certificates++
+		}
+		if domain := [4]string{key[0], key[1], key[2], s.Domain}; !domains[domain] {
+			domains[domain] = true
+			i.Domains++
+		}
+	}
+	sort.SliceStable(issuers, func(a, b int) bool {
+		if issuers[a].Target != issuers[b].Target {
+			return issuers[a].Target < issuers[b].Target
+		}
+		return issuers[a].Certificates > issuers[b].Certificates
+	})
+	return issuers
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func runHistory(args []string) {
+	view := "domains"
+	if len(args) > 0 {
+		view = args[0]
+	}
+	if view != "domains" && view != "issuers" && view != "sightings" {
+		logger.Fatal("unknown history view. valid options are: domains, issuers, sightings", "view", view)
+	}
+	format := strings.ToLower(historyQuery.format)
+	if format != "table" && format != "json" && format != "csv" && format != "tsv" {
+		logger.Fatal("invalid value for -format. valid options are: table, json, csv, tsv")
+	}
+
+	if configFlag != "" {
+		setConfigPath(configFlag)
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		logger.Fatal("failed to load config", "error", err)
+	}
+	if cfg != nil && cfg.History != "" && historyPath == "" {
+		historyPath = cfg.History
+	}
+
+	now := time.Now()
+	var since, until time.Time
+	if historyQuery.since != "" {
+		if since, err = parseTimeFlag(historyQuery.since, now); err != nil {
+			logger.Fatal("invalid -since", "error", err)
+		}
+	}
+	if historyQuery.until != "" {
+		if until, err = parseTimeFlag(historyQuery.until, now); err != nil {
+			logger.Fatal("invalid -until", "error", err)
+		}
+	}
+	var match *regexp.Regexp
+	if historyQuery.match != "" {
+		if match, err = regexp.Compile(historyQuery.match); err != nil {
+			logger.Fatal("invalid -match regex", "error", err)
+		}
+	}
+
+	path, err := historyDBPath()
+	if err != nil {
+		logger.Fatal("failed to locate history database", "error", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		logger.Fatal("no history database found; run crtmon monitor or search first", "path", path)
+	}
+	db, err := openHistory(path)
+	if err != nil {
+		logger.Fatal("failed to open history database", "error", err)
+	}
+	defer db.db.Close()
+
+	table, err := buildHistoryTable(db, view, since, until, match)
+	if err != nil {
+		logger.Fatal("failed to query history", "error", err)
+	}
+	if format == "table" && len(table.records) == 0 {
+		logger.Info("no matches recorded for this query")
+		return
+	}
+	if err := table.write(os.Stdout, format); err != nil {
+		logger.Fatal("failed to write history", "error", err)
+	}
+}
+
+// historyTable is one history view, as rows for -format json and as
+// records for the other formats.
+type historyTable struct {
+	header  []string
+	records [][]string
+	rows    interface{}
+}
+
+// buildHistoryTable reads the sightings matching the -target and -issuer
+// flags, the since/until window and the match regex, and groups them into
+// view.
+func buildHistoryTable(db *historyDB, view string, since, until time.Time, match *regexp.Regexp) (*historyTable, error) {
+	// the first sighting of a domain can predate -since, so -new reads
+	// everything and filters by first seen afterwards
+	querySince, queryUntil := since, until
+	if historyQuery.newOnly && view == "domains" {
+		querySince, queryUntil = time.Time{}, time.Time{}
+	}
+	sightings, err := db.sightings(historyQuery.target, historyQuery.issuer, querySince, queryUntil)
+	if err != nil {
+		return nil, err
+	}
+	if match != nil {
+		filtered := sightings[:0]
+		for _, s := range sightings {
+			if match.MatchString(s.Domain) {
+				filtered = append(filtered, s)
+			}
+		}
+		sightings = filtered
+	}
+
+	table := &historyTable{}
+	switch view {
+	case "domains":
+		domains := groupDomains(sightings)
+		if historyQuery.newOnly {
+			filtered := domains[:0]
+			for _, d := range domains {
+				if (since.IsZero() || !d.FirstSeen.Before(since)) && (until.IsZero() || d.FirstSeen.Before(until)) {
+					filtered = append(filtered, d)
+				}
+			}
+			domains = filtered
+		}
+		domains = limitRows(domains, historyQuery.limit)
+		table.header = []string{"target", "domain", "first_seen", "last_seen", "sightings", "issuers"}
+		for _, d := range domains {
+			table.records = append(table.records, []string{d.Target, d.Domain, d.FirstSeen.Local().Format(time.DateTime), d.LastSeen.Local().Format(time.DateTime),
+				strconv.Itoa(d.Sightings), strings.Join(d.Issuers, ", ")})
+		}
+		table.rows = domains
+	case "issuers":
+		issuers := groupIssuers(sightings)
+		if historyQuery.limit > 0 && len(issuers) > historyQuery.limit {
+			issuers = issuers[:historyQuery.limit]
+		}
+		table.header = []string{"target", "issuer", "certificates", "domains", "first_seen", "last_seen"}
+		for _, i := range issuers {
+			table.records = append(table.records, []string{i.Target, i.Issuer, strconv.Itoa(i.Certificates), strconv.Itoa(i.Domains),
+				i.FirstSeen.Local().Format(time.DateTime), i.LastSeen.Local().Format(time.DateTime)})
+		}
+		table.rows = issuers
+	case "sightings":
+		sightings = limitRows(sightings, historyQuery.limit)
+		table.header = []string{"observed_at", "target", "domain", "issuer", "validation", "entry_type", "fingerprint_sha256", "log_url", "log_index"}
+		for _, s := range sightings {
+			table.records = append(table.records, []string{s.ObservedAt.Local().Format(time.DateTime), s.Target, s.Domain, issuerName(s), s.Validation,
+				s.EntryType, s.Fingerprint, s.LogURL, strconv.FormatInt(s.LogIndex, 10)})
+		}
+		table.rows = sightings
+	default:
+		return nil, fmt.Errorf("unknown history view %q", view)
+	}
+	return table, nil
+}
+
+func (t *historyTable) write(out io.Writer, format string) error {
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(t.rows, "", "  ")
+		if err != nil {
+			return err
+		}
+		if string(data) == "null" {
+			data = []byte("[]")
+		}
+		_, err = fmt.Fprintln(out, string(data))
+		return err
+	case "csv", "tsv":
+		w := csv.NewWriter(out)
+		if format == "tsv" {
+			w.Comma = '\t'
+		}
+		w.Write(t.header)
+		w.WriteAll(t.records)
+		return w.Error()
+	default:
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, strings.ToUpper(strings.Join(t.header, "\t")))
+		for _, r := range t.records {
+			fmt.Fprintln(w, strings.Join(r, "\t"))
+		}
+		return w.Flush()
+	}
+}
+
+// limitRows keeps the last n rows, which are the most recent ones.
+func limitRows[T any](rows []T, n int) []T {
+	if n > 0 && len(rows) > n {
+		return rows[len(rows)-n:]
+	}
+	return rows
+}
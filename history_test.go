@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTimeFlag(t *testing.T) {
+	now := time.Date(2025, 6, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{value: "36h", want: now.Add(-36 * time.Hour)},
+		{value: "7d", want: time.Date(2025, 6, 3, 12, 0, 0, 0, time.UTC)},
+		{value: " 0d ", want: now},
+		{value: "2025-06-01T08:00:00Z", want: time.Date(2025, 6, 1, 8, 0, 0, 0, time.UTC)},
+		{value: "2025-06-01", want: time.Date(2025, 6, 1, 0, 0, 0, 0, time.Local)},
+		{value: "xd", wantErr: true},
+		{value: "yesterday", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseTimeFlag(tt.value, now)
+		if (err != nil) != tt.wantErr || !got.Equal(tt.want) {
+			t.Errorf("parseTimeFlag(%q) = %s, %v, want %s", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestCertificateKey(t *testing.T) {
+	lite := CertEntry{IssuerDN: "CN=R11,O=Let's Encrypt,C=US", Issuer: "R11", SerialNumber: "0a1b"}
+	otherSerial := lite
+	otherSerial.SerialNumber = "0a1c"
+
+	if got := certificateKey(CertEntry{Fingerprint: "ff00", SerialNumber: "0a1b"}); got != "ff00" {
+		t.Errorf("certificateKey() = %q, want the fingerprint", got)
+	}
+	if got := certificateKey(CertEntry{Domains: []string{"a.example.com"}}); got != "" {
+		t.Errorf("certificateKey() = %q for an entry without a certificate", got)
+	}
+	key := certificateKey(lite)
+	if !strings.HasPrefix(key, "serial:") || key == certificateKey(otherSerial) {
+		t.Errorf("certificateKey() = %q and %q, want distinct serial keys", key, certificateKey(otherSerial))
+	}
+	if certificateKey(lite) != key {
+		t.Error("certificateKey() is not stable")
+	}
+}
+
+func openTestHistory(t *testing.T) *historyDB {
+	t.Helper()
+	db, err := openHistory(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.db.Close() })
+	return db
+}
+
+// TestHistoryCertificatesWithoutFingerprint records entries that carry no
+// fingerprint and checks that each keeps its own certificate row.
+func TestHistoryCertificatesWithoutFingerprint(t *testing.T) {
+	db := openTestHistory(t)
+	observed := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	batch := []historyRecord{
+		{profile: defaultProfile, target: "example", domain: "a.example.com", observed: observed,
+			entry: CertEntry{Issuer: "R11", IssuerOrg: "Let's Encrypt", SerialNumber: "01", LogURL: "https://ct.example.com/"}},
+		{profile: defaultProfile, target: "example", domain: "b.example.com", observed: observed.Add(time.Minute),
+			entry: CertEntry{Issuer: "WR1", IssuerOrg: "Google Trust Services", SerialNumber: "02", LogURL: "https://ct.example.com/"}},
+		{profile: defaultProfile, target: "example", domain: "c.example.com", observed: observed.Add(2 * time.Minute),
+			entry: CertEntry{LogURL: "wss://certstream.example.com/"}},
+		{profile: defaultProfile, target: "example", domain: "d.example.com", observed: observed.Add(3 * time.Minute),
+			entry: CertEntry{LogURL: "wss://certstream.example.com/"}},
+	}
+	if err := db.write(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	var certificates int
+	if err := db.db.QueryRow("SELECT count(*) FROM certificates").Scan(&certificates); err != nil {
+		t.Fatal(err)
+	}
+	if certificates != 2 {
+		t.Errorf("recorded %d certificates, want 2", certificates)
+	}
+	sightings, err := db.sightings("", "", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range sightings {
+		got = append(got, s.Domain+"="+issuerName(s)+"/"+s.SerialNumber)
+	}
+	want := "a.example.com=Let's Encrypt/01,b.example.com=Google Trust Services/02,c.example.com=/,d.example.com=/"
+	if strings.Join(got, ",") != want {
+		t.Errorf("sightings = %s, want %s", strings.Join(got, ","), want)
+	}
+}
+
+// seedHistory records a small history: example.com names seen with two
+// certificates over ten days, one name without a certificate and a second
+// profile's target.
+func seedHistory(t *testing.T) *historyDB {
+	t.Helper()
+	db := openTestHistory(t)
+	day := func(d int) time.Time { return time.Date(2025, 6, d, 12, 0, 0, 0, time.UTC) }
+	le := CertEntry{Fingerprint: "aa", Issuer: "R11", IssuerOrg: "Let's Encrypt", LogURL: "https://ct.example.com/", LogIndex: 1}
+	gts := CertEntry{Fingerprint: "bb", Issuer: "WR1", IssuerOrg: "Google Trust Services", LogURL: "https://ct.example.com/", LogIndex: 2}
+	batch := []historyRecord{
+		{profile: defaultProfile, target: "example", domain: "api.example.com", entry: le, observed: day(1)},
+		{profile: "red", target: "other", domain: "mail.other.org", entry: le, observed: day(5)},
+		{profile: defaultProfile, target: "example", domain: "api.example.com", entry: gts, observed: day(9)},
+		{profile: defaultProfile, target: "example", domain: "www.example.com", entry: gts, observed: day(9)},
+		{profile: defaultProfile, target: "example", domain: "new.example.com", entry: CertEntry{LogURL: "wss://certstream.example.com/"}, observed: day(10)},
+	}
+	if err := db.write(batch); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestBuildHistoryTable(t *testing.T) {
+	saved := historyQuery
+	defer func() { historyQuery = saved }()
+	db := seedHistory(t)
+	since := time.Date(2025, 6, 8, 0, 0, 0, 0, time.UTC)
+
+	// the columns compared for each view; the dates are formatted in the
+	// local time zone
+	columns := map[string][]int{
+		"domains":   {0, 1, 4, 5},
+		"issuers":   {0, 1, 2, 3},
+		"sightings": {1, 2, 3, 8},
+	}
+	tests := []struct {
+		name    string
+		view    string
+		target  string
+		issuer  string
+		newOnly bool
+		limit   int
+		since   time.Time
+		match   string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "domains",
+			view: "domains",
+			want: []string{
+				"example|api.example.com|2|Let's Encrypt, Google Trust Services",
+				"other|mail.other.org|1|Let's Encrypt",
+				"example|www.example.com|1|Google Trust Services",
+				"example|new.example.com|1|",
+			},
+		},
+		{
+			name:  "since",
+			view:  "domains",
+			since: since,
+			want: []string{
+				"example|api.example.com|1|Google Trust Services",
+				"example|www.example.com|1|Google Trust Services",
+				"example|new.example.com|1|",
+			},
+		},
+		{
+			name:    "new since",
+			view:    "domains",
+			since:   since,
+			newOnly: true,
+			want: []string{
+				"example|www.example.com|1|Google Trust Services",
+				"example|new.example.com|1|",
+			},
+		},
+		{name: "match", view: "domains", match: `^api\.`, want: []string{"example|api.example.com|2|Let's Encrypt, Google Trust Services"}},
+		{name: "target", view: "domains", target: "other", want: []string{"other|mail.other.org|1|Let's Encrypt"}},
+		{
+			name:   "issuer",
+			view:   "domains",
+			issuer: "google",
+			want: []string{
+				"example|api.example.com|1|Google Trust Services",
+				"example|www.example.com|1|Google Trust Services",
+			},
+		},
+		{name: "limit keeps the newest", view: "domains", limit: 1, want: []string{"example|new.example.com|1|"}},
+		{
+			name: "issuers",
+			view: "issuers",
+			want: []string{
+				"example|Let's Encrypt|1|1",
+				"example|Google Trust Services|1|2",
+				"other|Let's Encrypt|1|1",
+			},
+		},
+		{
+			name:  "sightings",
+			view:  "sightings",
+			since: since,
+			match: `example\.com$`,
+			want: []string{
+				"example|api.example.com|Google Trust Services|2",
+				"example|www.example.com|Google Trust Services|2",
+				"example|new.example.com||0",
+			},
+		},
+		{name: "unknown view", view: "certificates", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			historyQuery.target, historyQuery.issuer = tt.target, tt.issuer
+			historyQuery.newOnly, historyQuery.limit = tt.newOnly, tt.limit
+			var match *regexp.Regexp
+			if tt.match != "" {
+				match = regexp.MustCompile(tt.match)
+			}
+
+			table, err := buildHistoryTable(db, tt.view, tt.since, time.Time{}, match)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("buildHistoryTable() succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, r := range table.records {
+				var fields []string
+				for _, c := range columns[tt.view] {
+					fields = append(fields, r[c])
+				}
+				got = append(got, strings.Join(fields, "|"))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("records =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestHistoryTableWrite(t *testing.T) {
+	table := &historyTable{
+		header:  []string{"target", "domain"},
+		records: [][]string{{"example", "api.example.com"}, {"example", "a,b.example.com"}},
+		rows:    []map[string]string{{"domain": "api.example.com"}},
+	}
+	tests := []struct {
+		format string
+		table  *historyTable
+		want   string
+	}{
+		{"csv", table, "target,domain\nexample,api.example.com\nexample,\"a,b.example.com\"\n"},
+		{"tsv", table, "target\tdomain\nexample\tapi.example.com\nexample\ta,b.example.com\n"},
+		{"table", table, "TARGET   DOMAIN\nexample  api.example.com\nexample  a,b.example.com\n"},
+		{"json", table, "[\n  {\n    \"domain\": \"api.example.com\"\n  }\n]\n"},
+		{"json", &historyTable{rows: []*historyDomain(nil)}, "[]\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := tt.table.write(&buf, tt.format); err != nil {
+			t.Fatalf("write(%s) error = %v", tt.format, err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("write(%s) = %q, want %q", tt.format, buf.String(), tt.want)
+		}
+	}
+}
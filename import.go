@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var importFormat string
+
+func addImportFlags(fs *flag.FlagSet) {
+	fs.StringVar(&configFlag, "config", "", "path to configuration `file` (default: ~/.config/crtmon/provider.yaml)")
+	fs.StringVar(&targetFlag, "target", "", "only import domains containing these target(s): a domain, a `file` with domains, or - for stdin")
+	fs.StringVar(&knownPath, "known", "", "`file` of already known domains to add to (default: ~/.config/crtmon/known_domains.txt)")
+	fs.StringVar(&importFormat, "format", "auto", "input format: auto, json (crt.sh, Censys and similar exports), text (one domain per line)")
+	addHistoryFlags(fs)
+}
+
+// importNameFields are the fields holding certificate names in crt.sh
+// (name_value, common_name), Censys (names, parsed.names, dns_names) and
+// subdomain tool exports (host, domain). Dotted keys are read both as a
+// flattened key and as a path of nested objects.
+var importNameFields = []string{
+	"name_value",
+	"common_name",
+	"names",
+	"dns_names",
+	"parsed.names",
+	"parsed.subject.common_name",
+	"parsed.extensions.subject_alt_name.dns_names",
+	"host",
+	"domain",
+}
+
+// importLogURL stands in for the log URL of imported sightings, which the
+// exports do not carry.
+const importLogURL = "import"
+
+// importRecord is one exported certificate, or one line of a text list.
+// entry is nil when the record carries no certificate details, as plain
+// domain lists do; those only update the known domains.
+type importRecord struct {
+	names []string
+	entry *CertEntry
+}
+
+func runImport(args []string) {
+	if len(args) == 0 {
+		logger.Fatal("no input files. usage: crtmon import [flags] <file|->...")
+	}
+	format := strings.ToLower(importFormat)
+	if format != "auto" && format != "json" && format != "text" {
+		logger.Fatal("invalid value for -format. valid options are: auto, json, text")
+	}
+
+	if configFlag != "" {
+		setConfigPath(configFlag)
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		logger.Fatal("failed to load config", "error", err)
+	}
+
+	var targets []string
+	if targetFlag != "" {
+		if targets, err = resolveTargetFlag(targetFlag); err != nil {
+			logger.Fatal("failed to read targets", "error", err)
+		}
+	}
+
+	if err := applyHistoryConfig(cfg); err != nil {
+		logger.Fatal("failed to open history database", "error", err)
+	}
+	defer closeHistory()
+	historyTargets := importHistoryTargets(cfg, targets)
+
+	path, err := knownDomainsPath(cfg)
+	if err != nil {
+		logger.Fatal("failed to locate known domains", "error", err)
+	}
+	set, err := openKnownSet(path)
+	if err != nil {
+		logger.Fatal("failed to load known domains", "error", err)
+	}
+
+	total, added, skipped, sightings := 0, 0, 0, 0
+	for _, name := range args {
+		records, err := readImportFile(name, format)
+		if err != nil {
+			logger.Fatal("failed to import", "file", name, "error", err)
+		}
+
+		var domains []string
+		seen := make(map[string]bool)
+		names := 0
+		for _, rec := range records {
+			names += len(rec.names)
+			recorded := make(map[string]bool)
+			for _, n := range rec.names {
+				domain := normalizeDomain(n)
+				if domain == "" || !matchesAnyTarget(domain, targets) {
+					skipped++
+					continue
+				}
+				if !seen[domain] {
+					seen[domain] = true
+					domains = append(domains, domain)
+				}
+				if history != nil && rec.entry != nil && !recorded[domain] {
+					recorded[domain] = true
+					sightings += recordImportSighting(historyTargets, domain, rec.entry)
+				}
+			}
+		}
+
+		n, err := set.add(domains...)
+		if err != nil {
+			logger.Fatal("failed to update known domains", "path", path, "error", err)
+		}
+		logger.Debug("imported file", "file", name, "names", names, "domains", len(domains), "new", n)
+		total += len(domains)
+		added += n
+	}
+
+	logger.Info("import complete", "domains", total, "new", added, "already_known", total-added, "skipped", skipped, "path", path)
+	switch {
+	case history == nil:
+	case sightings == 0 && !hasTargets(historyTargets):
+		logger.Info("no targets configured, history database not seeded; use -target or a config file with targets")
+	default:
+		logger.Info("seeded history database", "sightings", sightings)
+	}
+}
+
+// importHistoryTargets returns the profiles whose targets imported
+// certificates are recorded under in the history database: the -target
+// list as the default profile, or every profile of the config file.
+func importHistoryTargets(cfg *Config, targets []string) []*profile {
+	if len(targets) > 0 {
+		return []*profile{{name: defaultProfile, targets: targets}}
+	}
+	if cfg == nil {
+		return nil
+	}
+	selected, err := selectProfiles(cfg, "all")
+	if err != nil {
+		logger.Fatal("failed to select profiles", "error", err)
+	}
+	return selected
+}
+
+func hasTargets(selected []*profile) bool {
+	for _, p := range selected {
+		if len(p.targets) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// recordImportSighting queues a history sighting of domain for every target
+// it matches, and returns how many were queued. Imported certificates are
+// dated by their log entry or, failing that, their notBefore so they do not
+// show up as new in history -new.
+func recordImportSighting(selected []*profile, domain string, entry *CertEntry) int {
+	observed := entry.LogTimestamp
+	if observed.IsZero() {
+		observed = entry.NotBefore
+	}
+	if observed.IsZero() {
+		observed = time.Now()
+	}
+
+	n := 0
+	for _, p := range selected {
+		for _, target := range p.targets {
+			if domainMatches(domain, target) {
+				history.records <- historyRecord{profile: p.name, target: target, domain: domain, entry: *entry, observed: observed}
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func matchesAnyTarget(domain string, targets []string) bool {
+	if len(targets) == 0 {
+		return true
+	}
+	for _, target := range targets {
+		if strings.Contains(domain, strings.ToLower(target)) {
+			return true
+		}
+	}
+	return false
+}
+
+func readImportFile(name, format string) ([]importRecord, error) {
+	var data []byte
+	var err error
+	if name == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if format == "auto" {
+		format = "text"
+		if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && (trimmed[0] == '[' || trimmed[0] == '{') {
+			format = "json"
+		}
+	}
+	if format == "text" {
+		return importText(data)
+	}
+	return importJSON(data)
+}
+
+func importText(data []byte) ([]importRecord, error) {
+	var records []importRecord
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		records = append(records, importRecord{names: []string{scanner.Text()}})
+	}
+	return records, scanner.Err()
+}
+
+// importJSON reads a JSON array of records, a single record or a stream of
+// records (NDJSON), as exported by crt.sh and Censys.
+func importJSON(data []byte) ([]importRecord, error) {
+	var result []importRecord
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var value any
+		err := dec.Decode(&value)
+		if errors.Is(err, io.EOF) {
+			return result, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON: %v", err)
+		}
+
+		records, ok := value.([]any)
+		if !ok {
+			records = []any{value}
+		}
+		for _, record := range records {
+			if fields, ok := record.(map[string]any); ok {
+				result = append(result, importRecord{names: recordNames(fields), entry: recordEntry(fields)})
+			}
+		}
+	}
+}
+
+func recordNames(record map[string]any) []string {
+	var names []string
+	for _, field := range importNameFields {
+		value, ok := record[field]
+		if !ok {
+			value, ok = lookupPath(record, strings.Split(field, "."))
+		}
+		if ok {
+			names = append(names, stringValues(value)...)
+		}
+	}
+	return names
+}
+
+// recordEntry reads the certificate details of a crt.sh record (issuer_name,
+// serial_number, not_before, not_after, entry_timestamp) or a Censys one
+// (parsed.issuer_dn, parsed.serial_number, parsed.validity,
+// fingerprint_sha256). crt.sh's id numbers its own database, not a log, so
+// the log index stays unset. It returns nil when the record has neither an
+// issuer nor a validity period, as subdomain tool exports do not.
+func recordEntry(record map[string]any) *CertEntry {
+	issuerDN := recordString(record, "issuer_name", "parsed.issuer_dn")
+	notBefore := recordTime(record, "not_before", "parsed.validity.start")
+	if issuerDN == "" && notBefore.IsZero() {
+		return nil
+	}
+
+	entry := &CertEntry{
+		IssuerDN:     issuerDN,
+		Issuer:       dnAttribute(issuerDN, "CN"),
+		IssuerOrg:    dnAttribute(issuerDN, "O"),
+		NotBefore:    notBefore,
+		NotAfter:     recordTime(record, "not_after", "parsed.validity.end"),
+		SerialNumber: recordString(record, "serial_number", "parsed.serial_number"),
+		Subject:      recordString(record, "common_name", "parsed.subject_dn"),
+		Fingerprint:  strings.ToLower(recordString(record, "fingerprint_sha256")),
+		LogTimestamp: recordTime(record, "entry_timestamp"),
+		LogURL:       importLogURL,
+		EntryType:    entryTypeCert,
+	}
+	return entry
+}
+
+// recordString returns the first of fields that holds a string or number.
+func recordString(record map[string]any, fields ...string) string {
+	for _, field := range fields {
+		value, ok := record[field]
+		if !ok {
+			value, ok = lookupPath(record, strings.Split(field, "."))
+		}
+		switch v := value.(type) {
+		case string:
+			if v != "" {
+				return strings.TrimSpace(v)
+			}
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+	}
+	return ""
+}
+
+// importTimeLayouts are the timestamp formats of crt.sh (no zone, UTC) and
+// Censys (RFC 3339).
+var importTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05",
+}
+
+func recordTime(record map[string]any, fields ...string) time.Time {
+	value := recordString(record, fields...)
+	for _, layout := range importTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.UTC); err == nil {
+			return t.UTC()
+		}
+	}
+	return time.Time{}
+}
+
+// dnAttribute returns the value of attribute key in a distinguished name
+// such as "C=US, O=Let's Encrypt, CN=R3".
+func dnAttribute(dn, key string) string {
+	for _, part := range strings.Split(dn, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if ok && strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+func lookupPath(record map[string]any, path []string) (any, bool) {
+	var value any = record
+	for _, key := range path {
+		fields, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if value, ok = fields[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// stringValues flattens a string or list of strings; crt.sh separates the
+// names of one certificate with newlines in name_value.
+func stringValues(value any) []string {
+	switch v := value.(type) {
+	case string:
+		return strings.Split(v, "\n")
+	case []any:
+		var values []string
+		for _, item := range v {
+			values = append(values, stringValues(item)...)
+		}
+		return values
+	}
+	return nil
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImportJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    [][]string
+		wantErr bool
+	}{
+		{
+			name: "crt.sh array",
+			data: `[{"common_name":"example.com","name_value":"example.com\nwww.example.com"},
+				{"common_name":"api.example.com","name_value":"api.example.com"}]`,
+			want: [][]string{{"example.com", "www.example.com", "example.com"}, {"api.example.com", "api.example.com"}},
+		},
+		{
+			name: "censys ndjson",
+			data: "{\"names\":[\"a.example.com\",\"b.example.com\"]}\n{\"parsed\":{\"names\":[\"c.example.com\"],\"subject\":{\"common_name\":[\"c.example.com\"]}}}\n",
+			want: [][]string{{"a.example.com", "b.example.com"}, {"c.example.com", "c.example.com"}},
+		},
+		{
+			name: "flattened censys keys",
+			data: `{"parsed.names":["d.example.com"],"parsed.extensions.subject_alt_name.dns_names":["e.example.com"]}`,
+			want: [][]string{{"d.example.com", "e.example.com"}},
+		},
+		{
+			name: "subdomain tool output",
+			data: `[{"host":"x.example.com"},{"domain":"y.example.com","ip":"192.0.2.1"}]`,
+			want: [][]string{{"x.example.com"}, {"y.example.com"}},
+		},
+		{
+			name: "non-object values are skipped",
+			data: `[1, "x", null, {"dns_names":["z.example.com"]}]`,
+			want: [][]string{{"z.example.com"}},
+		},
+		{name: "invalid", data: `[{"name_value":`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := importJSON([]byte(tt.data))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("importJSON() succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("importJSON() error = %v", err)
+			}
+			if len(records) != len(tt.want) {
+				t.Fatalf("importJSON() returned %d records, want %d", len(records), len(tt.want))
+			}
+			for i, want := range tt.want {
+				if got := strings.Join(records[i].names, ","); got != strings.Join(want, ",") {
+					t.Errorf("record %d names = %s, want %s", i, got, strings.Join(want, ","))
+				}
+			}
+		})
+	}
+}
+
+func TestRecordEntry(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		want   *CertEntry
+		wantFP string
+	}{
+		{
+			name: "crt.sh",
+			data: `{"issuer_name":"C=US, O=Let's Encrypt, CN=R11","common_name":"example.com","id":123456789,
+				"entry_timestamp":"2025-06-01T10:20:30.456","not_before":"2025-06-01T09:20:30","not_after":"2025-08-30T09:20:29",
+				"serial_number":"04a1b2"}`,
+			want: &CertEntry{
+				IssuerDN:     "C=US, O=Let's Encrypt, CN=R11",
+				Issuer:       "R11",
+				IssuerOrg:    "Let's Encrypt",
+				Subject:      "example.com",
+				SerialNumber: "04a1b2",
+				NotBefore:    time.Date(2025, 6, 1, 9, 20, 30, 0, time.UTC),
+				NotAfter:     time.Date(2025, 8, 30, 9, 20, 29, 0, time.UTC),
+				LogTimestamp: time.Date(2025, 6, 1, 10, 20, 30, 456000000, time.UTC),
+			},
+		},
+		{
+			name: "censys",
+			data: `{"fingerprint_sha256":"ABCDEF","parsed":{"issuer_dn":"C=US, O=Google Trust Services, CN=WR1",
+				"subject_dn":"CN=example.com","serial_number":"1234567890",
+				"validity":{"start":"2025-05-01T00:00:00Z","end":"2025-07-30T00:00:00Z"}}}`,
+			want: &CertEntry{
+				IssuerDN:     "C=US, O=Google Trust Services, CN=WR1",
+				Issuer:       "WR1",
+				IssuerOrg:    "Google Trust Services",
+				Subject:      "CN=example.com",
+				SerialNumber: "1234567890",
+				NotBefore:    time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC),
+				NotAfter:     time.Date(2025, 7, 30, 0, 0, 0, 0, time.UTC),
+			},
+			wantFP: "abcdef",
+		},
+		{
+			name: "space separated crt.sh time",
+			data: `{"issuer_name":"CN=Test CA","not_before":"2024-01-02 03:04:05"}`,
+			want: &CertEntry{
+				IssuerDN:  "CN=Test CA",
+				Issuer:    "Test CA",
+				NotBefore: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+			},
+		},
+		{name: "names only", data: `{"host":"x.example.com"}`, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := importJSON([]byte(tt.data))
+			if err != nil || len(records) != 1 {
+				t.Fatalf("importJSON() = %v, %v", records, err)
+			}
+			got := records[0].entry
+			if tt.want == nil {
+				if got != nil {
+					t.Fatalf("entry = %+v, want none", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatal("no entry")
+			}
+			if got.IssuerDN != tt.want.IssuerDN || got.Issuer != tt.want.Issuer || got.IssuerOrg != tt.want.IssuerOrg ||
+				got.Subject != tt.want.Subject || got.SerialNumber != tt.want.SerialNumber || got.LogIndex != tt.want.LogIndex {
+				t.Errorf("entry = %+v, want %+v", got, tt.want)
+			}
+			if !got.NotBefore.Equal(tt.want.NotBefore) || !got.NotAfter.Equal(tt.want.NotAfter) || !got.LogTimestamp.Equal(tt.want.LogTimestamp) {
+				t.Errorf("times = %s %s %s, want %s %s %s", got.NotBefore, got.NotAfter, got.LogTimestamp,
+					tt.want.NotBefore, tt.want.NotAfter, tt.want.LogTimestamp)
+			}
+			if got.LogURL != importLogURL || got.EntryType != entryTypeCert {
+				t.Errorf("log = %q %q", got.LogURL, got.EntryType)
+			}
+			if got.Fingerprint != tt.wantFP {
+				t.Errorf("fingerprint = %q, want %q", got.Fingerprint, tt.wantFP)
+			}
+		})
+	}
+}
+
+func TestDNAttribute(t *testing.T) {
+	tests := []struct {
+		dn, key, want string
+	}{
+		{"C=US, O=Let's Encrypt, CN=R11", "CN", "R11"},
+		{"C=US, O=Let's Encrypt, CN=R11", "O", "Let's Encrypt"},
+		{"c=US,o=Example", "O", "Example"},
+		{"CN=Only", "O", ""},
+		{"", "CN", ""},
+	}
+	for _, tt := range tests {
+		if got := dnAttribute(tt.dn, tt.key); got != tt.want {
+			t.Errorf("dnAttribute(%q, %q) = %q, want %q", tt.dn, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestReadImportFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	text := write("list.txt", "a.example.com\n\nB.example.com\n")
+	jsonFile := write("crtsh.json", "  \n[{\"name_value\":\"c.example.com\"}]")
+
+	tests := []struct {
+		name      string
+		path      string
+		format    string
+		wantNames string
+		wantErr   bool
+	}{
+		{name: "auto text", path: text, format: "auto", wantNames: "a.example.com,,B.example.com"},
+		{name: "auto json", path: jsonFile, format: "auto", wantNames: "c.example.com"},
+		{name: "forced text", path: jsonFile, format: "text", wantNames: `  ,[{"name_value":"c.example.com"}]`},
+		{name: "forced json on text", path: text, format: "json", wantErr: true},
+		{name: "missing file", path: filepath.Join(dir, "missing"), format: "auto", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := readImportFile(tt.path, tt.format)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("readImportFile() succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readImportFile() error = %v", err)
+			}
+			var names []string
+			for _, rec := range records {
+				if rec.entry != nil {
+					t.Errorf("record %v has certificate details", rec.names)
+				}
+				names = append(names, rec.names...)
+			}
+			if got := strings.Join(names, ","); got != tt.wantNames {
+				t.Errorf("names = %q, want %q", got, tt.wantNames)
+			}
+		})
+	}
+}
+
+func TestMatchesAnyTarget(t *testing.T) {
+	tests := []struct {
+		domain  string
+		targets []string
+		want    bool
+	}{
+		{"api.example.com", nil, true},
+		{"api.example.com", []string{"Example"}, true},
+		{"api.example.com", []string{"other", "api."}, true},
+		{"api.example.com", []string{"other"}, false},
+	}
+	for _, tt := range tests {
+		if got := matchesAnyTarget(tt.domain, tt.targets); got != tt.want {
+			t.Errorf("matchesAnyTarget(%q, %v) = %v, want %v", tt.domain, tt.targets, got, tt.want)
+		}
+	}
+}
+
+func TestImportSeedsHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	db, err := openHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := history
+	history = db
+	defer func() { history = saved }()
+	go db.run()
+
+	logged := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	issued := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	selected := importHistoryTargets(nil, []string{"example", "api."})
+	tests := []struct {
+		domain string
+		entry  CertEntry
+		want   int
+	}{
+		{"api.example.com", CertEntry{Fingerprint: "aa", Issuer: "R11", LogTimestamp: logged, NotBefore: issued, LogURL: importLogURL}, 2},
+		{"www.example.com", CertEntry{Fingerprint: "bb", Issuer: "R11", NotBefore: issued, LogURL: importLogURL}, 1},
+		{"other.org", CertEntry{Fingerprint: "cc", NotBefore: issued, LogURL: importLogURL}, 0},
+	}
+	for _, tt := range tests {
+		if got := recordImportSighting(selected, tt.domain, &tt.entry); got != tt.want {
+			t.Errorf("recordImportSighting(%q) = %d, want %d", tt.domain, got, tt.want)
+		}
+	}
+	db.close()
+
+	db, err = openHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.db.Close()
+	sightings, err := db.sightings("", "", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range sightings {
+		got = append(got, s.Profile+"/"+s.Target+"/"+s.Domain+"@"+s.ObservedAt.Format(time.DateOnly))
+	}
+	want := "default/example/www.example.com@2023-01-01,default/example/api.example.com@2024-03-01,default/api./api.example.com@2024-03-01"
+	if strings.Join(got, ",") != want {
+		t.Errorf("sightings = %q, want %q", got, want)
+	}
+}
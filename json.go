@@ -6,29 +6,155 @@ import (
 	"time"
 )
 
-func outputJSON(domain, target string, entry CertEntry) {
-	data := map[string]interface{}{
-		"domain":     domain,
-		"target":     target,
-		"not_before": entry.NotBefore.Format(time.RFC3339),
-		"not_after":  entry.NotAfter.Format(time.RFC3339),
-		"issuer":     entry.Issuer,
-		"log_url":    entry.LogURL,
-		"timestamp":  time.Now().Format(time.RFC3339),
+// schemaVersion is bumped whenever a field of the JSON events is removed,
+// renamed or changes meaning. Added fields do not change it.
+const schemaVersion = 1
+
+const (
+	eventMatch = "match"
+	eventAlert = "alert"
+	eventError = "error"
+)
+
+// matchEvent is written for every target match with -json.
+type matchEvent struct {
+	Event         string          `json:"event" const:"match" doc:"Event type."`
+	SchemaVersion int             `json:"schema_version" doc:"Version of the crtmon event schema."`
+	Profile       string          `json:"profile,omitempty" doc:"Profile that matched; omitted for the top-level settings."`
+	Domain        string          `json:"domain" doc:"Domain from the certificate that matched."`
+	Target        string          `json:"target" doc:"Configured target that matched."`
+	Rule          matchRule       `json:"rule" doc:"Rule the domain matched."`
+	Certificate   certificateInfo `json:"certificate" doc:"Certificate the domain was found in."`
+	Log           logEntryInfo    `json:"log" doc:"CT log entry the certificate was read from."`
+	Known         bool            `json:"known,omitempty" doc:"The domain was already known, e.g. from crtmon import, and was not alerted on."`
+	ObservedAt    time.Time       `json:"observed_at" doc:"When crtmon processed the entry."`
+}
+
+type matchRule struct {
+	Type    string `json:"type" enum:"contains" doc:"How the target is compared with the domain; contains is a case-insensitive substring match."`
+	Pattern string `json:"pattern" doc:"Target the domain was compared with."`
+	Scope   string `json:"scope,omitempty" doc:"Keyword from -scope the domain also had to contain."`
+}
+
+type certificateInfo struct {
+	Domains            []string  `json:"domains" doc:"All DNS names of the certificate."`
+	SerialNumber       string    `json:"serial_number" doc:"Serial number, hex encoded."`
+	Fingerprint        string    `json:"fingerprint_sha256" doc:"SHA-256 of the DER certificate or precertificate, hex encoded."`
+	Subject            string    `json:"subject" doc:"Subject distinguished name."`
+	Issuer             string    `json:"issuer" doc:"Issuer common name."`
+	IssuerDN           string    `json:"issuer_dn" doc:"Issuer distinguished name."`
+	IssuerOrg          string    `json:"issuer_org,omitempty" doc:"Issuer organization."`
+	NotBefore          time.Time `json:"not_before" doc:"Start of the validity period."`
+	NotAfter           time.Time `json:"not_after" doc:"End of the validity period."`
+	KeyAlgorithm       string    `json:"key_algorithm" doc:"Public key algorithm: RSA, ECDSA, Ed25519 or another x509 name."`
+	KeySize            int       `json:"key_size,omitempty" doc:"Public key size in bits."`
+	SignatureAlgorithm string    `json:"signature_algorithm" doc:"Signature algorithm, e.g. SHA256-RSA."`
+	IPAddresses        []string  `json:"ip_addresses,omitempty" doc:"IP address SANs."`
+	EmailAddresses     []string  `json:"email_addresses,omitempty" doc:"Email address SANs."`
+	URIs               []string  `json:"uris,omitempty" doc:"URI SANs."`
+	Validation         string    `json:"validation,omitempty" enum:"DV,OV,IV,EV" doc:"Validation level from the CA/Browser Forum policy OIDs."`
+	SCTs               []SCT     `json:"scts,omitempty" doc:"SCTs embedded in the certificate; precertificates have none."`
+}
+
+type logEntryInfo struct {
+	URL       string    `json:"url" doc:"Log URL."`
+	Index     int64     `json:"index" doc:"Index of the entry in the log."`
+	EntryType string    `json:"entry_type" enum:"cert,precert" doc:"Whether the log holds the final certificate or a precertificate."`
+	Timestamp time.Time `json:"timestamp" doc:"Timestamp the log assigned to the entry."`
+}
+
+// alertEvent is written when -verify-sth finds a misbehaving log.
+type alertEvent struct {
+	Event         string    `json:"event" const:"alert" doc:"Event type."`
+	SchemaVersion int       `json:"schema_version" doc:"Version of the crtmon event schema."`
+	Alert         string    `json:"alert" enum:"bad_signature,tree_shrunk,root_hash_changed,inconsistent_tree" doc:"Kind of misbehaviour."`
+	Detail        string    `json:"detail" doc:"Human readable description."`
+	Log           string    `json:"log" doc:"Log description."`
+	LogURL        string    `json:"log_url" doc:"Log URL."`
+	ObservedAt    time.Time `json:"observed_at" doc:"When crtmon detected the misbehaviour."`
+}
+
+// errorEvent is written when an event cannot be encoded.
+type errorEvent struct {
+	Event         string    `json:"event" const:"error" doc:"Event type."`
+	SchemaVersion int       `json:"schema_version" doc:"Version of the crtmon event schema."`
+	Error         string    `json:"error" doc:"Error message."`
+	ObservedAt    time.Time `json:"observed_at" doc:"When the error happened."`
+}
+
+func newMatchEvent(p *profile, domain, target string, entry CertEntry) matchEvent {
+	event := matchEvent{
+		Event:         eventMatch,
+		SchemaVersion: schemaVersion,
+		Domain:        domain,
+		Target:        target,
+		Rule:          matchRule{Type: "contains", Pattern: target, Scope: scopeFilter},
+		Certificate: certificateInfo{
+			Domains:            entry.Domains,
+			SerialNumber:       entry.SerialNumber,
+			Fingerprint:        entry.Fingerprint,
+			Subject:            entry.Subject,
+			Issuer:             entry.Issuer,
+			IssuerDN:           entry.IssuerDN,
+			IssuerOrg:          entry.IssuerOrg,
+			NotBefore:          entry.NotBefore.UTC(),
+			NotAfter:           entry.NotAfter.UTC(),
+			KeyAlgorithm:       entry.KeyAlgorithm,
+			KeySize:            entry.KeySize,
+			SignatureAlgorithm: entry.SignatureAlgorithm,
+			IPAddresses:        entry.IPAddresses,
+			EmailAddresses:     entry.EmailAddresses,
+			URIs:               entry.URIs,
+			Validation:         entry.Validation,
+			SCTs:               entry.SCTs,
+		},
+		Log: logEntryInfo{
+			URL:       entry.LogURL,
+			Index:     entry.LogIndex,
+			EntryType: entry.EntryType,
+			Timestamp: entry.LogTimestamp,
+		},
+		Known:      knownDomains.contains(domain),
+		ObservedAt: time.Now().UTC(),
 	}
-	jsonBytes, err := json.Marshal(data)
-	if err != nil {
-		outputJSONError(err.Error())
-		return
+	if p.name != defaultProfile {
+		event.Profile = p.name
 	}
-	fmt.Println(string(jsonBytes))
+	return event
+}
+
+func outputJSON(p *profile, domain, target string, entry CertEntry) {
+	writeJSONEvent(newMatchEvent(p, domain, target, entry))
+}
+
+func outputJSONAlert(l *ctLog, kind, detail string) {
+	writeJSONEvent(alertEvent{
+		Event:         eventAlert,
+		SchemaVersion: schemaVersion,
+		Alert:         kind,
+		Detail:        detail,
+		Log:           l.Description,
+		LogURL:        l.URL,
+		ObservedAt:    time.Now().UTC(),
+	})
 }
 
 func outputJSONError(errMsg string) {
-	data := map[string]interface{}{
-		"error":     errMsg,
-		"timestamp": time.Now().Format(time.RFC3339),
+	writeJSONEvent(errorEvent{
+		Event:         eventError,
+		SchemaVersion: schemaVersion,
+		Error:         errMsg,
+		ObservedAt:    time.Now().UTC(),
+	})
+}
+
+func writeJSONEvent(event interface{}) {
+	jsonBytes, err := json.Marshal(event)
+	if err != nil {
+		if _, isError := event.(errorEvent); !isError {
+			outputJSONError(err.Error())
+		}
+		return
 	}
-	jsonBytes, _ := json.Marshal(data)
 	fmt.Println(string(jsonBytes))
 }
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	knownPath    string
+	includeKnown bool
+	knownDomains *knownSet
+)
+
+func addKnownFlags(fs *flag.FlagSet) {
+	fs.StringVar(&knownPath, "known", "", "`file` of already known domains that are not alerted on (default: ~/.config/crtmon/known_domains.txt)")
+	fs.BoolVar(&includeKnown, "include-known", false, "alert on known domains too")
+}
+
+func knownDomainsPath(cfg *Config) (string, error) {
+	switch {
+	case knownPath != "":
+		return expandHome(knownPath)
+	case cfg != nil && cfg.KnownDomains != "":
+		return expandHome(cfg.KnownDomains)
+	}
+	dir, err := getConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "known_domains.txt"), nil
+}
+
+// applyKnownConfig loads the known domains for monitor and search. The
+// default file is only used once crtmon import has created it, so without
+// an import every match is alerted on as before.
+func applyKnownConfig(cfg *Config) error {
+	if includeKnown {
+		return nil
+	}
+	path, err := knownDomainsPath(cfg)
+	if err != nil {
+		return err
+	}
+	explicit := knownPath != "" || (cfg != nil && cfg.KnownDomains != "")
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) && !explicit {
+		return nil
+	}
+
+	set, err := openKnownSet(path)
+	if err != nil {
+		return err
+	}
+	knownDomains = set
+	logger.Info("loaded known domains; only new domains are alerted on", "count", set.len(), "path", path)
+	return nil
+}
+
+// knownSet is the set of domains already seen, kept in a text file with one
+// domain per line. Domains added while running are appended to the file.
+type knownSet struct {
+	mu      sync.Mutex
+	path    string
+	domains map[string]bool
+}
+
+func openKnownSet(path string) (*knownSet, error) {
+	set := &knownSet{path: path, domains: make(map[string]bool)}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return set, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if domain := normalizeDomain(scanner.Text()); domain != "" {
+			set.domains[domain] = true
+		}
+	}
+	return set, scanner.Err()
+}
+
+// normalizeDomain lowercases a name and drops a trailing dot. Names that
+// cannot be domains, such as email addresses, become empty.
+func normalizeDomain(name string) string {
+	name = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(name), "."))
+	if name == "" || strings.HasPrefix(name, "#") || strings.ContainsAny(name, "@ /\t") {
+		return ""
+	}
+	return name
+}
+
+func (k *knownSet) len() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return len(k.domains)
+}
+
+func (k *knownSet) contains(domain string) bool {
+	if k == nil {
+		return false
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.domains[normalizeDomain(domain)]
+}
+
+// add records domains and returns how many were new.
+func (k *knownSet) add(domains ...string) (int, error) {
+	if k == nil {
+		return 0, nil
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	var lines strings.Builder
+	added := 0
+	for _, domain := range domains {
+		domain = normalizeDomain(domain)
+		if domain == "" || k.domains[domain] {
+			continue
+		}
+		k.domains[domain] = true
+		lines.WriteString(domain + "\n")
+		added++
+	}
+	if added == 0 {
+		return 0, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(k.path), 0755); err != nil {
+		return added, err
+	}
+	file, err := os.OpenFile(k.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return added, err
+	}
+	if _, err := file.WriteString(lines.String()); err != nil {
+		file.Close()
+		return added, err
+	}
+	return added, file.Close()
+}
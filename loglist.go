@@ -0,0 +1,571 @@
+package main
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/certificate-transparency-go/loglist3"
+)
+
+const (
+	logListTimeout   = 30 * time.Second
+	logListRetry     = time.Minute
+	logListPubKeyURL = "https://www.gstatic.com/ct/log_list/v3/log_list_pubkey.pem"
+)
+
+var (
+	logListFlag    string
+	logListKeyFlag string
+	logListSource  = loglist3.LogListURL
+	logListKeyPath string
+)
+
+var logListClient = &http.Client{Timeout: logListTimeout}
+
+func addLogListFlags(fs *flag.FlagSet) {
+	fs.StringVar(&logListFlag, "log-list", "", "CT log list `file` or URL (default: Google's log list, cached on disk)")
+	fs.StringVar(&logListKeyFlag, "log-list-key", "", "`file` with the PEM public key used to verify the log list signature")
+}
+
+// applyLogListConfig merges the log list source and log selection from the
+// configuration file with the command line flags, which take precedence.
+func applyLogListConfig(cfg *Config) {
+	if cfg != nil {
+		if cfg.LogList != "" {
+			logListSource = cfg.LogList
+		}
+		logListKeyPath = cfg.LogListKey
+	}
+	if logListFlag != "" {
+		logListSource = logListFlag
+	}
+	if logListKeyFlag != "" {
+		logListKeyPath = logListKeyFlag
+	}
+	applyLogFilterConfig(cfg)
+}
+
+func getCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "crtmon"), nil
+}
+
+func isURL(source string) bool {
+	return strings.HasPrefix(source, "https://") || strings.HasPrefix(source, "http://")
+}
+
+// loadLogList returns the CT log list from the configured source. Remote lists
+// are verified and cached on disk; if the fetch fails the cached copy is used.
+func loadLogList() (*loglist3.LogList, error) {
+	if !isURL(logListSource) {
+		return readLocalLogList(logListSource)
+	}
+
+	ll, data, sig, err := fetchRemoteLogList(logListSource)
+	if err == nil {
+		if err := cacheLogList(logListSource, data, sig); err != nil {
+			logger.Warn("failed to cache CT log list", "error", err)
+		}
+		return ll, nil
+	}
+
+	cached, modTime, cacheErr := readCachedLogList(logListSource)
+	if cacheErr != nil {
+		return nil, fmt.Errorf("%w (no usable cached copy: %v)", err, cacheErr)
+	}
+
+	logger.Warn("failed to fetch CT log list, using cached copy", "error", err, "cached", modTime.Format(time.RFC3339))
+	return cached, nil
+}
+
+func fetchRemoteLogList(source string) (*loglist3.LogList, []byte, []byte, error) {
+	data, err := httpGet(source)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to fetch log list: %w", err)
+	}
+
+	key, err := logListPublicKey(source)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if key == nil {
+		logger.Warn("no signing key for CT log list; signature not verified", "source", source)
+		ll, err := loglist3.NewFromJSON(data)
+		return ll, data, nil, err
+	}
+
+	sig, err := httpGet(logListSignatureURL(source))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to fetch log list signature: %w", err)
+	}
+
+	ll, err := loglist3.NewFromSignedJSON(data, sig, key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return ll, data, sig, nil
+}
+
+func readLocalLogList(path string) (*loglist3.LogList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read log list: %w", err)
+	}
+
+	if logListKeyPath == "" {
+		return loglist3.NewFromJSON(data)
+	}
+
+	key, err := readPublicKey(logListKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := os.ReadFile(strings.TrimSuffix(path, ".json") + ".sig")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read log list signature: %w", err)
+	}
+	return loglist3.NewFromSignedJSON(data, sig, key)
+}
+
+func logListSignatureURL(source string) string {
+	switch source {
+	case loglist3.LogListURL:
+		return loglist3.LogListSignatureURL
+	case loglist3.AllLogListURL:
+		return loglist3.AllLogListSignatureURL
+	}
+	return strings.TrimSuffix(source, ".json") + ".sig"
+}
+
+// logListPublicKey returns the key that signs the given log list. Google's
+// key is fetched once and pinned in the cache directory; lists from other
+// sources are only verified when a key is configured.
+func logListPublicKey(source string) (crypto.PublicKey, error) {
+	if logListKeyPath != "" {
+		return readPublicKey(logListKeyPath)
+	}
+
+	if source != loglist3.LogListURL && source != loglist3.AllLogListURL {
+		return nil, nil
+	}
+
+	dir, err := getCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	pinned := filepath.Join(dir, "log_list_pubkey.pem")
+	if _, err := os.Stat(pinned); err == nil {
+		return readPublicKey(pinned)
+	}
+
+	data, err := httpGet(logListPubKeyURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch log list signing key: %w", err)
+	}
+	key, err := parsePublicKey(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeCacheFile(pinned, data); err != nil {
+		logger.Warn("failed to pin log list signing key", "error", err)
+	} else {
+		logger.Info("pinned CT log list signing key", "path", pinned)
+	}
+	return key, nil
+}
+
+func readPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read log list key: %w", err)
+	}
+	return parsePublicKey(data)
+}
+
+func parsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("log list key is not PEM encoded")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse log list key: %w", err)
+	}
+	return key, nil
+}
+
+func logListCachePaths(source string) (string, string, error) {
+	dir, err := getCacheDir()
+	if err != nil {
+		return "", "", err
+	}
+	name := "log_list"
+	if source != loglist3.LogListURL {
+		sum := sha256.Sum256([]byte(source))
+		name += "-" + hex.EncodeToString(sum[:4])
+	}
+	return filepath.Join(dir, name+".json"), filepath.Join(dir, name+".sig"), nil
+}
+
+func cacheLogList(source string, data, sig []byte) error {
+	jsonPath, sigPath, err := logListCachePaths(source)
+	if err != nil {
+		return err
+	}
+	if err := writeCacheFile(jsonPath, data); err != nil {
+		return err
+	}
+	if sig == nil {
+		os.Remove(sigPath)
+		return nil
+	}
+	return writeCacheFile(sigPath, sig)
+}
+
+func readCachedLogList(source string) (*loglist3.LogList, time.Time, error) {
+	jsonPath, sigPath, err := logListCachePaths(source)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	info, err := os.Stat(jsonPath)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	data, err := os.ReadFile(jsonPath)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	// a key that cannot be loaded must not turn verification off
+	key, err := logListPublicKey(source)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if key == nil {
+		ll, err := loglist3.NewFromJSON(data)
+		return ll, info.ModTime(), err
+	}
+
+	sig, err := os.ReadFile(sigPath)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("cached log list has no signature: %w", err)
+	}
+	ll, err := loglist3.NewFromSignedJSON(data, sig, key)
+	return ll, info.ModTime(), err
+}
+
+func writeCacheFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func httpGet(url string) ([]byte, error) {
+	resp, err := logListClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// maxCertLifetime bounds how far ahead a temporal shard can still receive
+// newly issued certificates.
+const maxCertLifetime = 398 * 24 * time.Hour
+
+type LogFilterConfig struct {
+	Operators        []string         `yaml:"operators,omitempty"`
+	ExcludeOperators []string         `yaml:"exclude_operators,omitempty"`
+	URLs             []string         `yaml:"urls,omitempty"`
+	ExcludeURLs      []string         `yaml:"exclude_urls,omitempty"`
+	Match            string           `yaml:"match,omitempty"`
+	ExcludeMatch     string           `yaml:"exclude_match,omitempty"`
+	States           []string         `yaml:"states,omitempty"`
+	Temporal         string           `yaml:"temporal,omitempty"`
+	Extra            []ExtraLogConfig `yaml:"extra,omitempty"`
+}
+
+type ExtraLogConfig struct {
+	URL         string `yaml:"url"`
+	Description string `yaml:"description,omitempty"`
+	Operator    string `yaml:"operator,omitempty"`
+	Key         string `yaml:"key,omitempty"`
+	Tiled       bool   `yaml:"tiled,omitempty"`
+}
+
+type ctLog struct {
+	Description string
+	Operator    string
+	URL         string
+	LogID       []byte
+	Key         []byte
+	MMD         int32
+	State       string
+	Interval    *loglist3.TemporalInterval
+	Tiled       bool
+}
+
+var (
+	logFilterFlags LogFilterConfig
+	logExtraFlag   string
+	logFilter      = LogFilterConfig{States: []string{"usable"}, Temporal: "current"}
+)
+
+func addLogFilterFlags(fs *flag.FlagSet) {
+	fs.Func("log-operator", "only monitor logs run by these operators, comma separated", appendList(&logFilterFlags.Operators))
+	fs.Func("log-exclude-operator", "skip logs run by these operators, comma separated", appendList(&logFilterFlags.ExcludeOperators))
+	fs.Func("log-url", "only monitor logs whose URL contains one of these, comma separated", appendList(&logFilterFlags.URLs))
+	fs.Func("log-exclude-url", "skip logs whose URL contains one of these, comma separated", appendList(&logFilterFlags.ExcludeURLs))
+	fs.StringVar(&logFilterFlags.Match, "log-match", "", "only monitor logs whose description matches this regex")
+	fs.StringVar(&logFilterFlags.ExcludeMatch, "log-exclude-match", "", "skip logs whose description matches this regex")
+	fs.Func("log-state", "log states to monitor: usable, qualified, readonly, retired, pending (default: usable)", appendList(&logFilterFlags.States))
+	fs.StringVar(&logFilterFlags.Temporal, "log-temporal", "", "temporal shards to monitor: current, all (default: current)")
+	fs.StringVar(&logExtraFlag, "log-extra", "", "additional log URLs not in the log list, comma separated")
+}
+
+func appendList(dst *[]string) func(string) error {
+	return func(value string) error {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*dst = append(*dst, item)
+			}
+		}
+		return nil
+	}
+}
+
+// applyLogFilterConfig merges the log selection from the configuration file
+// with the command line flags. Each flag replaces the matching config entry.
+func applyLogFilterConfig(cfg *Config) {
+	if cfg != nil {
+		merged := cfg.Logs
+		if len(merged.States) == 0 {
+			merged.States = logFilter.States
+		}
+		if merged.Temporal == "" {
+			merged.Temporal = logFilter.Temporal
+		}
+		logFilter = merged
+	}
+
+	f := logFilterFlags
+	if f.Operators != nil {
+		logFilter.Operators = f.Operators
+	}
+	if f.ExcludeOperators != nil {
+		logFilter.ExcludeOperators = f.ExcludeOperators
+	}
+	if f.URLs != nil {
+		logFilter.URLs = f.URLs
+	}
+	if f.ExcludeURLs != nil {
+		logFilter.ExcludeURLs = f.ExcludeURLs
+	}
+	if f.Match != "" {
+		logFilter.Match = f.Match
+	}
+	if f.ExcludeMatch != "" {
+		logFilter.ExcludeMatch = f.ExcludeMatch
+	}
+	if f.States != nil {
+		logFilter.States = f.States
+	}
+	if f.Temporal != "" {
+		logFilter.Temporal = f.Temporal
+	}
+	var extra []string
+	appendList(&extra)(logExtraFlag)
+	for _, url := range extra {
+		logFilter.Extra = append(logFilter.Extra, ExtraLogConfig{URL: url})
+	}
+}
+
+// selectLogs applies the configured log filter to the log list and appends
+// any extra logs from the configuration.
+func selectLogs(ll *loglist3.LogList) ([]*ctLog, error) {
+	var include, exclude *regexp.Regexp
+	var err error
+	if logFilter.Match != "" {
+		if include, err = regexp.Compile(logFilter.Match); err != nil {
+			return nil, fmt.Errorf("invalid log match regex: %w", err)
+		}
+	}
+	if logFilter.ExcludeMatch != "" {
+		if exclude, err = regexp.Compile(logFilter.ExcludeMatch); err != nil {
+			return nil, fmt.Errorf("invalid log exclude regex: %w", err)
+		}
+	}
+
+	states := make(map[string]bool)
+	for _, state := range logFilter.States {
+		state = strings.ToLower(strings.TrimSpace(state))
+		switch state {
+		case "pending", "qualified", "usable", "readonly", "retired", "rejected":
+			states[state] = true
+		default:
+			return nil, fmt.Errorf("invalid log state %q", state)
+		}
+	}
+
+	switch logFilter.Temporal {
+	case "current", "all":
+	default:
+		return nil, fmt.Errorf("invalid log temporal filter %q (valid: current, all)", logFilter.Temporal)
+	}
+
+	candidates := allLogs(ll)
+
+	now := time.Now()
+	var selected []*ctLog
+	for _, log := range candidates {
+		switch {
+		case !states[log.State]:
+		case len(logFilter.Operators) > 0 && !containsFold(logFilter.Operators, log.Operator):
+		case containsFold(logFilter.ExcludeOperators, log.Operator):
+		case len(logFilter.URLs) > 0 && !containsFold(logFilter.URLs, log.URL):
+		case containsFold(logFilter.ExcludeURLs, log.URL):
+		case include != nil && !include.MatchString(log.Description):
+		case exclude != nil && exclude.MatchString(log.Description):
+		case logFilter.Temporal == "current" && !temporallyRelevant(log.Interval, now):
+		default:
+			selected = append(selected, log)
+		}
+	}
+
+	for _, extra := range logFilter.Extra {
+		log, err := extraLog(extra)
+		if err != nil {
+			return nil, err
+		}
+		selected = append(selected, log)
+	}
+
+	return selected, nil
+}
+
+// allLogs returns every log in the log list, RFC 6962 and tiled, before any
+// filters are applied.
+func allLogs(ll *loglist3.LogList) []*ctLog {
+	var logs []*ctLog
+	for _, op := range ll.Operators {
+		for _, l := range op.Logs {
+			logs = append(logs, &ctLog{
+				Description: l.Description,
+				Operator:    op.Name,
+				URL:         l.URL,
+				LogID:       l.LogID,
+				Key:         l.Key,
+				MMD:         l.MMD,
+				State:       logStateName(l.State),
+				Interval:    l.TemporalInterval,
+			})
+		}
+		for _, l := range op.TiledLogs {
+			logs = append(logs, &ctLog{
+				Description: l.Description,
+				Operator:    op.Name,
+				URL:         l.MonitoringURL,
+				LogID:       l.LogID,
+				Key:         l.Key,
+				MMD:         l.MMD,
+				State:       logStateName(l.State),
+				Interval:    l.TemporalInterval,
+				Tiled:       true,
+			})
+		}
+	}
+	return logs
+}
+
+func extraLog(extra ExtraLogConfig) (*ctLog, error) {
+	if extra.URL == "" {
+		return nil, errors.New("extra log is missing a url")
+	}
+
+	log := &ctLog{
+		Description: extra.Description,
+		Operator:    extra.Operator,
+		URL:         extra.URL,
+		State:       "extra",
+		Tiled:       extra.Tiled,
+	}
+	if log.Description == "" {
+		log.Description = extra.URL
+	}
+	if extra.Key != "" {
+		key, err := base64.StdEncoding.DecodeString(extra.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key for extra log %s: %w", extra.URL, err)
+		}
+		id := sha256.Sum256(key)
+		log.Key = key
+		log.LogID = id[:]
+	}
+	return log, nil
+}
+
+// temporallyRelevant reports whether a shard can still receive certificates
+// issued now: its interval has not ended and starts within the longest
+// certificate lifetime from now.
+func temporallyRelevant(interval *loglist3.TemporalInterval, now time.Time) bool {
+	if interval == nil {
+		return true
+	}
+	return interval.EndExclusive.After(now) && interval.StartInclusive.Before(now.Add(maxCertLifetime))
+}
+
+func logStateName(states *loglist3.LogStates) string {
+	switch states.LogStatus() {
+	case loglist3.PendingLogStatus:
+		return "pending"
+	case loglist3.QualifiedLogStatus:
+		return "qualified"
+	case loglist3.UsableLogStatus:
+		return "usable"
+	case loglist3.ReadOnlyLogStatus:
+		return "readonly"
+	case loglist3.RetiredLogStatus:
+		return "retired"
+	case loglist3.RejectedLogStatus:
+		return "rejected"
+	default:
+		return ""
+	}
+}
+
+func containsFold(patterns []string, value string) bool {
+	value = strings.ToLower(value)
+	for _, pattern := range patterns {
+		if strings.Contains(value, strings.ToLower(pattern)) {
+			return true
+		}
+	}
+	return false
+}
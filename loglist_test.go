@@ -0,0 +1,439 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/certificate-transparency-go/loglist3"
+)
+
+func testLogList() *loglist3.LogList {
+	now := time.Now()
+	usable := &loglist3.LogStates{Usable: &loglist3.LogState{}}
+	current := &loglist3.TemporalInterval{StartInclusive: now.AddDate(-1, 0, 0), EndExclusive: now.AddDate(0, 6, 0)}
+	expired := &loglist3.TemporalInterval{StartInclusive: now.AddDate(-2, 0, 0), EndExclusive: now.AddDate(-1, 0, 0)}
+	future := &loglist3.TemporalInterval{StartInclusive: now.AddDate(3, 0, 0), EndExclusive: now.AddDate(4, 0, 0)}
+
+	return &loglist3.LogList{Operators: []*loglist3.Operator{
+		{
+			Name: "Google",
+			Logs: []*loglist3.Log{
+				{Description: "Google 'Argon2025h2' log", URL: "https://ct.googleapis.com/logs/us1/argon2025h2/", State: usable, TemporalInterval: current},
+				{Description: "Google 'Argon2023' log", URL: "https://ct.googleapis.com/logs/argon2023/", State: usable, TemporalInterval: expired},
+				{Description: "Google 'Argon2030' log", URL: "https://ct.googleapis.com/logs/argon2030/", State: usable, TemporalInterval: future},
+				{Description: "Google 'Xenon' log", URL: "https://ct.googleapis.com/logs/xenon/", State: &loglist3.LogStates{Retired: &loglist3.LogState{}}},
+			},
+		},
+		{
+			Name: "Let's Encrypt",
+			Logs: []*loglist3.Log{
+				{Description: "Let's Encrypt 'Oak2025h2'", URL: "https://oak.ct.letsencrypt.org/2025h2/", State: usable, TemporalInterval: current},
+			},
+			TiledLogs: []*loglist3.TiledLog{
+				{Description: "Let's Encrypt 'Sycamore2025h2'", MonitoringURL: "https://sycamore.ct.letsencrypt.org/2025h2", State: usable, TemporalInterval: current},
+			},
+		},
+		{
+			Name: "Sectigo",
+			Logs: []*loglist3.Log{
+				{Description: "Sectigo 'Elephant2025h2'", URL: "https://elephant2025h2.ct.sectigo.com/", State: &loglist3.LogStates{Qualified: &loglist3.LogState{}}, TemporalInterval: current},
+			},
+		},
+	}}
+}
+
+func TestSelectLogs(t *testing.T) {
+	saved := logFilter
+	defer func() { logFilter = saved }()
+
+	defaults := LogFilterConfig{States: []string{"usable"}, Temporal: "current"}
+	with := func(change func(*LogFilterConfig)) LogFilterConfig {
+		f := defaults
+		change(&f)
+		return f
+	}
+
+	tests := []struct {
+		name    string
+		filter  LogFilterConfig
+		want    []string
+		wantErr string
+	}{
+		{
+			name:   "defaults",
+			filter: defaults,
+			want:   []string{"Google 'Argon2025h2' log", "Let's Encrypt 'Oak2025h2'", "Let's Encrypt 'Sycamore2025h2'"},
+		},
+		{
+			name:   "all shards",
+			filter: with(func(f *LogFilterConfig) { f.Temporal = "all" }),
+			want:   []string{"Google 'Argon2025h2' log", "Google 'Argon2023' log", "Google 'Argon2030' log", "Let's Encrypt 'Oak2025h2'", "Let's Encrypt 'Sycamore2025h2'"},
+		},
+		{
+			name:   "states",
+			filter: with(func(f *LogFilterConfig) { f.States = []string{"Qualified", " retired"} }),
+			want:   []string{"Google 'Xenon' log", "Sectigo 'Elephant2025h2'"},
+		},
+		{
+			name:   "operator is case-insensitive",
+			filter: with(func(f *LogFilterConfig) { f.Operators = []string{"google"} }),
+			want:   []string{"Google 'Argon2025h2' log"},
+		},
+		{
+			name:   "exclude operator",
+			filter: with(func(f *LogFilterConfig) { f.ExcludeOperators = []string{"Google"} }),
+			want:   []string{"Let's Encrypt 'Oak2025h2'", "Let's Encrypt 'Sycamore2025h2'"},
+		},
+		{
+			name:   "url",
+			filter: with(func(f *LogFilterConfig) { f.URLs = []string{"sycamore", "argon"} }),
+			want:   []string{"Google 'Argon2025h2' log", "Let's Encrypt 'Sycamore2025h2'"},
+		},
+		{
+			name:   "exclude url",
+			filter: with(func(f *LogFilterConfig) { f.ExcludeURLs = []string{"letsencrypt.org"} }),
+			want:   []string{"Google 'Argon2025h2' log"},
+		},
+		{
+			name:   "match and exclude match",
+			filter: with(func(f *LogFilterConfig) { f.Match = "2025h2"; f.ExcludeMatch = "Oak" }),
+			want:   []string{"Google 'Argon2025h2' log", "Let's Encrypt 'Sycamore2025h2'"},
+		},
+		{
+			name: "extra logs are appended after filtering",
+			filter: with(func(f *LogFilterConfig) {
+				f.Operators = []string{"nobody"}
+				f.Extra = []ExtraLogConfig{{URL: "https://ct.example.com/log/"}, {URL: "https://tiles.example.com", Description: "Example tiles", Tiled: true}}
+			}),
+			want: []string{"https://ct.example.com/log/", "Example tiles"},
+		},
+		{name: "invalid state", filter: with(func(f *LogFilterConfig) { f.States = []string{"frozen"} }), wantErr: `invalid log state "frozen"`},
+		{name: "invalid temporal", filter: with(func(f *LogFilterConfig) { f.Temporal = "future" }), wantErr: "invalid log temporal filter"},
+		{name: "invalid match", filter: with(func(f *LogFilterConfig) { f.Match = "(" }), wantErr: "invalid log match regex"},
+		{name: "invalid exclude match", filter: with(func(f *LogFilterConfig) { f.ExcludeMatch = "[" }), wantErr: "invalid log exclude regex"},
+		{name: "extra log without url", filter: with(func(f *LogFilterConfig) { f.Extra = []ExtraLogConfig{{Description: "x"}} }), wantErr: "missing a url"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logFilter = tt.filter
+			logs, err := selectLogs(testLogList())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("selectLogs() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("selectLogs() error = %v", err)
+			}
+			var got []string
+			for _, l := range logs {
+				got = append(got, l.Description)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("selectLogs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyLogFilterConfig(t *testing.T) {
+	savedFilter, savedFlags, savedExtra := logFilter, logFilterFlags, logExtraFlag
+	defer func() { logFilter, logFilterFlags, logExtraFlag = savedFilter, savedFlags, savedExtra }()
+
+	tests := []struct {
+		name  string
+		cfg   *Config
+		flags []string
+		extra string
+		want  LogFilterConfig
+	}{
+		{
+			name: "no config keeps the defaults",
+			want: LogFilterConfig{States: []string{"usable"}, Temporal: "current"},
+		},
+		{
+			name: "config fills in the defaults it leaves out",
+			cfg:  &Config{Logs: LogFilterConfig{Operators: []string{"Google"}}},
+			want: LogFilterConfig{Operators: []string{"Google"}, States: []string{"usable"}, Temporal: "current"},
+		},
+		{
+			name:  "flags replace config entries",
+			cfg:   &Config{Logs: LogFilterConfig{Operators: []string{"Google"}, States: []string{"qualified"}, Temporal: "all"}},
+			flags: []string{"-log-operator", "Sectigo, DigiCert", "-log-state", "usable"},
+			extra: "https://a.example.com/, https://b.example.com/",
+			want: LogFilterConfig{
+				Operators: []string{"Sectigo", "DigiCert"},
+				States:    []string{"usable"},
+				Temporal:  "all",
+				Extra:     []ExtraLogConfig{{URL: "https://a.example.com/"}, {URL: "https://b.example.com/"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logFilter = LogFilterConfig{States: []string{"usable"}, Temporal: "current"}
+			logFilterFlags, logExtraFlag = LogFilterConfig{}, ""
+
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			addLogFilterFlags(fs)
+			if err := fs.Parse(tt.flags); err != nil {
+				t.Fatal(err)
+			}
+			if tt.extra != "" {
+				logExtraFlag = tt.extra
+			}
+			applyLogFilterConfig(tt.cfg)
+
+			got, want := logFilter, tt.want
+			if strings.Join(got.Operators, ",") != strings.Join(want.Operators, ",") ||
+				strings.Join(got.States, ",") != strings.Join(want.States, ",") ||
+				got.Temporal != want.Temporal || len(got.Extra) != len(want.Extra) {
+				t.Fatalf("logFilter = %+v, want %+v", got, want)
+			}
+			for i := range want.Extra {
+				if got.Extra[i].URL != want.Extra[i].URL {
+					t.Errorf("extra log %d = %q, want %q", i, got.Extra[i].URL, want.Extra[i].URL)
+				}
+			}
+		})
+	}
+}
+
+func TestExtraLog(t *testing.T) {
+	key := []byte("public key")
+	id := sha256.Sum256(key)
+
+	log, err := extraLog(ExtraLogConfig{URL: "https://ct.example.com/", Operator: "Example", Key: base64.StdEncoding.EncodeToString(key)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log.Description != "https://ct.example.com/" || log.State != "extra" || string(log.Key) != string(key) || string(log.LogID) != string(id[:]) {
+		t.Errorf("extraLog() = %+v", log)
+	}
+	if _, err := extraLog(ExtraLogConfig{URL: "https://ct.example.com/", Key: "not base64!"}); err == nil {
+		t.Error("extraLog() accepted an invalid key")
+	}
+}
+
+// logListServer answers the log list client's requests by URL, without
+// touching the network.
+type logListServer map[string]func() (int, []byte)
+
+func (s logListServer) RoundTrip(req *http.Request) (*http.Response, error) {
+	w := httptest.NewRecorder()
+	if reply, ok := s[req.URL.String()]; ok {
+		status, body := reply()
+		w.WriteHeader(status)
+		w.Write(body)
+	} else {
+		w.WriteHeader(http.StatusNotFound)
+	}
+	return w.Result(), nil
+}
+
+type logListSigner struct {
+	key *ecdsa.PrivateKey
+	pem []byte
+}
+
+func newLogListSigner(t *testing.T) *logListSigner {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &logListSigner{key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})}
+}
+
+func (s *logListSigner) sign(t *testing.T, data []byte) []byte {
+	t.Helper()
+	digest := sha256.Sum256(data)
+	sig, err := ecdsa.SignASN1(rand.Reader, s.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+func logListJSON(timestamp string) []byte {
+	return []byte(`{"version":"1","log_list_timestamp":"` + timestamp + `","operators":[{"name":"Example","logs":[]}]}`)
+}
+
+// useLogListState points the log list settings, the cache directory and the
+// HTTP client at test values and restores them afterwards.
+func useLogListState(t *testing.T, server logListServer) string {
+	t.Helper()
+	cache := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cache)
+	t.Setenv("HOME", cache)
+	savedSource, savedKey, savedTransport := logListSource, logListKeyPath, logListClient.Transport
+	t.Cleanup(func() { logListSource, logListKeyPath, logListClient.Transport = savedSource, savedKey, savedTransport })
+	logListSource, logListKeyPath, logListClient.Transport = loglist3.LogListURL, "", server
+
+	dir, err := getCacheDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestLoadLogListPinsGoogleKey(t *testing.T) {
+	signer, rotated := newLogListSigner(t), newLogListSigner(t)
+	list := logListJSON("2025-06-01T00:00:00Z")
+	sig := signer.sign(t, list)
+
+	var keyFetches int
+	listStatus, keyStatus := http.StatusOK, http.StatusOK
+	servedList, servedSig, servedKey := list, sig, signer.pem
+	server := logListServer{
+		loglist3.LogListURL:          func() (int, []byte) { return listStatus, servedList },
+		loglist3.LogListSignatureURL: func() (int, []byte) { return listStatus, servedSig },
+		logListPubKeyURL: func() (int, []byte) {
+			keyFetches++
+			return keyStatus, servedKey
+		},
+	}
+	dir := useLogListState(t, server)
+	pinned := filepath.Join(dir, "log_list_pubkey.pem")
+
+	steps := []struct {
+		name          string
+		setup         func()
+		wantTimestamp string
+		wantErr       string
+	}{
+		{
+			name:          "first use pins the key and caches the list",
+			wantTimestamp: "2025-06-01",
+		},
+		{
+			name: "a newer list is verified with the pinned key",
+			setup: func() {
+				servedList = logListJSON("2025-06-02T00:00:00Z")
+				servedSig = signer.sign(t, servedList)
+			},
+			wantTimestamp: "2025-06-02",
+		},
+		{
+			name: "a list signed by another key falls back to the cache",
+			setup: func() {
+				servedKey = rotated.pem
+				servedList = logListJSON("2025-06-03T00:00:00Z")
+				servedSig = rotated.sign(t, servedList)
+			},
+			wantTimestamp: "2025-06-02",
+		},
+		{
+			name:          "an unreachable server falls back to the cache",
+			setup:         func() { listStatus = http.StatusServiceUnavailable },
+			wantTimestamp: "2025-06-02",
+		},
+		{
+			name: "a tampered cache is rejected",
+			setup: func() {
+				jsonPath, _, _ := logListCachePaths(loglist3.LogListURL)
+				os.WriteFile(jsonPath, logListJSON("2030-01-01T00:00:00Z"), 0644)
+			},
+			wantErr: "no usable cached copy",
+		},
+		{
+			name: "a cache whose key cannot be loaded is rejected",
+			setup: func() {
+				cacheLogList(loglist3.LogListURL, list, sig)
+				os.Remove(pinned)
+				keyStatus = http.StatusServiceUnavailable
+			},
+			wantErr: "failed to fetch log list signing key",
+		},
+	}
+	for _, step := range steps {
+		if step.setup != nil {
+			step.setup()
+		}
+		ll, err := loadLogList()
+		if step.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), step.wantErr) {
+				t.Fatalf("%s: loadLogList() error = %v, want %q", step.name, err, step.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: loadLogList() error = %v", step.name, err)
+		}
+		if got := ll.LogListTimestamp.Format("2006-01-02"); got != step.wantTimestamp {
+			t.Errorf("%s: got the list of %s, want %s", step.name, got, step.wantTimestamp)
+		}
+	}
+	if keyFetches != 2 {
+		t.Errorf("fetched the signing key %d times, want once before and once after it was removed", keyFetches)
+	}
+}
+
+func TestReadLocalLogList(t *testing.T) {
+	useLogListState(t, logListServer{})
+	signer := newLogListSigner(t)
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	list := logListJSON("2025-06-01T00:00:00Z")
+	path := write("list.json", list)
+	write("list.sig", signer.sign(t, list))
+	key := write("key.pem", signer.pem)
+	tampered := write("tampered.json", logListJSON("2030-01-01T00:00:00Z"))
+	write("tampered.sig", signer.sign(t, list))
+
+	tests := []struct {
+		name    string
+		path    string
+		key     string
+		wantErr bool
+	}{
+		{name: "unverified", path: path},
+		{name: "verified", path: path, key: key},
+		{name: "tampered", path: tampered, key: key, wantErr: true},
+		{name: "missing key", path: path, key: filepath.Join(dir, "missing.pem"), wantErr: true},
+		{name: "missing list", path: filepath.Join(dir, "missing.json"), wantErr: true},
+	}
+	for _, tt := range tests {
+		logListKeyPath = tt.key
+		if _, err := readLocalLogList(tt.path); (err != nil) != tt.wantErr {
+			t.Errorf("%s: readLocalLogList() error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLogListSignatureURL(t *testing.T) {
+	tests := []struct{ source, want string }{
+		{loglist3.LogListURL, loglist3.LogListSignatureURL},
+		{loglist3.AllLogListURL, loglist3.AllLogListSignatureURL},
+		{"https://example.com/ct/list.json", "https://example.com/ct/list.sig"},
+	}
+	for _, tt := range tests {
+		if got := logListSignatureURL(tt.source); got != tt.want {
+			t.Errorf("logListSignatureURL(%q) = %q, want %q", tt.source, got, tt.want)
+		}
+	}
+}
@@ -11,127 +11,257 @@ import (
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
 	charmlog "github.com/charmbracelet/log"
 )
 
 var (
-	target      = flag.String("target", "", "target domain to monitor")
-	scope       = flag.String("scope", "", "scope keyword to filter subdomains")
-	configPath  = flag.String("config", "", "path to configuration file")
-	notify      = flag.String("notify", "", "notification provider: discord, telegram, both")
-	jsonOutput  = flag.Bool("json", false, "output raw JSON format to stdout")
-	showVersion = flag.Bool("version", false, "show version")
-	update      = flag.Bool("update", false, "update to latest version")
-	showHelp    = flag.Bool("h", false, "show help")
-	showHelp2   = flag.Bool("help", false, "show help")
-	logger *charmlog.Logger
-	targets        []string
-	scopeFilter    string
-	webhookURL     string
-	telegramToken  string
-	telegramChatID string
-	notifyDiscord  bool
-	notifyTelegram bool
+	targetFlag  string
+	scopeFlag   string
+	configFlag  string
+	notifyFlag  string
+	profileFlag string
+	jsonOutput  bool
+	logger      = newLogger(false)
+	scopeFilter string
 )
 
 func main() {
-	flag.CommandLine.Usage = func() {
+	cmd, args := resolveCommand(os.Args[1:])
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", os.Args[1])
 		displayHelp()
+		os.Exit(2)
 	}
-	flag.Parse()
 
-	if *jsonOutput {
-		logger = charmlog.NewWithOptions(os.Stderr, charmlog.Options{
-			ReportTimestamp: false,
-			Level:           charmlog.FatalLevel,
-		})
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	positional, err := parseArgs(fs, args)
+	if err == nil {
+		err = checkArgs(cmd, positional)
+	}
+	if err != nil {
+		if err == flag.ErrHelp {
+			displayCommandHelp(cmd)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "%s: %v\n\n", cmd.name, err)
+		displayCommandHelp(cmd)
+		os.Exit(2)
+	}
+
+	cmd.run(positional)
+}
+
+// parseArgs parses flags that appear anywhere among the arguments, so that
+// "crtmon config show -config x.yaml" works like "crtmon config -config x.yaml show".
+// A literal "--" ends flag parsing.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		if len(args) > len(rest) && args[len(args)-len(rest)-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// checkArgs rejects positional arguments for commands that take none, so
+// that "crtmon monitor example.com" fails instead of silently ignoring the
+// domain.
+func checkArgs(cmd *command, positional []string) error {
+	if cmd.args == "" && len(positional) > 0 {
+		return fmt.Errorf("unexpected argument %q", positional[0])
+	}
+	return nil
+}
+
+func newLogger(quiet bool) *charmlog.Logger {
+	if quiet {
 		log.SetOutput(io.Discard)
 		log.SetFlags(0)
-	} else {
-		logger = charmlog.NewWithOptions(os.Stderr, charmlog.Options{
-			ReportTimestamp: true,
-			TimeFormat:      "15:04:05",
-			Level:           charmlog.DebugLevel,
+		return charmlog.NewWithOptions(os.Stderr, charmlog.Options{
+			ReportTimestamp: false,
+			Level:           charmlog.FatalLevel,
 		})
 	}
+	return charmlog.NewWithOptions(os.Stderr, charmlog.Options{
+		ReportTimestamp: true,
+		TimeFormat:      "15:04:05",
+		Level:           charmlog.DebugLevel,
+	})
+}
 
-	if len(os.Args) > 1 {
-		validFlags := map[string]bool{
-			"-target": true,
-			"-scope": true,
-			"-config": true,
-			"-notify": true,
-			"-json": true,
-			"-version": true,
-			"-update": true,
-			"-h": true, "-help": true,
-		}
+func runMonitor(args []string) {
+	if replaySpeed < 0 {
+		logger.Fatal("-replay-speed must not be negative")
+	}
 
-		for i, arg := range os.Args[1:] {
-			if strings.HasPrefix(arg, "-") && arg != "-" {
-				flagName := arg
-				if idx := strings.Index(arg, "="); idx != -1 {
-					flagName = arg[:idx]
-				}
-				if !validFlags[flagName] {
-					displayHelp()
-					return
-				}
-			} else if arg == "-" && i > 0 {
-				prevArg := os.Args[i]
-				if !strings.HasPrefix(prevArg, "-") || strings.Contains(prevArg, "=") {
-					displayHelp()
-					return
-				}
+	cfg := setupProfiles(true)
+	if err := applyAPIConfig(cfg); err != nil {
+		logger.Fatal("invalid API settings", "error", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		<-sigChan
+		logger.Info("shutting down...")
+		cancel()
+	}()
+
+	defer removeStatusSnapshot()
+	defer closeOutput()
+	defer closeHistory()
+	defer closeRecorder()
+	defer flushNotifications()
+
+	source := newEntrySource()
+	defer source.Stop()
+	if metricsAddr != "" {
+		startMetricsServer(source)
+	}
+	if apiConfig.Addr != "" {
+		startAPIServer()
+	}
+	stream := source.Start()
+	go newStatusReporter().run(ctx, true)
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("goodbye")
+			return
+		case call := <-apiCalls:
+			call()
+		case entry, ok := <-stream:
+			if !ok {
+				return
 			}
+			processEntry(entry)
 		}
 	}
+}
 
-	if *showVersion {
-		displayVersion()
-		return
+func runSearch(args []string) {
+	if searchEntries <= 0 {
+		logger.Fatal("-entries must be greater than zero")
 	}
 
-	if *update {
-		performUpdate()
-		return
+	// a one-off scan should see every entry rather than keep up with the logs
+	dropPolicy = dropPolicyBlock
+	setupProfiles(false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		<-sigChan
+		logger.Info("shutting down...")
+		cancel()
+	}()
+
+	logger.Info("searching recent certificate transparency entries", "entries_per_log", searchEntries)
+
+	monitor := NewCTMonitor()
+	monitor.backfill = searchEntries
+	monitor.continuous = false
+	stream := monitor.Start()
+
+	status := newStatusReporter()
+	statusCtx, stopStatus := context.WithCancel(ctx)
+	statusDone := make(chan struct{})
+	go func() {
+		defer close(statusDone)
+		status.run(statusCtx, false)
+	}()
+	defer stopStatus()
+	defer closeOutput()
+	defer closeHistory()
+	defer closeRecorder()
+
+	for {
+		select {
+		case <-ctx.Done():
+			monitor.Stop()
+			logger.Info("goodbye")
+			return
+		case entry, ok := <-stream:
+			if !ok {
+				stopStatus()
+				<-statusDone
+				logStatusLine(status.summary(time.Now()))
+				logger.Info("search complete")
+				return
+			}
+			processEntry(entry)
+		}
 	}
+}
 
-	if *showHelp || *showHelp2 {
-		displayHelp()
-		return
+// setupProfiles loads the configuration, resolves the selected profiles and
+// their targets, and prints the startup summary. Notification providers are
+// only validated and enabled when withNotify is set. It returns the loaded
+// configuration, which is nil without a configuration file.
+func setupProfiles(withNotify bool) *Config {
+	logger = newLogger(jsonOutput)
+	if jsonOutput && silentOutput {
+		logger.Fatal("-json and -silent cannot be used together")
 	}
 
-	if !*jsonOutput {
+	if !jsonOutput && !silentOutput {
 		printBanner()
 	}
 
-	if *configPath != "" {
-		setConfigPath(*configPath)
+	if configFlag != "" {
+		setConfigPath(configFlag)
 	}
 
 	cfg, err := loadConfig()
 	if err != nil {
 		logger.Fatal("failed to load config", "error", err)
 	}
+	applyLogListConfig(cfg)
+	if err := applyBufferConfig(cfg); err != nil {
+		logger.Fatal("invalid buffer settings", "error", err)
+	}
+	if err := applyOutputConfig(cfg); err != nil {
+		logger.Fatal("invalid output settings", "error", err)
+	}
+	if err := applySourceConfig(cfg); err != nil {
+		logger.Fatal("invalid source settings", "error", err)
+	}
+	if err := applyRecordConfig(); err != nil {
+		logger.Fatal("failed to open recording", "error", err)
+	}
+	if err := applyKnownConfig(cfg); err != nil {
+		logger.Fatal("failed to load known domains", "error", err)
+	}
+	if err := applyHistoryConfig(cfg); err != nil {
+		logger.Fatal("failed to open history database", "error", err)
+	}
 
-	if cfg != nil {
-		if cfg.Webhook == `""` {
-			cfg.Webhook = ""
-		}
-
-		webhookURL = strings.TrimSpace(cfg.Webhook)
-		if webhookURL == "" {
-			logger.Warn("no discord webhook configured in configuration file; discord notifications disabled")
-		}
+	selected, err := selectProfiles(cfg, profileFlag)
+	if err != nil {
+		logger.Fatal("failed to select profile", "error", err)
+	}
 
-		telegramToken = strings.TrimSpace(cfg.TelegramBotToken)
-		telegramChatID = strings.TrimSpace(cfg.TelegramChatID)
-	} else {
-		webhookURL = ""
-		telegramToken = ""
-		telegramChatID = ""
+	if cfg == nil {
 		logger.Warn("no configuration file found. notifications will be disabled unless providers are configured")
 	}
 
@@ -140,17 +270,18 @@ func main() {
 		stdinAvailable = true
 	}
 
+	var cliTargets []string
 	switch {
-	case *target != "":
-		resolved, err := resolveTargetFlag(*target)
+	case targetFlag != "":
+		resolved, err := resolveTargetFlag(targetFlag)
 		if err != nil {
 			logger.Fatal("failed to resolve target", "error", err)
 		}
 		if len(resolved) == 0 {
 			logger.Fatal("no targets resolved from -target flag")
 		}
-		targets = resolved
-		logger.Info("using targets from cli flag", "count", len(targets))
+		cliTargets = resolved
+		logger.Info("using targets from cli flag", "count", len(cliTargets))
 	case stdinAvailable:
 		resolved, err := loadTargetsFromStdin()
 		if err != nil {
@@ -159,14 +290,15 @@ func main() {
 		if len(resolved) == 0 {
 			logger.Fatal("no targets provided on stdin")
 		}
-		targets = resolved
-		logger.Info("using targets from stdin", "count", len(targets))
+		cliTargets = resolved
+		logger.Info("using targets from stdin", "count", len(cliTargets))
 	case cfg != nil:
-		if len(cfg.Targets) == 0 {
-			logger.Fatal("no targets configured. please add target domains to ~/.config/crtmon/provider.yaml or use -target flag or stdin")
+		for _, p := range selected {
+			if len(p.targets) == 0 {
+				logger.Fatal("no targets configured. please add target domains to ~/.config/crtmon/provider.yaml or use -target flag or stdin", "profile", p.name)
+			}
 		}
-		targets = cfg.Targets
-		logger.Info("loaded configuration", "targets", len(targets))
+		logger.Info("loaded configuration", "profiles", len(selected))
 	default:
 		if err := createConfigTemplate(); err != nil {
 			logger.Fatal("failed to create config template", "error", err)
@@ -176,70 +308,61 @@ func main() {
 		logger.Fatal("please edit the configuration file or provide targets via -target or stdin and run again")
 	}
 
-	discordConfigured := webhookURL != ""
-	telegramConfigured := telegramToken != "" && telegramChatID != ""
-
-	scopeFilter = strings.TrimSpace(*scope)
-
-	notifyValue := strings.ToLower(strings.TrimSpace(*notify))
-	switch notifyValue {
-	case "":
-	case "discord":
-		if !discordConfigured {
-			logger.Fatal("notify=discord selected but discord webhook is not configured. please configure it in your configuration file (use -config for a custom path)")
+	if cliTargets != nil {
+		// the same targets in several profiles would report every match once per profile
+		if len(selected) > 1 {
+			logger.Fatal("targets from -target or stdin replace the profile targets and need a single profile; choose one with -profile", "profiles", len(selected))
 		}
-		notifyDiscord = true
-	case "telegram":
-		if !telegramConfigured {
-			logger.Fatal("notify=telegram selected but telegram bot token/chat id are not configured. please configure them in your configuration file (use -config for a custom path)")
+		for _, p := range selected {
+			p.targets = cliTargets
 		}
-		notifyTelegram = true
-	default:
-		logger.Fatal("invalid value for -notify. valid options are: discord, telegram")
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	scopeFilter = strings.TrimSpace(scopeFlag)
 
-	go func() {
-		<-sigChan
-		logger.Info("shutting down...")
-		cancel()
-	}()
+	notifyValue := strings.ToLower(strings.TrimSpace(notifyFlag))
+	for _, p := range selected {
+		if !withNotify {
+			break
+		}
+		if cfg != nil && !p.discordConfigured() {
+			logger.Warn("no discord webhook configured in configuration file; discord notifications disabled", "profile", p.name)
+		}
 
-	logger.Info("starting crtmon")
-	if !*jsonOutput {
-		for i, t := range targets {
-			fmt.Printf("         %d. %s\n", (i + 1), t)
+		switch notifyValue {
+		case "":
+		case "discord":
+			if !p.discordConfigured() {
+				logger.Fatal("notify=discord selected but discord webhook is not configured. please configure it in your configuration file (use -config for a custom path)", "profile", p.name)
+			}
+			p.notifyDiscord = true
+		case "telegram":
+			if !p.telegramConfigured() {
+				logger.Fatal("notify=telegram selected but telegram bot token/chat id are not configured. please configure them in your configuration file (use -config for a custom path)", "profile", p.name)
+			}
+			p.notifyTelegram = true
+		default:
+			logger.Fatal("invalid value for -notify. valid options are: discord, telegram")
 		}
 	}
+	profiles = selected
 
-	var notifyStatus string
-	if notifyDiscord && notifyTelegram {
-		notifyStatus = "discord, telegram"
-	} else if notifyDiscord {
-		notifyStatus = "discord"
-	} else if notifyTelegram {
-		notifyStatus = "telegram"
-	} else {
-		notifyStatus = "off"
+	logger.Info("starting crtmon")
+	if !jsonOutput && !silentOutput {
+		for _, p := range profiles {
+			if len(profiles) > 1 {
+				fmt.Printf("         %s:\n", p.name)
+			}
+			for i, t := range p.targets {
+				fmt.Printf("         %d. %s\n", (i + 1), t)
+			}
+		}
 	}
-	logger.Debug("configuration", "targets", len(targets), "notification", notifyStatus)
 
-	logger.Info("connecting to certificate transparency logs")
-
-	stream := CertStreamEventStream()
-
-	for {
-		select {
-		case <-ctx.Done():
-			logger.Info("goodbye")
-			return
-		case entry := <-stream:
-			processEntry(entry)
-		}
+	for _, p := range profiles {
+		logger.Debug("configuration", "profile", p.name, "targets", len(p.targets), "notification", p.notificationStatus())
 	}
+	return cfg
 }
 
 func resolveTargetFlag(value string) ([]string, error) {
@@ -290,22 +413,64 @@ func loadTargetsFromStdin() ([]string, error) {
 	return targets, nil
 }
 
+// domainMatches reports whether a domain contains the target and, with
+// -scope, the scope keyword.
+func domainMatches(domain, target string) bool {
+	domain = strings.ToLower(domain)
+	if !strings.Contains(domain, strings.ToLower(target)) {
+		return false
+	}
+	return scopeFilter == "" || strings.Contains(domain, strings.ToLower(scopeFilter))
+}
+
 func processEntry(entry CertEntry) {
-	for _, domain := range entry.Domains {
-		for _, target := range targets {
-			if strings.Contains(strings.ToLower(domain), strings.ToLower(target)) {
-				if scopeFilter != "" && !strings.Contains(strings.ToLower(domain), strings.ToLower(scopeFilter)) {
-					continue
-				}
-				if *jsonOutput {
-					outputJSON(domain, target, entry)
-				} else {
-					logger.Info("new subdomain", "domain", domain, "target", target)
-				}
-				if notifyDiscord || notifyTelegram {
-					go sendToDiscord(domain, target)
+	stats := findLogStats(entry.LogURL)
+	sctsChecked := false
+	var newDomains []string
+	for _, p := range profiles {
+		for _, domain := range entry.Domains {
+			known := knownDomains.contains(domain)
+			for _, target := range p.targets {
+				if domainMatches(domain, target) {
+					recordMatch(p, target, stats)
+					if !sctsChecked {
+						sctsChecked = true
+						entry.SCTs = checkSCTs(entry, domain)
+					}
+					if output != nil {
+						output.write(p, domain, target, entry)
+					}
+					if history != nil {
+						history.record(p, domain, target, entry)
+					}
+					if !known {
+						newDomains = append(newDomains, domain)
+					}
+					switch {
+					case jsonOutput:
+						// known domains are written too, flagged with "known"
+						outputJSON(p, domain, target, entry)
+					case known:
+						logger.Debug("known subdomain", "domain", domain, "target", target, "profile", p.name)
+					case silentOutput:
+						printDomain(domain)
+					case len(profiles) > 1:
+						logger.Info("new subdomain", "domain", domain, "target", target, "profile", p.name)
+					default:
+						logger.Info("new subdomain", "domain", domain, "target", target)
+					}
+					muted := p.muted[target]
+					if recent != nil {
+						recent.add(newMatchEvent(p, domain, target, entry), muted)
+					}
+					if (p.notifyDiscord || p.notifyTelegram) && !muted && !known {
+						p.notifier.add(target, match{domain: domain, entry: entry})
+					}
 				}
 			}
 		}
 	}
+	if _, err := knownDomains.add(newDomains...); err != nil {
+		logger.Error("failed to update known domains", "error", err)
+	}
 }
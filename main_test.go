@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		args           []string
+		wantPositional []string
+		wantConfig     string
+		wantJSON       bool
+		wantErr        bool
+	}{
+		{args: nil},
+		{args: []string{"show", "-config", "x.yaml"}, wantPositional: []string{"show"}, wantConfig: "x.yaml"},
+		{args: []string{"-config=x.yaml", "webhook", "https://example.com", "-json"}, wantPositional: []string{"webhook", "https://example.com"}, wantConfig: "x.yaml", wantJSON: true},
+		{args: []string{"a", "--", "-json", "b"}, wantPositional: []string{"a", "-json", "b"}},
+		{args: []string{"-unknown"}, wantErr: true},
+		{args: []string{"-config"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		var config string
+		var json bool
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		fs.StringVar(&config, "config", "", "")
+		fs.BoolVar(&json, "json", false, "")
+
+		positional, err := parseArgs(fs, tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseArgs(%q) error = %v", tt.args, err)
+			continue
+		}
+		if strings.Join(positional, " ") != strings.Join(tt.wantPositional, " ") || config != tt.wantConfig || json != tt.wantJSON {
+			t.Errorf("parseArgs(%q) = %q, config %q, json %v", tt.args, positional, config, json)
+		}
+	}
+}
+
+func TestCheckArgs(t *testing.T) {
+	tests := []struct {
+		command string
+		args    []string
+		wantErr bool
+	}{
+		{command: "monitor"},
+		{command: "monitor", args: []string{"junk"}, wantErr: true},
+		{command: "search", args: []string{"example.com"}, wantErr: true},
+		{command: "version", args: []string{"now"}, wantErr: true},
+		{command: "config", args: []string{"webhook", "https://example.com"}},
+		{command: "help", args: []string{"monitor"}},
+	}
+	for _, tt := range tests {
+		if err := checkArgs(findCommand(tt.command), tt.args); (err != nil) != tt.wantErr {
+			t.Errorf("checkArgs(%s, %q) error = %v, want error %v", tt.command, tt.args, err, tt.wantErr)
+		}
+	}
+}
@@ -2,31 +2,158 @@ package main
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 )
 
-func buildDiscordPayload(target string, domains []string) map[string]interface{} {
-	domainList := strings.Join(domains, "\n")
+func buildDiscordPayload(target string, matches []match) map[string]interface{} {
+	domainList := strings.Join(matchDomains(matches), "\n")
+
+	embed := map[string]interface{}{
+		"title":       fmt.Sprintf("%s  [%d]", target, len(matches)),
+		"description": fmt.Sprintf("```\n%s\n```", domainList),
+		"color":       2829617,
+		// "author": map[string]string{
+		// 	"name": "1hehaq/ceye",
+		// 	"url":  "https://github.com/1hehaq/ceye",
+		// },
+		"timestamp": time.Now().Format(time.RFC3339),
+	}
+
+	var fields []map[string]interface{}
+	for _, d := range certDetails(matches) {
+		fields = append(fields, map[string]interface{}{
+			"name":   d.name,
+			"value":  d.value,
+			"inline": d.inline,
+		})
+	}
+	if len(fields) > 0 {
+		embed["fields"] = fields
+	}
 
 	return map[string]interface{}{
-		"tts": false,
-		"embeds": []map[string]interface{}{
-			{
-				"title":       fmt.Sprintf("%s  [%d]", target, len(domains)),
-				"description": fmt.Sprintf("```\n%s\n```", domainList),
-				"color":       2829617,
-				// "author": map[string]string{
-				// 	"name": "1hehaq/ceye",
-				// 	"url":  "https://github.com/1hehaq/ceye",
-				// },
-				"timestamp": time.Now().Format(time.RFC3339),
-			},
-		},
+		"tts":    false,
+		"embeds": []map[string]interface{}{embed},
+	}
+}
+
+func buildTelegramMessage(target string, matches []match) string {
+	domainList := strings.Join(matchDomains(matches), "\n")
+	text := fmt.Sprintf("*%s* [%d]\n```%s```", target, len(matches), domainList)
+	for _, d := range certDetails(matches) {
+		text += fmt.Sprintf("\n*%s:* `%s`", d.name, d.value)
 	}
+	return text
 }
 
-func buildTelegramMessage(target string, domains []string) string {
-	domainList := strings.Join(domains, "\n")
-	return fmt.Sprintf("*%s* [%d]\n```%s```", target, len(domains), domainList)
+func matchDomains(matches []match) []string {
+	domains := make([]string, len(matches))
+	for i, m := range matches {
+		domains[i] = m.domain
+	}
+	return domains
+}
+
+type certDetail struct {
+	name   string
+	value  string
+	inline bool
+}
+
+// maxDetailValues caps the values listed in one detail so a batch of many
+// certificates, or one with hundreds of SANs, stays within the message size
+// limits of the providers.
+const maxDetailValues = 10
+
+// certDetails summarises the certificates behind a batch of matches. The
+// issuer, validation, entry type, validity and log are listed once per
+// distinct value; the SANs, SCTs, serial number and fingerprint are only
+// shown when the batch comes from a single certificate.
+func certDetails(matches []match) []certDetail {
+	if len(matches) == 0 {
+		return nil
+	}
+	var details []certDetail
+	summary := func(name string, value func(CertEntry) string) {
+		seen := make(map[string]bool)
+		var values []string
+		for _, m := range matches {
+			if v := value(m.entry); v != "" && !seen[v] {
+				seen[v] = true
+				values = append(values, v)
+			}
+		}
+		if len(values) > 0 {
+			details = append(details, certDetail{name: name, value: joinLimited(values), inline: true})
+		}
+	}
+	summary("Issuer", func(e CertEntry) string {
+		if e.IssuerOrg != "" {
+			return e.IssuerOrg
+		}
+		return e.Issuer
+	})
+	summary("Validation", func(e CertEntry) string { return e.Validation })
+	summary("Type", func(e CertEntry) string { return e.EntryType })
+	summary("Validity", func(e CertEntry) string {
+		if e.NotBefore.IsZero() || e.NotAfter.IsZero() {
+			return ""
+		}
+		return e.NotBefore.Format(time.DateOnly) + " to " + e.NotAfter.Format(time.DateOnly)
+	})
+	summary("Log", func(e CertEntry) string { return logName(e.LogURL) })
+
+	fingerprints := make(map[string]bool)
+	for _, m := range matches {
+		fingerprints[m.entry.Fingerprint] = true
+	}
+	entry := matches[0].entry
+	if len(fingerprints) != 1 || entry.Fingerprint == "" {
+		return details
+	}
+
+	sans := append(append(append(slices.Clone(entry.Domains), entry.IPAddresses...), entry.EmailAddresses...), entry.URIs...)
+	if len(sans) > 0 {
+		details = append(details, certDetail{name: fmt.Sprintf("SANs [%d]", len(sans)), value: joinLimited(sans)})
+	}
+	if len(entry.SCTs) > 0 {
+		var scts []string
+		for _, sct := range entry.SCTs {
+			name := sct.Log
+			if name == "" {
+				name = sct.LogID
+			}
+			if sct.Status != "" {
+				name += " (" + sct.Status + ")"
+			}
+			scts = append(scts, name)
+		}
+		details = append(details, certDetail{name: fmt.Sprintf("SCTs [%d]", len(scts)), value: joinLimited(scts)})
+	}
+	if !entry.LogTimestamp.IsZero() {
+		details = append(details, certDetail{name: "Log entry", value: fmt.Sprintf("%d, logged %s", entry.LogIndex, entry.LogTimestamp.Format(time.RFC3339))})
+	}
+	details = append(details,
+		certDetail{name: "Serial", value: entry.SerialNumber},
+		certDetail{name: "SHA-256", value: entry.Fingerprint},
+	)
+	return details
+}
+
+// joinLimited joins up to maxDetailValues values and counts the rest.
+func joinLimited(values []string) string {
+	if len(values) <= maxDetailValues {
+		return strings.Join(values, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(values[:maxDetailValues], ", "), len(values)-maxDetailValues)
+}
+
+// logName returns the description of a monitored log, or its URL.
+func logName(url string) string {
+	if stats := findLogStats(url); stats != nil && stats.description != "" {
+		return stats.description
+	}
+	return url
 }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCertDetails(t *testing.T) {
+	cert := CertEntry{
+		Domains:      []string{"api.example.com", "www.example.com"},
+		Issuer:       "R11",
+		IssuerOrg:    "Let's Encrypt",
+		SerialNumber: "0a1b",
+		Fingerprint:  "ff00",
+		NotBefore:    time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2025, 8, 30, 0, 0, 0, 0, time.UTC),
+		LogURL:       "https://ct.example.com/",
+		LogIndex:     42,
+		LogTimestamp: time.Date(2025, 6, 1, 0, 1, 0, 0, time.UTC),
+	}
+	other := cert
+	other.Fingerprint, other.Issuer, other.IssuerOrg = "ee00", "WR1", "Google Trust Services"
+
+	tests := []struct {
+		name    string
+		matches []match
+		want    string
+	}{
+		{name: "empty batch"},
+		{
+			name:    "single certificate",
+			matches: []match{{"api.example.com", cert}, {"www.example.com", cert}},
+			want: "Issuer=Let's Encrypt;Validity=2025-06-01 to 2025-08-30;Log=https://ct.example.com/;" +
+				"SANs [2]=api.example.com, www.example.com;Log entry=42, logged 2025-06-01T00:01:00Z;Serial=0a1b;SHA-256=ff00",
+		},
+		{
+			name:    "several certificates are only summarised",
+			matches: []match{{"api.example.com", cert}, {"api.example.com", other}},
+			want:    "Issuer=Let's Encrypt, Google Trust Services;Validity=2025-06-01 to 2025-08-30;Log=https://ct.example.com/",
+		},
+		{
+			name:    "no fingerprint",
+			matches: []match{{"a.example.com", CertEntry{Issuer: "R11"}}},
+			want:    "Issuer=R11",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, d := range certDetails(tt.matches) {
+				got = append(got, d.name+"="+d.value)
+			}
+			if strings.Join(got, ";") != tt.want {
+				t.Errorf("certDetails() = %q, want %q", strings.Join(got, ";"), tt.want)
+			}
+			// the builders must cope with whatever certDetails returns
+			buildDiscordPayload("example", tt.matches)
+			buildTelegramMessage("example", tt.matches)
+		})
+	}
+}
+
+func TestJoinLimited(t *testing.T) {
+	var many []string
+	for i := 0; i < maxDetailValues+3; i++ {
+		many = append(many, "x")
+	}
+	tests := []struct {
+		values []string
+		want   string
+	}{
+		{nil, ""},
+		{[]string{"a", "b"}, "a, b"},
+		{many, strings.Repeat("x, ", maxDetailValues-1) + "x and 3 more"},
+	}
+	for _, tt := range tests {
+		if got := joinLimited(tt.values); got != tt.want {
+			t.Errorf("joinLimited(%d values) = %q, want %q", len(tt.values), got, tt.want)
+		}
+	}
+}
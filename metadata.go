@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	ct "github.com/google/certificate-transparency-go"
+	"github.com/google/certificate-transparency-go/x509"
+)
+
+const (
+	entryTypeCert    = "cert"
+	entryTypePrecert = "precert"
+)
+
+// CA/Browser Forum policy OIDs identifying the validation level of a
+// certificate.
+var validationPolicies = map[string]string{
+	"2.23.140.1.1":   "EV",
+	"2.23.140.1.2.1": "DV",
+	"2.23.140.1.2.2": "OV",
+	"2.23.140.1.2.3": "IV",
+}
+
+// setMetadata fills in the certificate details of an entry from the parsed
+// certificate and the log entry it came from.
+func (e *CertEntry) setMetadata(cert *x509.Certificate, raw rawEntry) {
+	if cert.SerialNumber != nil {
+		e.SerialNumber = hex.EncodeToString(cert.SerialNumber.Bytes())
+	}
+	if len(raw.der) > 0 {
+		sum := sha256.Sum256(raw.der)
+		e.Fingerprint = hex.EncodeToString(sum[:])
+	}
+	e.Subject = cert.Subject.String()
+	e.IssuerDN = cert.Issuer.String()
+	if len(cert.Issuer.Organization) > 0 {
+		e.IssuerOrg = cert.Issuer.Organization[0]
+	}
+	e.KeyAlgorithm, e.KeySize = publicKeyInfo(cert)
+	e.SignatureAlgorithm = cert.SignatureAlgorithm.String()
+
+	for _, ip := range cert.IPAddresses {
+		e.IPAddresses = append(e.IPAddresses, ip.String())
+	}
+	e.EmailAddresses = cert.EmailAddresses
+	for _, uri := range cert.URIs {
+		e.URIs = append(e.URIs, uri.String())
+	}
+
+	e.EntryType = entryTypeCert
+	if raw.entryType == ct.PrecertLogEntryType {
+		e.EntryType = entryTypePrecert
+	}
+	e.LogIndex = raw.index
+	e.LogTimestamp = time.UnixMilli(int64(raw.timestamp)).UTC()
+	e.Validation = validationType(cert)
+}
+
+func publicKeyInfo(cert *x509.Certificate) (string, int) {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return "RSA", key.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ECDSA", key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return "Ed25519", 256
+	}
+	return cert.PublicKeyAlgorithm.String(), 0
+}
+
+// validationType returns DV, OV, IV or EV from the certificate policies, or
+// an empty string when none of the CA/Browser Forum policies is asserted.
+func validationType(cert *x509.Certificate) string {
+	for _, oid := range cert.PolicyIdentifiers {
+		if v, ok := validationPolicies[oid.String()]; ok {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+var metricsAddr string
+
+func addMetricsFlags(fs *flag.FlagSet) {
+	fs.StringVar(&metricsAddr, "metrics-addr", "", "address to serve Prometheus metrics and /healthz, /readyz on, e.g. :9090 (disabled by default)")
+	addHealthFlags(fs)
+}
+
+// startMetricsServer serves the monitor's metrics and health checks on
+// metricsAddr in the background. A failure to listen is fatal so a typo does
+// not go unnoticed.
+func startMetricsServer(src entrySource) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(renderMetrics(src))
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeProbe(w, src.healthy())
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeProbe(w, src.ready())
+	})
+
+	server := &http.Server{Addr: metricsAddr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatal("metrics server failed", "addr", metricsAddr, "error", err)
+		}
+	}()
+	logger.Info("serving metrics", "addr", metricsAddr)
+}
+
+type metricsWriter struct {
+	bytes.Buffer
+}
+
+func (w *metricsWriter) header(name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes one sample; labels are given as alternating names and values.
+func (w *metricsWriter) sample(name string, value float64, labels ...string) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", labels[i], escapeLabel(labels[i+1]))
+		}
+		w.WriteByte('}')
+	}
+	fmt.Fprintf(w, " %g\n", value)
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func renderMetrics(src entrySource) []byte {
+	var w metricsWriter
+	logs := allLogStats()
+
+	perLog := []struct {
+		name, kind, help string
+		value            func(*logStats) float64
+	}{
+		{"crtmon_log_entries_total", "counter", "Entries processed from the log.", func(s *logStats) float64 { return float64(s.entries.Load()) }},
+		{"crtmon_log_tree_size", "gauge", "Last known tree size of the log.", func(s *logStats) float64 { return float64(s.size()) }},
+		{"crtmon_log_position", "gauge", "Index of the next entry to process.", func(s *logStats) float64 { return float64(max(0, s.next.Load())) }},
+		{"crtmon_log_lag", "gauge", "Entries in the log not processed yet.", func(s *logStats) float64 { return float64(s.lag()) }},
+		{"crtmon_log_parse_failures_total", "counter", "Entries whose certificate could not be parsed.", func(s *logStats) float64 { return float64(s.parseFailures.Load()) }},
+		{"crtmon_log_matches_total", "counter", "Target matches found in the log.", func(s *logStats) float64 { return float64(s.matches.Load()) }},
+		{"crtmon_log_dropped_entries_total", "counter", "Entries dropped because the buffer was full.", func(s *logStats) float64 { return float64(s.dropped.Load()) }},
+		{"crtmon_log_restarts_total", "counter", "Times the log fetcher was restarted after failing.", func(s *logStats) float64 { return float64(s.restarts.Load()) }},
+		{"crtmon_log_alerts_total", "counter", "Misbehaviour found by -verify-sth: bad signatures or inconsistent tree heads.", func(s *logStats) float64 { return float64(s.alerts.Load()) }},
+		{"crtmon_log_degraded", "gauge", "Whether the log failed repeatedly without progress.", func(s *logStats) float64 {
+			if s.degraded.Load() {
+				return 1
+			}
+			return 0
+		}},
+	}
+	for _, metric := range perLog {
+		w.header(metric.name, metric.kind, metric.help)
+		for _, s := range logs {
+			w.sample(metric.name, metric.value(s), "log", s.description, "url", s.url)
+		}
+	}
+
+	w.header("crtmon_matches_total", "counter", "Matches per profile and target.")
+	matchCounts.mu.Lock()
+	keys := make([]matchKey, 0, len(matchCounts.counts))
+	for k := range matchCounts.counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].profile != keys[j].profile {
+			return keys[i].profile < keys[j].profile
+		}
+		return keys[i].target < keys[j].target
+	})
+	for _, k := range keys {
+		w.sample("crtmon_matches_total", float64(matchCounts.counts[k]), "profile", k.profile, "target", k.target)
+	}
+	matchCounts.mu.Unlock()
+
+	providers := []struct {
+		name  string
+		stats *notificationStats
+	}{{"discord", &discordStats}, {"telegram", &telegramStats}}
+	w.header("crtmon_notifications_sent_total", "counter", "Notifications delivered per provider.")
+	for _, p := range providers {
+		w.sample("crtmon_notifications_sent_total", float64(p.stats.sent.Load()), "provider", p.name)
+	}
+	w.header("crtmon_notification_failures_total", "counter", "Notifications that could not be delivered per provider.")
+	for _, p := range providers {
+		w.sample("crtmon_notification_failures_total", float64(p.stats.failures.Load()), "provider", p.name)
+	}
+	w.header("crtmon_notification_retries_total", "counter", "Notification attempts retried after rate limiting per provider.")
+	for _, p := range providers {
+		w.sample("crtmon_notification_retries_total", float64(p.stats.retries.Load()), "provider", p.name)
+	}
+
+	depth, capacity := src.queue()
+	w.header("crtmon_queue_depth", "gauge", "Parsed entries waiting to be matched.")
+	w.sample("crtmon_queue_depth", float64(depth))
+	w.header("crtmon_queue_capacity", "gauge", "Size of the entry buffer.")
+	w.sample("crtmon_queue_capacity", float64(capacity))
+
+	return w.Bytes()
+}
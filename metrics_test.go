@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// useLogStats gives the test empty log and match counters, restoring the
+// global ones afterwards.
+func useLogStats(t *testing.T) {
+	t.Helper()
+	oldLogs, oldByURL, oldCounts := logStatsRegistry.logs, logStatsRegistry.byURL, matchCounts.counts
+	logStatsRegistry.logs, logStatsRegistry.byURL = nil, make(map[string]*logStats)
+	matchCounts.counts = make(map[matchKey]int64)
+	t.Cleanup(func() {
+		logStatsRegistry.logs, logStatsRegistry.byURL, matchCounts.counts = oldLogs, oldByURL, oldCounts
+	})
+}
+
+func TestRenderMetrics(t *testing.T) {
+	useLogStats(t)
+
+	argon := registerLogStats(&ctLog{Description: `Argon "2026h2"`}, "https://ct.example/argon/")
+	argon.treeSize.Store(100)
+	argon.next.Store(90)
+	argon.entries.Add(90)
+	argon.parseFailures.Add(2)
+	argon.dropped.Add(1)
+	xenon := registerLogStats(&ctLog{Description: "Xenon 2026h2"}, "https://ct.example/xenon/")
+	xenon.treeSize.Store(50)
+	xenon.restarts.Add(3)
+	xenon.degraded.Store(true)
+
+	recordMatch(&profile{name: "default"}, "example.com", argon)
+	recordMatch(&profile{name: "default"}, "example.com", argon)
+	recordMatch(&profile{name: "acme"}, "acme.test", nil)
+
+	discordStats.sent.Add(4)
+	telegramStats.failures.Add(1)
+	t.Cleanup(func() {
+		discordStats.sent.Add(-4)
+		telegramStats.failures.Add(-1)
+	})
+
+	m := &CTMonitor{entryChan: make(chan CertEntry, 8), ctx: context.Background()}
+	m.entryChan <- CertEntry{}
+
+	got := string(renderMetrics(m))
+	for _, line := range []string{
+		"# HELP crtmon_log_entries_total Entries processed from the log.",
+		"# TYPE crtmon_log_entries_total counter",
+		`crtmon_log_entries_total{log="Argon \"2026h2\"",url="https://ct.example/argon/"} 90`,
+		"# TYPE crtmon_log_lag gauge",
+		`crtmon_log_lag{log="Argon \"2026h2\"",url="https://ct.example/argon/"} 10`,
+		`crtmon_log_position{log="Xenon 2026h2",url="https://ct.example/xenon/"} 0`,
+		`crtmon_log_lag{log="Xenon 2026h2",url="https://ct.example/xenon/"} 0`,
+		`crtmon_log_parse_failures_total{log="Argon \"2026h2\"",url="https://ct.example/argon/"} 2`,
+		`crtmon_log_matches_total{log="Argon \"2026h2\"",url="https://ct.example/argon/"} 2`,
+		`crtmon_log_dropped_entries_total{log="Argon \"2026h2\"",url="https://ct.example/argon/"} 1`,
+		`crtmon_log_restarts_total{log="Xenon 2026h2",url="https://ct.example/xenon/"} 3`,
+		`crtmon_log_degraded{log="Xenon 2026h2",url="https://ct.example/xenon/"} 1`,
+		`crtmon_log_degraded{log="Argon \"2026h2\"",url="https://ct.example/argon/"} 0`,
+		`crtmon_matches_total{profile="acme",target="acme.test"} 1`,
+		`crtmon_matches_total{profile="default",target="example.com"} 2`,
+		`crtmon_notifications_sent_total{provider="discord"} 4`,
+		`crtmon_notification_failures_total{provider="telegram"} 1`,
+		"crtmon_queue_depth 1",
+		"crtmon_queue_capacity 8",
+	} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("metrics are missing %q", line)
+		}
+	}
+
+	// every sample follows the HELP and TYPE lines of its metric
+	var current string
+	for _, line := range strings.Split(strings.TrimSuffix(got, "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "# HELP "):
+			current = strings.Fields(line)[2]
+		case strings.HasPrefix(line, "# TYPE "):
+			if fields := strings.Fields(line); fields[2] != current {
+				t.Errorf("TYPE line %q does not follow the HELP line of %s", line, current)
+			}
+		default:
+			if name, _, _ := strings.Cut(line, "{"); strings.Fields(name)[0] != current {
+				t.Errorf("sample %q listed under %s", line, current)
+			}
+		}
+	}
+	if strings.Index(got, `acme.test`) > strings.Index(got, `example.com`) {
+		t.Error("match counters are not sorted by profile")
+	}
+}
+
+func TestEscapeLabel(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{in: "plain", want: "plain"},
+		{in: `say "hi"`, want: `say \"hi\"`},
+		{in: `C:\logs`, want: `C:\\logs`},
+		{in: "two\nlines", want: `two\nlines`},
+	}
+
+	for _, tt := range tests {
+		if got := escapeLabel(tt.in); got != tt.want {
+			t.Errorf("escapeLabel(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
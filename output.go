@@ -0,0 +1,346 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	outputJSONL = "jsonl"
+	outputCSV   = "csv"
+	outputTXT   = "txt"
+
+	rotatedTimeFormat = "20060102T150405"
+)
+
+var csvHeader = []string{
+	"observed_at", "profile", "target", "domain", "issuer", "issuer_org", "validation",
+	"not_before", "not_after", "serial_number", "fingerprint_sha256",
+	"entry_type", "log_url", "log_index", "log_timestamp",
+}
+
+// OutputConfig is the output section of the configuration file.
+type OutputConfig struct {
+	Path     string `yaml:"path,omitempty"`
+	Format   string `yaml:"format,omitempty"`
+	MaxSize  int    `yaml:"max_size,omitempty"`
+	Rotate   string `yaml:"rotate,omitempty"`
+	Compress bool   `yaml:"compress,omitempty"`
+}
+
+var (
+	outputFlags    OutputConfig
+	outputRotation time.Duration
+	output         *outputSink
+)
+
+func addOutputFlags(fs *flag.FlagSet) {
+	fs.StringVar(&outputFlags.Path, "output", "", "write matches to this `file`; {target} and {profile} in the path give one file per target or profile")
+	fs.StringVar(&outputFlags.Format, "output-format", "", "output file format: jsonl, csv, txt (default: from the file extension, else jsonl)")
+	fs.IntVar(&outputFlags.MaxSize, "output-max-size", 0, "rotate the output file when it reaches this size in MB (default: no limit)")
+	fs.DurationVar(&outputRotation, "output-rotate", 0, "rotate the output file at this interval, e.g. 24h (default: never)")
+	fs.BoolVar(&outputFlags.Compress, "output-compress", false, "gzip rotated output files")
+}
+
+// applyOutputConfig merges the output settings from the configuration file
+// with the command line flags, which take precedence.
+func applyOutputConfig(cfg *Config) error {
+	var oc OutputConfig
+	if cfg != nil {
+		oc = cfg.Output
+	}
+	if outputFlags.Path != "" {
+		oc.Path = outputFlags.Path
+	}
+	if outputFlags.Format != "" {
+		oc.Format = outputFlags.Format
+	}
+	if outputFlags.MaxSize != 0 {
+		oc.MaxSize = outputFlags.MaxSize
+	}
+	if outputFlags.Compress {
+		oc.Compress = true
+	}
+
+	rotate := outputRotation
+	if rotate == 0 && oc.Rotate != "" {
+		d, err := time.ParseDuration(oc.Rotate)
+		if err != nil {
+			return fmt.Errorf("invalid output rotate interval %q: %v", oc.Rotate, err)
+		}
+		rotate = d
+	}
+
+	if oc.Path == "" {
+		return nil
+	}
+	path, err := expandHome(oc.Path)
+	if err != nil {
+		return err
+	}
+	oc.Path = path
+	format := strings.ToLower(strings.TrimSpace(oc.Format))
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(oc.Path), ".")
+		if format != outputCSV && format != outputTXT {
+			format = outputJSONL
+		}
+	}
+	if format != outputJSONL && format != outputCSV && format != outputTXT {
+		return fmt.Errorf("invalid output format %q. valid options are: jsonl, csv, txt", oc.Format)
+	}
+	if oc.MaxSize < 0 || rotate < 0 {
+		return fmt.Errorf("output max size and rotate interval must not be negative")
+	}
+
+	output = &outputSink{
+		path:     oc.Path,
+		format:   format,
+		maxSize:  int64(oc.MaxSize) << 20,
+		rotate:   rotate,
+		compress: oc.Compress,
+		files:    make(map[string]*rotatingFile),
+	}
+	return nil
+}
+
+// outputSink writes matches to one or more rotating files. Files are opened
+// on the first match written to them, so a path with {target} only creates
+// files for targets that matched.
+type outputSink struct {
+	path     string
+	format   string
+	maxSize  int64
+	rotate   time.Duration
+	compress bool
+
+	mu    sync.Mutex
+	files map[string]*rotatingFile
+	gzips sync.WaitGroup
+}
+
+func (o *outputSink) write(p *profile, domain, target string, entry CertEntry) {
+	event := newMatchEvent(p, domain, target, entry)
+
+	var line []byte
+	var err error
+	switch o.format {
+	case outputJSONL:
+		line, err = json.Marshal(event)
+		line = append(line, '\n')
+	case outputCSV:
+		line, err = csvLine(csvRecord(p, event))
+	case outputTXT:
+		line = []byte(domain + "\n")
+	}
+	if err != nil {
+		logger.Error("failed to encode output", "error", err)
+		return
+	}
+
+	path := strings.NewReplacer("{target}", safeFileName(target), "{profile}", safeFileName(p.name)).Replace(o.path)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	f := o.files[path]
+	if f == nil {
+		f = &rotatingFile{sink: o, path: path}
+		o.files[path] = f
+	}
+	if err := f.write(line); err != nil {
+		logger.Error("failed to write output", "path", path, "error", err)
+	}
+}
+
+// close closes every file and waits for rotated files to be compressed.
+func (o *outputSink) close() {
+	o.mu.Lock()
+	for _, f := range o.files {
+		f.close()
+	}
+	o.mu.Unlock()
+	o.gzips.Wait()
+}
+
+func closeOutput() {
+	if output != nil {
+		output.close()
+	}
+}
+
+func csvRecord(p *profile, e matchEvent) []string {
+	return []string{
+		e.ObservedAt.Format(time.RFC3339),
+		p.name,
+		e.Target,
+		e.Domain,
+		e.Certificate.Issuer,
+		e.Certificate.IssuerOrg,
+		e.Certificate.Validation,
+		e.Certificate.NotBefore.Format(time.RFC3339),
+		e.Certificate.NotAfter.Format(time.RFC3339),
+		e.Certificate.SerialNumber,
+		e.Certificate.Fingerprint,
+		e.Log.EntryType,
+		e.Log.URL,
+		strconv.FormatInt(e.Log.Index, 10),
+		e.Log.Timestamp.Format(time.RFC3339),
+	}
+}
+
+func csvLine(record []string) ([]byte, error) {
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	w.Write(record)
+	w.Flush()
+	return []byte(b.String()), w.Error()
+}
+
+// safeFileName makes a target usable as part of a file name.
+func safeFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || r == '*' || r == os.PathSeparator {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// rotatingFile appends to a file and moves it aside once it is larger than
+// the sink's max size or older than its rotate interval.
+type rotatingFile struct {
+	sink   *outputSink
+	path   string
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+func (f *rotatingFile) write(line []byte) error {
+	if f.file != nil && f.due(int64(len(line))) {
+		if err := f.rotateFile(); err != nil {
+			return err
+		}
+	}
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return err
+		}
+	}
+	n, err := f.file.Write(line)
+	f.size += int64(n)
+	return err
+}
+
+func (f *rotatingFile) due(next int64) bool {
+	if f.sink.maxSize > 0 && f.size > 0 && f.size+next > f.sink.maxSize {
+		return true
+	}
+	return f.sink.rotate > 0 && time.Since(f.opened) >= f.sink.rotate
+}
+
+func (f *rotatingFile) open() error {
+	if dir := filepath.Dir(f.path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	f.opened = time.Now()
+	if f.size > 0 {
+		// an existing file is rotated as if it was opened when last written
+		f.opened = info.ModTime()
+	}
+	if f.size == 0 && f.sink.format == outputCSV {
+		header, _ := csvLine(csvHeader)
+		n, err := f.file.Write(header)
+		f.size += int64(n)
+		return err
+	}
+	return nil
+}
+
+func (f *rotatingFile) rotateFile() error {
+	f.close()
+
+	ext := filepath.Ext(f.path)
+	base := strings.TrimSuffix(f.path, ext) + "-" + time.Now().Format(rotatedTimeFormat)
+	rotated := base + ext
+	for i := 1; fileExists(rotated) || fileExists(rotated+".gz"); i++ {
+		rotated = fmt.Sprintf("%s.%d%s", base, i, ext)
+	}
+	if err := os.Rename(f.path, rotated); err != nil {
+		return err
+	}
+
+	if f.sink.compress {
+		f.sink.gzips.Add(1)
+		go func() {
+			defer f.sink.gzips.Done()
+			if err := gzipFile(rotated); err != nil {
+				logger.Error("failed to compress rotated output", "path", rotated, "error", err)
+			}
+		}()
+	}
+	return nil
+}
+
+func (f *rotatingFile) close() {
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// gzipFile compresses path to path.gz and removes the original.
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyOutputConfig(t *testing.T) {
+	savedFlags, savedRotation, savedOutput := outputFlags, outputRotation, output
+	defer func() { outputFlags, outputRotation, output = savedFlags, savedRotation, savedOutput }()
+
+	tests := []struct {
+		name         string
+		cfg          *Config
+		flags        OutputConfig
+		rotation     time.Duration
+		wantFormat   string
+		wantMaxSize  int64
+		wantRotate   time.Duration
+		wantCompress bool
+		wantNone     bool
+		wantErr      string
+	}{
+		{name: "no output", wantNone: true},
+		{name: "format from extension", flags: OutputConfig{Path: "matches.csv"}, wantFormat: outputCSV},
+		{name: "txt extension", flags: OutputConfig{Path: "matches.txt"}, wantFormat: outputTXT},
+		{name: "unknown extension is jsonl", flags: OutputConfig{Path: "matches.log"}, wantFormat: outputJSONL},
+		{name: "explicit format wins", flags: OutputConfig{Path: "matches.csv", Format: " JSONL "}, wantFormat: outputJSONL},
+		{
+			name:        "config settings",
+			cfg:         &Config{Output: OutputConfig{Path: "out.jsonl", MaxSize: 5, Rotate: "24h", Compress: true}},
+			wantFormat:  outputJSONL,
+			wantMaxSize: 5 << 20, wantRotate: 24 * time.Hour, wantCompress: true,
+		},
+		{
+			name:        "flags override config",
+			cfg:         &Config{Output: OutputConfig{Path: "out.jsonl", MaxSize: 5, Rotate: "24h"}},
+			flags:       OutputConfig{Path: "flag.csv", MaxSize: 1},
+			rotation:    time.Hour,
+			wantFormat:  outputCSV,
+			wantMaxSize: 1 << 20, wantRotate: time.Hour,
+		},
+		{name: "invalid format", flags: OutputConfig{Path: "out", Format: "xml"}, wantErr: "invalid output format"},
+		{name: "invalid rotate", cfg: &Config{Output: OutputConfig{Path: "out", Rotate: "daily"}}, wantErr: "invalid output rotate interval"},
+		{name: "negative size", flags: OutputConfig{Path: "out", MaxSize: -1}, wantErr: "must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outputFlags, outputRotation, output = tt.flags, tt.rotation, nil
+
+			err := applyOutputConfig(tt.cfg)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("applyOutputConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyOutputConfig() error = %v", err)
+			}
+			if tt.wantNone {
+				if output != nil {
+					t.Fatalf("output = %+v, want none", output)
+				}
+				return
+			}
+			if output.format != tt.wantFormat || output.maxSize != tt.wantMaxSize ||
+				output.rotate != tt.wantRotate || output.compress != tt.wantCompress {
+				t.Errorf("output = format %s, max size %d, rotate %s, compress %v", output.format, output.maxSize, output.rotate, output.compress)
+			}
+		})
+	}
+}
+
+func TestSafeFileName(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"example.com", "example.com"},
+		{"a/b", "a_b"},
+		{`a\b:c*d`, "a_b_c_d"},
+	}
+	for _, tt := range tests {
+		if got := safeFileName(tt.in); got != tt.want {
+			t.Errorf("safeFileName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func newTestSink(path, format string) *outputSink {
+	return &outputSink{path: path, format: format, files: make(map[string]*rotatingFile)}
+}
+
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func TestOutputFormats(t *testing.T) {
+	red := &profile{name: "red"}
+
+	tests := []struct {
+		format string
+		check  func(t *testing.T, lines []string)
+	}{
+		{outputJSONL, func(t *testing.T, lines []string) {
+			if len(lines) != 2 {
+				t.Fatalf("got %d lines, want 2", len(lines))
+			}
+			var event matchEvent
+			if err := json.Unmarshal([]byte(lines[1]), &event); err != nil {
+				t.Fatal(err)
+			}
+			if event.Domain != "www.example.com" || event.Target != "example" || event.Profile != "red" || event.Log.Index != 42 {
+				t.Errorf("event = %+v", event)
+			}
+		}},
+		{outputCSV, func(t *testing.T, lines []string) {
+			records, err := csv.NewReader(strings.NewReader(strings.Join(lines, "\n"))).ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != 3 || strings.Join(records[0], ",") != strings.Join(csvHeader, ",") {
+				t.Fatalf("records = %q", records)
+			}
+			if r := records[2]; r[1] != "red" || r[3] != "www.example.com" || r[5] != "Let's Encrypt" || r[14] != "0001-01-01T00:00:00Z" {
+				t.Errorf("record = %q", r)
+			}
+		}},
+		{outputTXT, func(t *testing.T, lines []string) {
+			if strings.Join(lines, ",") != "api.example.com,www.example.com" {
+				t.Errorf("lines = %q", lines)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "matches."+tt.format)
+			sink := newTestSink(path, tt.format)
+			sink.write(red, "api.example.com", "example", testEntry())
+			sink.write(red, "www.example.com", "example", testEntry())
+			sink.close()
+			tt.check(t, readLines(t, path))
+		})
+	}
+}
+
+func TestOutputPathTemplate(t *testing.T) {
+	dir := t.TempDir()
+	sink := newTestSink(filepath.Join(dir, "{profile}", "{target}.txt"), outputTXT)
+	red, blue := &profile{name: "red"}, &profile{name: "blue"}
+
+	sink.write(red, "a.example.com", "example.com", CertEntry{})
+	sink.write(red, "b.example.com", "example.com", CertEntry{})
+	sink.write(red, "x.corp/internal", "corp/internal", CertEntry{})
+	sink.write(blue, "c.example.com", "example.com", CertEntry{})
+	sink.close()
+
+	tests := []struct {
+		file string
+		want string
+	}{
+		{"red/example.com.txt", "a.example.com,b.example.com"},
+		{"red/corp_internal.txt", "x.corp/internal"},
+		{"blue/example.com.txt", "c.example.com"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(readLines(t, filepath.Join(dir, tt.file)), ","); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.file, got, tt.want)
+		}
+	}
+}
+
+func TestOutputRotation(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		maxSize  int64
+		compress bool
+		writes   int
+		// wantFiles counts the current file and every rotated one
+		wantFiles int
+	}{
+		{name: "no limit", format: outputTXT, writes: 10, wantFiles: 1},
+		{name: "size limit", format: outputTXT, maxSize: 40, writes: 10, wantFiles: 5},
+		{name: "size limit compressed", format: outputTXT, maxSize: 40, compress: true, writes: 10, wantFiles: 5},
+		{name: "csv header starts every file", format: outputCSV, maxSize: 400, writes: 4, wantFiles: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "matches."+tt.format)
+			sink := newTestSink(path, tt.format)
+			sink.maxSize, sink.compress = tt.maxSize, tt.compress
+			for i := 0; i < tt.writes; i++ {
+				// every txt line is 17 bytes, so two fit in 40
+				sink.write(&profile{name: defaultProfile}, "host.example.com", "example", testEntry())
+			}
+			sink.close()
+
+			files, err := filepath.Glob(filepath.Join(dir, "*"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(files)
+			if len(files) != tt.wantFiles {
+				t.Fatalf("got files %q, want %d", files, tt.wantFiles)
+			}
+
+			var lines int
+			for _, file := range files {
+				if file != path && tt.compress != strings.HasSuffix(file, ".gz") {
+					t.Errorf("rotated file %s, compress %v", file, tt.compress)
+				}
+				content := readOutputFile(t, file)
+				if tt.maxSize > 0 && int64(len(content)) > tt.maxSize {
+					t.Errorf("%s has %d bytes, over the %d byte limit", file, len(content), tt.maxSize)
+				}
+				fileLines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+				if tt.format == outputCSV {
+					if fileLines[0] != strings.Join(csvHeader, ",") {
+						t.Errorf("%s does not start with the CSV header", file)
+					}
+					fileLines = fileLines[1:]
+				}
+				lines += len(fileLines)
+			}
+			if lines != tt.writes {
+				t.Errorf("got %d lines across files, want %d", lines, tt.writes)
+			}
+		})
+	}
+}
+
+func readOutputFile(t *testing.T, path string) string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var r io.Reader = bufio.NewReader(f)
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = zr
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRotatingFileDue(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		maxSize int64
+		rotate  time.Duration
+		size    int64
+		opened  time.Time
+		next    int64
+		want    bool
+	}{
+		{name: "no limits", size: 1 << 30, opened: now.Add(-24 * time.Hour), next: 10},
+		{name: "under size", maxSize: 100, size: 50, opened: now, next: 50},
+		{name: "over size", maxSize: 100, size: 50, opened: now, next: 51, want: true},
+		{name: "empty file takes an oversized line", maxSize: 10, size: 0, opened: now, next: 100},
+		{name: "interval not reached", rotate: time.Hour, opened: now.Add(-59 * time.Minute)},
+		{name: "interval reached", rotate: time.Hour, opened: now.Add(-time.Hour), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &rotatingFile{sink: &outputSink{maxSize: tt.maxSize, rotate: tt.rotate}, size: tt.size, opened: tt.opened}
+			if got := f.due(tt.next); got != tt.want {
+				t.Errorf("due(%d) = %v, want %v", tt.next, got, tt.want)
+			}
+		})
+	}
+}
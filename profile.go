@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const defaultProfile = "default"
+
+type profile struct {
+	name           string
+	targets        []string
+	webhookURL     string
+	telegramToken  string
+	telegramChatID string
+	notifyDiscord  bool
+	notifyTelegram bool
+	notifier       *notificationBuffer
+	muted          map[string]bool
+}
+
+var profiles []*profile
+
+func newProfile(name string, pc *ProfileConfig) *profile {
+	p := &profile{name: name}
+	if pc != nil {
+		webhook := pc.Webhook
+		if webhook == `""` {
+			webhook = ""
+		}
+		p.webhookURL = strings.TrimSpace(webhook)
+		p.telegramToken = strings.TrimSpace(pc.TelegramBotToken)
+		p.telegramChatID = strings.TrimSpace(pc.TelegramChatID)
+		p.targets = pc.Targets
+	}
+	p.notifier = newNotificationBuffer(p)
+	return p
+}
+
+// selectProfiles resolves the -profile selection against the configuration.
+// An empty selection uses the top-level settings, or every named profile when
+// the top-level settings have no targets of their own.
+func selectProfiles(cfg *Config, selection string) ([]*profile, error) {
+	selection = strings.TrimSpace(selection)
+
+	if cfg == nil {
+		if selection != "" && selection != defaultProfile {
+			return nil, fmt.Errorf("profile %q requested but no configuration file found", selection)
+		}
+		return []*profile{newProfile(defaultProfile, nil)}, nil
+	}
+
+	var names []string
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	switch {
+	case selection == "" && (len(cfg.Targets) > 0 || len(names) == 0):
+		return []*profile{newProfile(defaultProfile, &cfg.ProfileConfig)}, nil
+	case selection == "" || selection == "all":
+		var selected []*profile
+		if len(cfg.Targets) > 0 {
+			selected = append(selected, newProfile(defaultProfile, &cfg.ProfileConfig))
+		}
+		for _, name := range names {
+			selected = append(selected, newProfile(name, cfg.Profiles[name]))
+		}
+		return selected, nil
+	}
+
+	seen := make(map[string]bool)
+	var selected []*profile
+	for _, name := range strings.Split(selection, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		if name == defaultProfile {
+			selected = append(selected, newProfile(defaultProfile, &cfg.ProfileConfig))
+			continue
+		}
+		pc, exists := cfg.Profiles[name]
+		if !exists {
+			return nil, fmt.Errorf("unknown profile %q (available: %s)", name, strings.Join(append([]string{defaultProfile}, names...), ", "))
+		}
+		selected = append(selected, newProfile(name, pc))
+	}
+
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no profiles selected")
+	}
+	return selected, nil
+}
+
+func (p *profile) discordConfigured() bool {
+	return p.webhookURL != ""
+}
+
+func (p *profile) telegramConfigured() bool {
+	return p.telegramToken != "" && p.telegramChatID != ""
+}
+
+func (p *profile) notificationStatus() string {
+	switch {
+	case p.notifyDiscord && p.notifyTelegram:
+		return "discord, telegram"
+	case p.notifyDiscord:
+		return "discord"
+	case p.notifyTelegram:
+		return "telegram"
+	default:
+		return "off"
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSelectProfiles(t *testing.T) {
+	withDefault := &Config{
+		ProfileConfig: ProfileConfig{Webhook: "https://discord.test/default", Targets: []string{"example.com"}},
+		Profiles: map[string]*ProfileConfig{
+			"red":  {Webhook: "https://discord.test/red", Targets: []string{"red.com"}},
+			"blue": {TelegramBotToken: "token", TelegramChatID: "42", Targets: []string{"blue.com"}},
+		},
+	}
+	onlyNamed := &Config{
+		Profiles: map[string]*ProfileConfig{
+			"red":  {Targets: []string{"red.com"}},
+			"blue": {Targets: []string{"blue.com"}},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		cfg       *Config
+		selection string
+		want      []string
+		wantErr   string
+	}{
+		{name: "no config", cfg: nil, want: []string{"default"}},
+		{name: "no config default", cfg: nil, selection: "default", want: []string{"default"}},
+		{name: "no config named", cfg: nil, selection: "red", wantErr: "no configuration file found"},
+		{name: "top-level targets", cfg: withDefault, want: []string{"default"}},
+		{name: "only named profiles", cfg: onlyNamed, want: []string{"blue", "red"}},
+		{name: "all", cfg: withDefault, selection: "all", want: []string{"default", "blue", "red"}},
+		{name: "all without top-level targets", cfg: onlyNamed, selection: "all", want: []string{"blue", "red"}},
+		{name: "one", cfg: withDefault, selection: "red", want: []string{"red"}},
+		{name: "list keeps order and drops repeats", cfg: withDefault, selection: " red, default ,red,", want: []string{"red", "default"}},
+		{name: "unknown", cfg: withDefault, selection: "green", wantErr: `unknown profile "green" (available: default, blue, red)`},
+		{name: "empty list", cfg: withDefault, selection: ",", wantErr: "no profiles selected"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, err := selectProfiles(tt.cfg, tt.selection)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("selectProfiles() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("selectProfiles() error = %v", err)
+			}
+			var names []string
+			for _, p := range selected {
+				names = append(names, p.name)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("selectProfiles() = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewProfile(t *testing.T) {
+	tests := []struct {
+		name         string
+		pc           *ProfileConfig
+		wantWebhook  string
+		wantDiscord  bool
+		wantTelegram bool
+	}{
+		{name: "nil config", pc: nil},
+		{name: "template placeholder", pc: &ProfileConfig{Webhook: `""`}},
+		{name: "webhook is trimmed", pc: &ProfileConfig{Webhook: " https://discord.test/x \n"}, wantWebhook: "https://discord.test/x", wantDiscord: true},
+		{name: "telegram needs token and chat", pc: &ProfileConfig{TelegramBotToken: "token"}},
+		{name: "telegram", pc: &ProfileConfig{TelegramBotToken: " token ", TelegramChatID: "42"}, wantTelegram: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newProfile("test", tt.pc)
+			if p.webhookURL != tt.wantWebhook {
+				t.Errorf("webhookURL = %q, want %q", p.webhookURL, tt.wantWebhook)
+			}
+			if p.discordConfigured() != tt.wantDiscord {
+				t.Errorf("discordConfigured() = %v, want %v", p.discordConfigured(), tt.wantDiscord)
+			}
+			if p.telegramConfigured() != tt.wantTelegram {
+				t.Errorf("telegramConfigured() = %v, want %v", p.telegramConfigured(), tt.wantTelegram)
+			}
+			if p.notifier == nil {
+				t.Error("notifier not set")
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	ct "github.com/google/certificate-transparency-go"
+)
+
+// maxReplayLine is the longest recorded entry read back; certificates with
+// large SAN lists plus their issuer stay well below it.
+const maxReplayLine = 4 << 20
+
+var (
+	recordPath  string
+	replayPath  string
+	replaySpeed float64
+	recorder    *entryRecorder
+)
+
+func addRecordFlags(fs *flag.FlagSet) {
+	fs.StringVar(&recordPath, "record", "", "save every log entry to this JSONL `file` (.gz to compress) for -replay")
+}
+
+func addReplayFlags(fs *flag.FlagSet) {
+	fs.StringVar(&replayPath, "replay", "", "read entries from a `file` saved with -record instead of the CT logs")
+	fs.Float64Var(&replaySpeed, "replay-speed", 1, "replay speed: 1 keeps the recorded timing, 10 is ten times faster, 0 is as fast as possible")
+}
+
+// recordedEntry is one line of a recording. Certificates are kept as DER
+// so a replay goes through the same parsing as live entries.
+type recordedEntry struct {
+	LogURL     string    `json:"log_url"`
+	Index      int64     `json:"index"`
+	Timestamp  uint64    `json:"timestamp"`
+	EntryType  string    `json:"entry_type"`
+	Cert       []byte    `json:"cert"`
+	DER        []byte    `json:"der,omitempty"`
+	Issuer     []byte    `json:"issuer,omitempty"`
+	ObservedAt time.Time `json:"observed_at"`
+}
+
+func applyRecordConfig() error {
+	if recordPath == "" {
+		return nil
+	}
+	path, err := expandHome(recordPath)
+	if err != nil {
+		return err
+	}
+	if replayPath != "" {
+		if replay, err := expandHome(replayPath); err == nil && replay == path {
+			return fmt.Errorf("-record and -replay must not be the same file")
+		}
+	}
+	recorder, err = newEntryRecorder(path)
+	return err
+}
+
+// entryRecorder appends raw log entries to a file. Writes are buffered and
+// flushed when the recorder is closed.
+type entryRecorder struct {
+	mu   sync.Mutex
+	file *os.File
+	gz   *gzip.Writer
+	buf  *bufio.Writer
+}
+
+func newEntryRecorder(path string) (*entryRecorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	r := &entryRecorder{file: file}
+	var w io.Writer = file
+	if strings.HasSuffix(path, ".gz") {
+		r.gz = gzip.NewWriter(file)
+		w = r.gz
+	}
+	r.buf = bufio.NewWriter(w)
+	return r, nil
+}
+
+func (r *entryRecorder) write(logURL string, entry rawEntry) {
+	rec := recordedEntry{
+		LogURL:     logURL,
+		Index:      entry.index,
+		Timestamp:  entry.timestamp,
+		EntryType:  entryTypeCert,
+		Cert:       entry.cert,
+		Issuer:     entry.issuer,
+		ObservedAt: time.Now().UTC(),
+	}
+	if entry.entryType == ct.PrecertLogEntryType {
+		rec.EntryType = entryTypePrecert
+	}
+	if !bytes.Equal(entry.der, entry.cert) {
+		rec.DER = entry.der
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		logger.Error("failed to encode recorded entry", "error", err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.buf.Write(line)
+	if err := r.buf.WriteByte('\n'); err != nil {
+		logger.Error("failed to record entry", "error", err)
+	}
+}
+
+func (r *entryRecorder) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.buf.Flush(); err != nil {
+		logger.Error("failed to record entries", "error", err)
+	}
+	if r.gz != nil {
+		r.gz.Close()
+	}
+	r.file.Close()
+}
+
+func closeRecorder() {
+	if recorder != nil {
+		recorder.close()
+	}
+}
+
+// replaySource reads a recording and emits its entries at the times they
+// were recorded, relative to the first entry and divided by the speed.
+type replaySource struct {
+	path    string
+	speed   float64
+	entries chan CertEntry
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func newReplaySource(path string, speed float64) *replaySource {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &replaySource{
+		path:    path,
+		speed:   speed,
+		entries: make(chan CertEntry, bufferSize),
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+}
+
+func (r *replaySource) Start() <-chan CertEntry {
+	go r.run()
+	return r.entries
+}
+
+func (r *replaySource) Stop() {
+	r.cancel()
+	<-r.done
+}
+
+func (r *replaySource) healthy() error { return nil }
+
+func (r *replaySource) ready() error { return nil }
+
+func (r *replaySource) queue() (int, int) {
+	return len(r.entries), cap(r.entries)
+}
+
+func (r *replaySource) run() {
+	defer close(r.done)
+	defer close(r.entries)
+
+	count, err := r.replay()
+	if err != nil {
+		logger.Error("replay failed", "file", r.path, "entries", count, "error", err)
+		return
+	}
+	logger.Info("replay finished", "file", r.path, "entries", count)
+}
+
+func (r *replaySource) replay() (int, error) {
+	file, err := os.Open(r.path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var in io.Reader = file
+	if strings.HasSuffix(r.path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return 0, err
+		}
+		defer gz.Close()
+		in = gz
+	}
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), maxReplayLine)
+
+	count, line := 0, 0
+	var first, started time.Time
+	for scanner.Scan() {
+		line++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var rec recordedEntry
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return count, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		entry, err := newCertEntry(rec.LogURL, rec.rawEntry())
+		if err != nil {
+			logger.Warn("skipping unparsable recorded entry", "line", line, "error", err)
+			continue
+		}
+		if len(entry.Domains) == 0 {
+			continue
+		}
+
+		if r.speed > 0 {
+			if first.IsZero() {
+				first, started = rec.ObservedAt, time.Now()
+			}
+			offset := time.Duration(float64(rec.ObservedAt.Sub(first)) / r.speed)
+			if wait := time.Until(started.Add(offset)); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-r.ctx.Done():
+					return count, nil
+				}
+			}
+		}
+
+		select {
+		case r.entries <- entry:
+			count++
+		case <-r.ctx.Done():
+			return count, nil
+		}
+	}
+	return count, scanner.Err()
+}
+
+func (rec recordedEntry) rawEntry() rawEntry {
+	entry := rawEntry{
+		index:     rec.Index,
+		timestamp: rec.Timestamp,
+		entryType: ct.X509LogEntryType,
+		cert:      rec.Cert,
+		der:       rec.DER,
+		issuer:    rec.Issuer,
+	}
+	if rec.EntryType == entryTypePrecert {
+		entry.entryType = ct.PrecertLogEntryType
+	}
+	if entry.der == nil {
+		entry.der = rec.Cert
+	}
+	return entry
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	ct "github.com/google/certificate-transparency-go"
+)
+
+// replayAll returns every entry of a recording replayed at speed, and how
+// long the replay took.
+func replayAll(t *testing.T, path string, speed float64) ([]CertEntry, time.Duration) {
+	t.Helper()
+	started := time.Now()
+	replay := newReplaySource(path, speed)
+	defer replay.Stop()
+
+	var entries []CertEntry
+	timeout := time.After(10 * time.Second)
+	stream := replay.Start()
+	for {
+		select {
+		case entry, ok := <-stream:
+			if !ok {
+				return entries, time.Since(started)
+			}
+			entries = append(entries, entry)
+		case <-timeout:
+			t.Fatalf("replay of %s did not finish", path)
+		}
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	leaf := testCertificate(t, "www.example.com", "api.example.com")
+	parsed, err := x509.ParseCertificate(leaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := testCertificate(t, "Test CA")
+
+	recorded := []struct {
+		logURL string
+		entry  rawEntry
+	}{
+		{"https://ct.example/a/", rawEntry{index: 7, timestamp: 1000, entryType: ct.X509LogEntryType, cert: leaf, der: leaf, issuer: issuer}},
+		{"https://ct.example/b/", rawEntry{index: 3, timestamp: 2000, entryType: ct.PrecertLogEntryType, cert: parsed.RawTBSCertificate, der: leaf}},
+		{"https://ct.example/a/", rawEntry{index: 8, timestamp: 3000, entryType: ct.X509LogEntryType, cert: testCertificate(t, "shop.acme.test"), issuer: issuer}},
+	}
+	recorded[2].entry.der = recorded[2].entry.cert
+
+	var want []CertEntry
+	for _, r := range recorded {
+		entry, err := newCertEntry(r.logURL, r.entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, entry)
+	}
+
+	for _, name := range []string{"entries.jsonl", "entries.jsonl.gz"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			rec, err := newEntryRecorder(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range recorded {
+				rec.write(r.logURL, r.entry)
+			}
+			rec.close()
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gzipped := bytes.HasPrefix(data, []byte{0x1f, 0x8b}); gzipped != (filepath.Ext(name) == ".gz") {
+				t.Errorf("recording compressed = %v", gzipped)
+			}
+
+			got, _ := replayAll(t, path, 0)
+			if len(got) != len(want) {
+				t.Fatalf("replayed %d entries, want %d", len(got), len(want))
+			}
+			for i := range want {
+				if !reflect.DeepEqual(got[i].Domains, want[i].Domains) || got[i].LogURL != want[i].LogURL ||
+					got[i].LogIndex != want[i].LogIndex || got[i].EntryType != want[i].EntryType ||
+					got[i].Fingerprint != want[i].Fingerprint || !bytes.Equal(got[i].issuer, want[i].issuer) {
+					t.Errorf("entry %d: replayed %+v, want %+v", i, got[i], want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReplaySpeed(t *testing.T) {
+	cert := testCertificate(t, "www.example.com")
+	path := filepath.Join(t.TempDir(), "entries.jsonl")
+	var recording bytes.Buffer
+	first := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	for i := range 3 {
+		line, err := json.Marshal(recordedEntry{
+			LogURL:     "https://ct.example/a/",
+			Index:      int64(i),
+			EntryType:  entryTypeCert,
+			Cert:       cert,
+			ObservedAt: first.Add(time.Duration(i) * 150 * time.Millisecond),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		recording.Write(append(line, '\n'))
+	}
+	recording.WriteString("\n")
+	if err := os.WriteFile(path, recording.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		speed    float64
+		min, max time.Duration
+	}{
+		{speed: 0, max: 150 * time.Millisecond},
+		{speed: 1, min: 300 * time.Millisecond, max: 2 * time.Second},
+		{speed: 10, min: 30 * time.Millisecond, max: 300 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		entries, elapsed := replayAll(t, path, tt.speed)
+		if len(entries) != 3 {
+			t.Fatalf("speed %g replayed %d entries, want 3", tt.speed, len(entries))
+		}
+		for i, entry := range entries {
+			if entry.LogIndex != int64(i) {
+				t.Errorf("speed %g: entry %d has index %d", tt.speed, i, entry.LogIndex)
+			}
+		}
+		if elapsed < tt.min || elapsed > tt.max {
+			t.Errorf("speed %g took %v, want between %v and %v", tt.speed, elapsed, tt.min, tt.max)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const schemaID = "https://github.com/tomaquet18/crtmon/schema/v%d.json"
+
+// jsonSchema is the subset of JSON Schema draft 2020-12 used to describe the
+// events.
+type jsonSchema struct {
+	Schema      string                 `json:"$schema,omitempty"`
+	ID          string                 `json:"$id,omitempty"`
+	Title       string                 `json:"title,omitempty"`
+	Description string                 `json:"description,omitempty"`
+	Type        string                 `json:"type,omitempty"`
+	Format      string                 `json:"format,omitempty"`
+	Const       interface{}            `json:"const,omitempty"`
+	Enum        []string               `json:"enum,omitempty"`
+	Properties  map[string]*jsonSchema `json:"properties,omitempty"`
+	Required    []string               `json:"required,omitempty"`
+	Items       *jsonSchema            `json:"items,omitempty"`
+	OneOf       []*jsonSchema          `json:"oneOf,omitempty"`
+	Defs        map[string]*jsonSchema `json:"$defs,omitempty"`
+	Ref         string                 `json:"$ref,omitempty"`
+
+	AdditionalProperties *bool `json:"additionalProperties,omitempty"`
+}
+
+// eventSchema describes every event crtmon writes with -json, one JSON
+// object per line. It is derived from the event types, so it cannot drift
+// from the output.
+func eventSchema() (*jsonSchema, error) {
+	events := []struct {
+		name, description string
+		event             interface{}
+	}{
+		{eventMatch, "A domain in a CT log entry matched a target.", matchEvent{}},
+		{eventAlert, "A CT log misbehaved; only with -verify-sth.", alertEvent{}},
+		{eventError, "An event could not be encoded.", errorEvent{}},
+	}
+
+	schema := &jsonSchema{
+		Schema:      "https://json-schema.org/draft/2020-12/schema",
+		ID:          fmt.Sprintf(schemaID, schemaVersion),
+		Title:       "crtmon event",
+		Description: fmt.Sprintf("An event written by crtmon -json, one per line. schema_version is %d.", schemaVersion),
+		Defs:        make(map[string]*jsonSchema),
+	}
+	for _, e := range events {
+		def, err := schemaFor(reflect.TypeOf(e.event))
+		if err != nil {
+			return nil, fmt.Errorf("%s event: %w", e.name, err)
+		}
+		def.Title = e.name
+		def.Description = e.description
+		def.Properties["schema_version"].Const = schemaVersion
+		schema.Defs[e.name] = def
+		schema.OneOf = append(schema.OneOf, &jsonSchema{Ref: "#/$defs/" + e.name})
+	}
+	return schema, nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func schemaFor(t reflect.Type) (*jsonSchema, error) {
+	switch {
+	case t == timeType:
+		return &jsonSchema{Type: "string", Format: "date-time"}, nil
+	case t.Kind() == reflect.Struct:
+		return structSchema(t)
+	case t.Kind() == reflect.Slice:
+		items, err := schemaFor(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &jsonSchema{Type: "array", Items: items}, nil
+	case t.Kind() == reflect.String:
+		return &jsonSchema{Type: "string"}, nil
+	case t.Kind() == reflect.Bool:
+		return &jsonSchema{Type: "boolean"}, nil
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return &jsonSchema{Type: "integer"}, nil
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return &jsonSchema{Type: "number"}, nil
+	}
+	return nil, fmt.Errorf("no JSON schema for type %s", t)
+}
+
+// structSchema describes the exported fields of a struct from their json,
+// doc, enum and const tags. Fields without omitempty are required.
+func structSchema(t reflect.Type) (*jsonSchema, error) {
+	closed := false
+	schema := &jsonSchema{
+		Type:                 "object",
+		Properties:           make(map[string]*jsonSchema),
+		AdditionalProperties: &closed,
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if !field.IsExported() || tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+
+		property, err := schemaFor(field.Type)
+		if err != nil {
+			return nil, fmt.Errorf("field %s.%s: %w", t.Name(), field.Name, err)
+		}
+		property.Description = field.Tag.Get("doc")
+		if c := field.Tag.Get("const"); c != "" {
+			property.Const = c
+		}
+		if enum := field.Tag.Get("enum"); enum != "" {
+			property.Enum = strings.Split(enum, ",")
+		}
+		schema.Properties[name] = property
+		if !strings.Contains(options, "omitempty") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	return schema, nil
+}
+
+func runSchema(args []string) {
+	schema, err := eventSchema()
+	if err != nil {
+		logger.Fatal("failed to build schema", "error", err)
+	}
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		logger.Fatal("failed to encode schema", "error", err)
+	}
+	fmt.Println(string(data))
+}
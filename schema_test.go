@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSchemaFor(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   any
+		want    string
+		wantErr string
+	}{
+		{name: "time", value: time.Time{}, want: `{"type":"string","format":"date-time"}`},
+		{name: "string", value: "", want: `{"type":"string"}`},
+		{name: "bool", value: false, want: `{"type":"boolean"}`},
+		{name: "int", value: int(0), want: `{"type":"integer"}`},
+		{name: "int64", value: int64(0), want: `{"type":"integer"}`},
+		{name: "uint64", value: uint64(0), want: `{"type":"integer"}`},
+		{name: "float64", value: float64(0), want: `{"type":"number"}`},
+		{name: "strings", value: []string{}, want: `{"type":"array","items":{"type":"string"}}`},
+		{name: "times", value: []time.Time{}, want: `{"type":"array","items":{"type":"string","format":"date-time"}}`},
+		{name: "map", value: map[string]string{}, wantErr: "no JSON schema for type map[string]string"},
+		{name: "pointer", value: new(int), wantErr: "no JSON schema for type *int"},
+		{name: "slice of unsupported", value: []chan int{}, wantErr: "no JSON schema for type chan int"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema, err := schemaFor(reflect.TypeOf(tt.value))
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("schemaFor() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("schemaFor() error = %v", err)
+			}
+			data, _ := json.Marshal(schema)
+			if string(data) != tt.want {
+				t.Errorf("schemaFor() = %s, want %s", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestStructSchema(t *testing.T) {
+	type inner struct {
+		Value int `json:"value"`
+	}
+	type sample struct {
+		Kind     string  `json:"kind" const:"sample" doc:"The kind."`
+		Level    string  `json:"level,omitempty" enum:"low,high"`
+		Items    []inner `json:"items"`
+		Untagged bool    ``
+		Skipped  string  `json:"-"`
+		hidden   string
+		Tags     []string `json:"tags,omitempty"`
+	}
+	type broken struct {
+		Lookup map[string]int `json:"lookup"`
+	}
+
+	schema, err := structSchema(reflect.TypeOf(sample{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schema.Type != "object" || schema.AdditionalProperties == nil || *schema.AdditionalProperties {
+		t.Errorf("schema is not a closed object: %+v", schema)
+	}
+
+	var names []string
+	for name := range schema.Properties {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	if got := strings.Join(names, ","); got != "Untagged,items,kind,level,tags" {
+		t.Errorf("properties = %s", got)
+	}
+	if got := strings.Join(schema.Required, ","); got != "kind,items,Untagged" {
+		t.Errorf("required = %s", got)
+	}
+
+	tests := []struct {
+		property string
+		want     string
+	}{
+		{"kind", `{"description":"The kind.","type":"string","const":"sample"}`},
+		{"level", `{"type":"string","enum":["low","high"]}`},
+		{"items", `{"type":"array","items":{"type":"object","properties":{"value":{"type":"integer"}},"required":["value"],"additionalProperties":false}}`},
+	}
+	for _, tt := range tests {
+		data, _ := json.Marshal(schema.Properties[tt.property])
+		if string(data) != tt.want {
+			t.Errorf("%s = %s, want %s", tt.property, data, tt.want)
+		}
+	}
+
+	if _, err := structSchema(reflect.TypeOf(broken{})); err == nil || !strings.Contains(err.Error(), "field broken.Lookup") {
+		t.Errorf("structSchema() error = %v, want the unsupported field named", err)
+	}
+}
+
+// checkSchema reports where a decoded JSON value does not follow schema:
+// unknown or missing properties, wrong types and constants.
+func checkSchema(path string, schema *jsonSchema, value any) []string {
+	var problems []string
+	wrongType := func() []string {
+		return []string{fmt.Sprintf("%s: %T is not %s", path, value, schema.Type)}
+	}
+	switch schema.Type {
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return wrongType()
+		}
+		for _, name := range schema.Required {
+			if _, ok := obj[name]; !ok {
+				problems = append(problems, fmt.Sprintf("%s.%s: required but missing", path, name))
+			}
+		}
+		for name, v := range obj {
+			property, ok := schema.Properties[name]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s.%s: not in schema", path, name))
+				continue
+			}
+			problems = append(problems, checkSchema(path+"."+name, property, v)...)
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			return wrongType()
+		}
+		for i, item := range items {
+			problems = append(problems, checkSchema(fmt.Sprintf("%s[%d]", path, i), schema.Items, item)...)
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return wrongType()
+		}
+		if schema.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %q is not a date-time", path, s))
+			}
+		}
+		if len(schema.Enum) > 0 && !slices.Contains(schema.Enum, s) {
+			problems = append(problems, fmt.Sprintf("%s: %q not in %v", path, s, schema.Enum))
+		}
+	case "integer", "number":
+		if _, ok := value.(float64); !ok {
+			return wrongType()
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return wrongType()
+		}
+	}
+	if schema.Const != nil && fmt.Sprint(schema.Const) != fmt.Sprint(value) {
+		problems = append(problems, fmt.Sprintf("%s: %v is not the constant %v", path, value, schema.Const))
+	}
+	return problems
+}
+
+func testEntry() CertEntry {
+	return CertEntry{
+		Domains:      []string{"api.example.com"},
+		Issuer:       "R11",
+		IssuerOrg:    "Let's Encrypt",
+		SerialNumber: "0a1b",
+		Fingerprint:  "ff00",
+		NotBefore:    time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2025, 8, 30, 0, 0, 0, 0, time.UTC),
+		LogURL:       "https://ct.example.com/",
+		LogIndex:     42,
+		EntryType:    entryTypeCert,
+	}
+}
+
+func TestEventSchemaMatchesOutput(t *testing.T) {
+	schema, err := eventSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schema.OneOf) != len(schema.Defs) {
+		t.Fatalf("oneOf has %d entries for %d definitions", len(schema.OneOf), len(schema.Defs))
+	}
+
+	entry := testEntry()
+	entry.SCTs = []SCT{{LogID: "abc", Timestamp: time.Now().UTC(), Status: "valid"}}
+	tests := []struct {
+		def   string
+		event any
+	}{
+		{eventMatch, newMatchEvent(&profile{name: "red"}, "api.example.com", "example", entry)},
+		{eventMatch, newMatchEvent(&profile{name: defaultProfile}, "api.example.com", "example", CertEntry{Domains: []string{"api.example.com"}, EntryType: entryTypePrecert})},
+		{eventAlert, alertEvent{Event: eventAlert, SchemaVersion: schemaVersion, Alert: "bad_signature", Detail: "x", Log: "log", LogURL: "https://ct.example.com/", ObservedAt: time.Now().UTC()}},
+		{eventError, errorEvent{Event: eventError, SchemaVersion: schemaVersion, Error: "x", ObservedAt: time.Now().UTC()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.def, func(t *testing.T) {
+			def := schema.Defs[tt.def]
+			if def == nil {
+				t.Fatalf("no definition for %s", tt.def)
+			}
+			data, err := json.Marshal(tt.event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var value any
+			if err := json.Unmarshal(data, &value); err != nil {
+				t.Fatal(err)
+			}
+			for _, problem := range checkSchema(tt.def, def, value) {
+				t.Error(problem)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/base64"
+	"flag"
+	"slices"
+	"sync"
+	"time"
+
+	ct "github.com/google/certificate-transparency-go"
+	cttls "github.com/google/certificate-transparency-go/tls"
+	"github.com/google/certificate-transparency-go/x509"
+)
+
+const (
+	sctValid      = "valid"
+	sctInvalid    = "invalid"
+	sctUnknownLog = "unknown_log"
+	sctUnverified = "unverified"
+)
+
+var verifySCT bool
+
+func addSCTFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&verifySCT, "verify-sct", false, "verify the SCTs embedded in matched certificates against the log keys in the log list")
+}
+
+// SCT is a signed certificate timestamp embedded in a certificate: a log's
+// promise to include it. Status is only set once the SCT has been verified.
+type SCT struct {
+	LogID     string    `json:"log_id" doc:"Log ID, base64 encoded."`
+	Log       string    `json:"log,omitempty" doc:"Log description from the log list."`
+	Timestamp time.Time `json:"timestamp" doc:"Time the log issued the SCT."`
+	Status    string    `json:"status,omitempty" enum:"valid,invalid,unknown_log,unverified" doc:"Verification result with -verify-sct."`
+
+	sct *ct.SignedCertificateTimestamp
+}
+
+// sctLog is a log that may have issued SCTs, with its verifier created on
+// first use.
+type sctLog struct {
+	log      *ctLog
+	once     sync.Once
+	verifier *ct.SignatureVerifier
+}
+
+// sctLogs holds every log in the log list by log ID, not only the monitored
+// ones, since certificates carry SCTs from logs crtmon does not read.
+var sctLogs = struct {
+	mu   sync.RWMutex
+	byID map[[32]byte]*sctLog
+}{byID: make(map[[32]byte]*sctLog)}
+
+func registerSCTLogs(logs []*ctLog) {
+	sctLogs.mu.Lock()
+	defer sctLogs.mu.Unlock()
+	for _, l := range logs {
+		if len(l.LogID) != 32 {
+			continue
+		}
+		id := [32]byte(l.LogID)
+		if _, ok := sctLogs.byID[id]; !ok {
+			sctLogs.byID[id] = &sctLog{log: l}
+		}
+	}
+}
+
+func findSCTLog(id [32]byte) *sctLog {
+	sctLogs.mu.RLock()
+	defer sctLogs.mu.RUnlock()
+	return sctLogs.byID[id]
+}
+
+func (l *sctLog) signatureVerifier() *ct.SignatureVerifier {
+	l.once.Do(func() {
+		if key, err := parseLogKey(l.log.Key); err == nil && key != nil {
+			l.verifier = key.verifier
+		}
+	})
+	return l.verifier
+}
+
+// extractSCTs returns the SCTs embedded in a final certificate.
+func extractSCTs(cert *x509.Certificate) []SCT {
+	var scts []SCT
+	for _, serialized := range cert.SCTList.SCTList {
+		var sct ct.SignedCertificateTimestamp
+		if rest, err := cttls.Unmarshal(serialized.Val, &sct); err != nil || len(rest) > 0 {
+			continue
+		}
+		entry := SCT{
+			LogID:     base64.StdEncoding.EncodeToString(sct.LogID.KeyID[:]),
+			Timestamp: time.UnixMilli(int64(sct.Timestamp)).UTC(),
+			sct:       &sct,
+		}
+		if l := findSCTLog(sct.LogID.KeyID); l != nil {
+			entry.Log = l.log.Description
+		}
+		scts = append(scts, entry)
+	}
+	return scts
+}
+
+// verifySCTs checks each SCT of a certificate against the key of the log
+// that issued it. The issuer is needed to rebuild the signed precertificate;
+// without it the SCTs stay unverified. It reports whether any SCT is invalid
+// or from a log missing from the log list.
+func verifySCTs(scts []SCT, cert *x509.Certificate, issuer []byte) bool {
+	var chain []*x509.Certificate
+	if issuerCert, err := x509.ParseCertificate(issuer); len(issuer) > 0 && !x509.IsFatal(err) && issuerCert != nil {
+		chain = []*x509.Certificate{cert, issuerCert}
+	}
+
+	suspicious := false
+	for i := range scts {
+		sct := &scts[i]
+		l := findSCTLog(sct.sct.LogID.KeyID)
+		switch {
+		case l == nil:
+			sct.Status = sctUnknownLog
+			suspicious = true
+			continue
+		case chain == nil || l.signatureVerifier() == nil:
+			sct.Status = sctUnverified
+			continue
+		}
+
+		leaf, err := ct.MerkleTreeLeafForEmbeddedSCT(chain, sct.sct.Timestamp)
+		if err != nil {
+			sct.Status = sctUnverified
+			continue
+		}
+		leaf.TimestampedEntry.Extensions = sct.sct.Extensions
+		if err := l.signatureVerifier().VerifySCTSignature(*sct.sct, ct.LogEntry{Leaf: *leaf}); err != nil {
+			sct.Status = sctInvalid
+			suspicious = true
+			continue
+		}
+		sct.Status = sctValid
+	}
+	return suspicious
+}
+
+// checkSCTs returns the SCTs of a matched certificate. They are only parsed
+// once an entry matches, as most entries never do. With -verify-sct they are
+// verified, with a warning when any is invalid or from an unknown log. The
+// statuses are set on a copy, never on a slice the entry already shares.
+func checkSCTs(entry CertEntry, domain string) []SCT {
+	var scts []SCT
+	switch {
+	case entry.SCTs != nil:
+		scts = slices.Clone(entry.SCTs)
+	case entry.cert != nil:
+		scts = extractSCTs(entry.cert)
+	}
+	if !verifySCT || entry.cert == nil || !verifySCTs(scts, entry.cert, entry.issuer) {
+		return scts
+	}
+	for _, sct := range scts {
+		if sct.Status == sctInvalid || sct.Status == sctUnknownLog {
+			logger.Warn("suspicious SCT in certificate", "domain", domain, "status", sct.Status, "log_id", sct.LogID, "log", sct.Log, "timestamp", sct.Timestamp.Format(time.RFC3339))
+		}
+	}
+	return scts
+}
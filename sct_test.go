@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"math/big"
+	"testing"
+	"time"
+
+	ct "github.com/google/certificate-transparency-go"
+	cttls "github.com/google/certificate-transparency-go/tls"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/certificate-transparency-go/x509/pkix"
+)
+
+// sctFixture is a CA, a CT log and a certificate issued by the CA with an
+// SCT from the log embedded.
+type sctFixture struct {
+	t        *testing.T
+	log      *ctLog
+	logKey   *ecdsa.PrivateKey
+	caKey    *ecdsa.PrivateKey
+	ca       *x509.Certificate
+	caDER    []byte
+	leafKey  *ecdsa.PrivateKey
+	notAfter time.Time
+}
+
+func newSCTFixture(t *testing.T) *sctFixture {
+	t.Helper()
+	f := &sctFixture{t: t, logKey: testKey(t), caKey: testKey(t), leafKey: testKey(t), notAfter: time.Now().Add(24 * time.Hour)}
+
+	logKeyDER, err := x509.MarshalPKIXPublicKey(&f.logKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logID := sha256.Sum256(logKeyDER)
+	f.log = &ctLog{Description: "Test Log", LogID: logID[:], Key: logKeyDER}
+
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              f.notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	if f.caDER, err = x509.CreateCertificate(rand.Reader, ca, ca, &f.caKey.PublicKey, f.caKey); err != nil {
+		t.Fatal(err)
+	}
+	if f.ca, err = x509.ParseCertificate(f.caDER); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func testKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func (f *sctFixture) issue(domain string, scts ...[]byte) *x509.Certificate {
+	f.t.Helper()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     f.notAfter,
+	}
+	for _, sct := range scts {
+		template.SCTList.SCTList = append(template.SCTList.SCTList, x509.SerializedSCT{Val: sct})
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, f.ca, &f.leafKey.PublicKey, f.caKey)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return cert
+}
+
+// sign returns a serialized SCT from the log for a certificate of domain.
+// The signed precertificate does not include the SCT list, so a placeholder
+// certificate yields the same signature input as the final one.
+func (f *sctFixture) sign(domain string, timestamp uint64) []byte {
+	f.t.Helper()
+	placeholder := f.issue(domain, []byte{0})
+	leaf, err := ct.MerkleTreeLeafForEmbeddedSCT([]*x509.Certificate{placeholder, f.ca}, timestamp)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+
+	sct := ct.SignedCertificateTimestamp{SCTVersion: ct.V1, LogID: ct.LogID{KeyID: [32]byte(f.log.LogID)}, Timestamp: timestamp}
+	input, err := ct.SerializeSCTSignatureInput(sct, ct.LogEntry{Leaf: *leaf})
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	digest := sha256.Sum256(input)
+	sig, err := ecdsa.SignASN1(rand.Reader, f.logKey, digest[:])
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	sct.Signature = ct.DigitallySigned{
+		Algorithm: cttls.SignatureAndHashAlgorithm{Hash: cttls.SHA256, Signature: cttls.ECDSA},
+		Signature: sig,
+	}
+
+	serialized, err := cttls.Marshal(sct)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return serialized
+}
+
+// useSCTLogs replaces the known SCT logs for the duration of the test.
+func useSCTLogs(t *testing.T, logs ...*ctLog) {
+	t.Helper()
+	old := sctLogs.byID
+	sctLogs.byID = make(map[[32]byte]*sctLog)
+	registerSCTLogs(logs)
+	t.Cleanup(func() { sctLogs.byID = old })
+}
+
+func TestVerifySCTs(t *testing.T) {
+	f := newSCTFixture(t)
+	timestamp := uint64(time.Now().UnixMilli())
+	good := f.sign("www.example.com", timestamp)
+
+	tamperedSig := append([]byte(nil), good...)
+	tamperedSig[len(tamperedSig)-1] ^= 0xff
+
+	otherLog := newSCTFixture(t)
+
+	tests := []struct {
+		name           string
+		cert           *x509.Certificate
+		issuer         []byte
+		logs           []*ctLog
+		wantStatus     string
+		wantSuspicious bool
+	}{
+		{name: "valid", cert: f.issue("www.example.com", good), issuer: f.caDER, logs: []*ctLog{f.log}, wantStatus: sctValid},
+		{name: "tampered signature", cert: f.issue("www.example.com", tamperedSig), issuer: f.caDER, logs: []*ctLog{f.log}, wantStatus: sctInvalid, wantSuspicious: true},
+		{name: "tampered certificate", cert: f.issue("evil.example.com", good), issuer: f.caDER, logs: []*ctLog{f.log}, wantStatus: sctInvalid, wantSuspicious: true},
+		{name: "unknown log", cert: f.issue("www.example.com", good), issuer: f.caDER, logs: []*ctLog{otherLog.log}, wantStatus: sctUnknownLog, wantSuspicious: true},
+		{name: "no issuer", cert: f.issue("www.example.com", good), logs: []*ctLog{f.log}, wantStatus: sctUnverified},
+		{name: "log without key", cert: f.issue("www.example.com", good), issuer: f.caDER, logs: []*ctLog{{Description: "Test Log", LogID: f.log.LogID}}, wantStatus: sctUnverified},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useSCTLogs(t, tt.logs...)
+
+			scts := extractSCTs(tt.cert)
+			if len(scts) != 1 {
+				t.Fatalf("extracted %d SCTs, want 1", len(scts))
+			}
+			if want := time.UnixMilli(int64(timestamp)).UTC(); !scts[0].Timestamp.Equal(want) {
+				t.Errorf("timestamp %v, want %v", scts[0].Timestamp, want)
+			}
+
+			suspicious := verifySCTs(scts, tt.cert, tt.issuer)
+			if scts[0].Status != tt.wantStatus {
+				t.Errorf("status %q, want %q", scts[0].Status, tt.wantStatus)
+			}
+			if suspicious != tt.wantSuspicious {
+				t.Errorf("suspicious = %v, want %v", suspicious, tt.wantSuspicious)
+			}
+		})
+	}
+}
+
+func TestCheckSCTs(t *testing.T) {
+	f := newSCTFixture(t)
+	useSCTLogs(t, f.log)
+	cert := f.issue("www.example.com", f.sign("www.example.com", uint64(time.Now().UnixMilli())))
+
+	oldVerify := verifySCT
+	t.Cleanup(func() { verifySCT = oldVerify })
+
+	verifySCT = false
+	scts := checkSCTs(CertEntry{cert: cert, issuer: f.caDER}, "www.example.com")
+	if len(scts) != 1 || scts[0].Status != "" || scts[0].Log != "Test Log" {
+		t.Fatalf("without -verify-sct got %+v, want one unverified SCT from Test Log", scts)
+	}
+
+	verifySCT = true
+	shared := CertEntry{cert: cert, issuer: f.caDER, SCTs: scts}
+	verified := checkSCTs(shared, "www.example.com")
+	if len(verified) != 1 || verified[0].Status != sctValid {
+		t.Fatalf("with -verify-sct got %+v, want one valid SCT", verified)
+	}
+	if shared.SCTs[0].Status != "" {
+		t.Errorf("verification changed the SCTs shared with the entry")
+	}
+}
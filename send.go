@@ -3,8 +3,10 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 )
@@ -16,49 +18,97 @@ const (
 	maxRetries    = 3
 )
 
+// match is a matched domain with the certificate it was found in. Entry is
+// empty for messages that are not about a certificate, such as log alerts.
+type match struct {
+	domain string
+	entry  CertEntry
+}
+
 type notificationBuffer struct {
 	mu      sync.Mutex
-	pending map[string][]string
+	profile *profile
+	pending map[string][]match
 	timers  map[string]*time.Timer
+	sending sync.WaitGroup
 }
 
-var notifier = &notificationBuffer{
-	pending: make(map[string][]string),
-	timers:  make(map[string]*time.Timer),
-}
-
-func sendToDiscord(domain, target string) {
-	notifier.add(target, domain)
+func newNotificationBuffer(p *profile) *notificationBuffer {
+	return &notificationBuffer{
+		profile: p,
+		pending: make(map[string][]match),
+		timers:  make(map[string]*time.Timer),
+	}
 }
 
-func (n *notificationBuffer) add(target, domain string) {
+func (n *notificationBuffer) add(target string, m match) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
 
-	n.pending[target] = append(n.pending[target], domain)
+	n.pending[target] = append(n.pending[target], m)
 
 	if len(n.pending[target]) >= maxBatchSize {
-		domains := n.pending[target]
+		matches := n.pending[target]
 		delete(n.pending, target)
-		if timer, exists := n.timers[target]; exists {
-			timer.Stop()
-			delete(n.timers, target)
-		}
-		go n.send(target, domains)
+		n.stopTimer(target)
+		n.sending.Add(1)
+		go func() {
+			defer n.sending.Done()
+			n.send(target, matches)
+		}()
 		return
 	}
 
 	if _, exists := n.timers[target]; !exists {
+		n.sending.Add(1)
 		n.timers[target] = time.AfterFunc(batchDelay, func() {
+			defer n.sending.Done()
 			n.flush(target)
 		})
 	}
 }
 
+// stopTimer cancels the pending flush of a target. The caller holds n.mu.
+func (n *notificationBuffer) stopTimer(target string) {
+	if timer, exists := n.timers[target]; exists {
+		if timer.Stop() {
+			n.sending.Done()
+		}
+		delete(n.timers, target)
+	}
+}
+
+// flushAll sends every pending batch without waiting for its delay and
+// waits until all sends have finished.
+func (n *notificationBuffer) flushAll() {
+	n.mu.Lock()
+	pending := n.pending
+	n.pending = make(map[string][]match)
+	for target := range n.timers {
+		n.stopTimer(target)
+	}
+	n.mu.Unlock()
+
+	for target, matches := range pending {
+		n.send(target, matches)
+	}
+	n.sending.Wait()
+}
+
+// flushNotifications sends the batches still waiting in every profile, so
+// matches found just before exiting are not lost.
+func flushNotifications() {
+	for _, p := range profiles {
+		if p.notifier != nil {
+			p.notifier.flushAll()
+		}
+	}
+}
+
 func (n *notificationBuffer) flush(target string) {
 	n.mu.Lock()
-	domains, exists := n.pending[target]
-	if !exists || len(domains) == 0 {
+	matches, exists := n.pending[target]
+	if !exists || len(matches) == 0 {
 		n.mu.Unlock()
 		return
 	}
@@ -66,63 +116,80 @@ func (n *notificationBuffer) flush(target string) {
 	delete(n.timers, target)
 	n.mu.Unlock()
 
-	n.send(target, domains)
+	n.send(target, matches)
+}
+
+// sendText sends plain lines, without certificate details, to the enabled
+// providers and returns the outcome per provider.
+func (n *notificationBuffer) sendText(title string, lines []string) map[string]error {
+	matches := make([]match, len(lines))
+	for i, line := range lines {
+		matches[i] = match{domain: line}
+	}
+	return n.send(title, matches)
 }
 
-func (n *notificationBuffer) send(target string, domains []string) {
-	if notifyDiscord && webhookURL != "" {
-		n.sendDiscord(target, domains)
+// send delivers a batch to the enabled providers. Failures are logged and
+// counted; the returned map holds the error, or nil, of every provider
+// that was tried.
+func (n *notificationBuffer) send(target string, matches []match) map[string]error {
+	results := make(map[string]error)
+	if n.profile.notifyDiscord && n.profile.webhookURL != "" {
+		results["discord"] = n.sendDiscord(target, matches)
 	}
 
-	if notifyTelegram {
-		sendToTelegram(target, domains)
+	if n.profile.notifyTelegram && n.profile.telegramConfigured() {
+		results["telegram"] = n.sendTelegram(target, matches)
 	}
+	return results
 }
 
-func (n *notificationBuffer) sendDiscord(target string, domains []string) {
-	payload := buildDiscordPayload(target, domains)
+func (n *notificationBuffer) sendDiscord(target string, matches []match) error {
+	payload := buildDiscordPayload(target, matches)
 
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
 		logger.Error("failed to marshal discord payload", "error", err)
-		return
+		return err
 	}
 
 	for attempt := 0; attempt < maxRetries; attempt++ {
-		resp, err := http.Post(webhookURL, "application/json", bytes.NewBuffer(jsonData))
+		resp, err := http.Post(n.profile.webhookURL, "application/json", bytes.NewBuffer(jsonData))
 		if err != nil {
 			logger.Error("failed to send discord notification", "error", err)
-			return
+			discordStats.failures.Add(1)
+			return err
 		}
 
 		switch resp.StatusCode {
 		case http.StatusOK, http.StatusNoContent:
 			resp.Body.Close()
-			return
+			discordStats.sent.Add(1)
+			return nil
 		case http.StatusTooManyRequests:
 			resp.Body.Close()
 			logger.Warn("discord rate limited, waiting", "attempt", attempt+1)
+			discordStats.retries.Add(1)
 			time.Sleep(rateLimitWait * time.Duration(attempt+1))
 			continue
 		default:
 			resp.Body.Close()
 			logger.Warn("discord webhook error", "status", resp.StatusCode)
-			return
+			discordStats.failures.Add(1)
+			return fmt.Errorf("discord webhook returned %s", resp.Status)
 		}
 	}
 
-	logger.Error("failed to send discord after retries", "target", target)
+	logger.Error("failed to send discord after retries", "target", target, "profile", n.profile.name)
+	discordStats.failures.Add(1)
+	return errors.New("discord rate limit persisted after retries")
 }
 
-func sendToTelegram(target string, domains []string) {
-	if telegramToken == "" || telegramChatID == "" {
-		return
-	}
-
-	text := buildTelegramMessage(target, domains)
+func (n *notificationBuffer) sendTelegram(target string, matches []match) error {
+	text := buildTelegramMessage(target, matches)
 
 	payload := map[string]interface{}{
-		"chat_id":                  telegramChatID,
+		"chat_id":                  n.profile.telegramChatID,
 		"text":                     text,
 		"parse_mode":               "Markdown",
 		"disable_web_page_preview": true,
@@ -131,34 +198,87 @@ func sendToTelegram(target string, domains []string) {
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
 		logger.Error("failed to marshal telegram payload", "error", err)
-		return
+		return err
 	}
 
-	url := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", telegramToken)
+	url := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", n.profile.telegramToken)
 
 	for attempt := 0; attempt < maxRetries; attempt++ {
 		resp, err := http.Post(url, "application/json", bytes.NewBuffer(jsonData))
 		if err != nil {
 			logger.Error("failed to send telegram notification", "error", err)
-			return
+			telegramStats.failures.Add(1)
+			return err
 		}
 
 		if resp.StatusCode == http.StatusOK {
 			resp.Body.Close()
-			return
+			telegramStats.sent.Add(1)
+			return nil
 		}
 
 		if resp.StatusCode == http.StatusTooManyRequests {
 			resp.Body.Close()
 			logger.Warn("telegram rate limited, waiting", "attempt", attempt+1)
+			telegramStats.retries.Add(1)
 			time.Sleep(rateLimitWait * time.Duration(attempt+1))
 			continue
 		}
 
 		resp.Body.Close()
 		logger.Warn("telegram send error", "status", resp.StatusCode)
-		return
+		telegramStats.failures.Add(1)
+		return fmt.Errorf("telegram returned %s", resp.Status)
+	}
+
+	logger.Error("failed to send telegram after retries", "target", target, "profile", n.profile.name)
+	telegramStats.failures.Add(1)
+	return errors.New("telegram rate limit persisted after retries")
+}
+
+func runNotify(args []string) {
+	if configFlag != "" {
+		setConfigPath(configFlag)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		logger.Fatal("failed to load config", "error", err)
+	}
+	if cfg == nil {
+		logger.Fatal("no configuration file found. run 'crtmon config init' to create one")
 	}
 
-	logger.Error("failed to send telegram after retries", "target", target)
+	selected, err := selectProfiles(cfg, profileFlag)
+	if err != nil {
+		logger.Fatal("failed to select profile", "error", err)
+	}
+
+	message := "crtmon test notification"
+	if len(args) > 0 {
+		message = strings.Join(args, " ")
+	}
+
+	provider := strings.ToLower(strings.TrimSpace(notifyFlag))
+	for _, p := range selected {
+		switch provider {
+		case "":
+			p.notifyDiscord = p.discordConfigured()
+			p.notifyTelegram = p.telegramConfigured()
+		case "discord":
+			p.notifyDiscord = p.discordConfigured()
+		case "telegram":
+			p.notifyTelegram = p.telegramConfigured()
+		default:
+			logger.Fatal("invalid value for -notify. valid options are: discord, telegram")
+		}
+
+		if !p.notifyDiscord && !p.notifyTelegram {
+			logger.Warn("no notification providers configured", "profile", p.name)
+			continue
+		}
+
+		logger.Info("sending test notification", "profile", p.name, "notification", p.notificationStatus())
+		p.notifier.sendText("crtmon", []string{message})
+	}
 }
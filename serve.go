@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	serveHeartbeatInterval = 30 * time.Second
+	serveWriteTimeout      = 10 * time.Second
+	// serveClientBuffer is how many messages a slow client may fall behind
+	// before messages are dropped for it.
+	serveClientBuffer = 1000
+)
+
+// certstream serves three streams, like certstream-server: the lite stream
+// without certificates, the full stream with the certificate and issuer
+// DER, and bare domains.
+const (
+	streamLite    = "lite"
+	streamFull    = "full"
+	streamDomains = "domains"
+)
+
+var serveAddr string
+
+func addServeFlags(fs *flag.FlagSet) {
+	fs.StringVar(&serveAddr, "listen", "127.0.0.1:4000", "address to serve the certstream WebSocket feed on")
+}
+
+func runServe(args []string) {
+	logger = newLogger(false)
+	printBanner()
+
+	if configFlag != "" {
+		setConfigPath(configFlag)
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		logger.Fatal("failed to load config", "error", err)
+	}
+	applyLogListConfig(cfg)
+	if err := applyBufferConfig(cfg); err != nil {
+		logger.Fatal("invalid buffer settings", "error", err)
+	}
+	if err := applySourceConfig(cfg); err != nil {
+		logger.Fatal("invalid source settings", "error", err)
+	}
+	if err := applyRecordConfig(); err != nil {
+		logger.Fatal("failed to open recording", "error", err)
+	}
+
+	var targets []string
+	if targetFlag != "" {
+		if targets, err = resolveTargetFlag(targetFlag); err != nil {
+			logger.Fatal("failed to resolve target", "error", err)
+		}
+		logger.Info("only serving certificates matching the targets", "count", len(targets))
+	}
+	scopeFilter = strings.TrimSpace(scopeFlag)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		logger.Info("shutting down...")
+		cancel()
+	}()
+
+	hub := newCertstreamHub(targets)
+	server := &http.Server{Addr: serveAddr, Handler: hub.handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatal("certstream server failed", "addr", serveAddr, "error", err)
+		}
+	}()
+	logger.Info("serving certstream feed", "addr", serveAddr, "streams", "/, /full-stream, /domains-only")
+
+	defer closeRecorder()
+
+	source := newEntrySource()
+	defer source.Stop()
+	if metricsAddr != "" {
+		startMetricsServer(source)
+	}
+	stream := source.Start()
+	go newStatusReporter().run(ctx, false)
+
+	heartbeat := time.NewTicker(serveHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			shutdown, done := context.WithTimeout(context.Background(), serveWriteTimeout)
+			server.Shutdown(shutdown)
+			done()
+			hub.close()
+			logger.Info("goodbye")
+			return
+		case <-heartbeat.C:
+			hub.heartbeat()
+		case entry, ok := <-stream:
+			if !ok {
+				return
+			}
+			hub.broadcast(entry)
+		}
+	}
+}
+
+// certstreamHub fans entries out to the connected WebSocket clients.
+type certstreamHub struct {
+	targets  []string
+	upgrader websocket.Upgrader
+
+	mu      sync.Mutex
+	clients map[*streamClient]bool
+}
+
+type streamClient struct {
+	stream  string
+	targets []string
+	send    chan *websocket.PreparedMessage
+	dropped int
+}
+
+func newCertstreamHub(targets []string) *certstreamHub {
+	return &certstreamHub{
+		targets: targets,
+		// the feed is read-only and meant for other tools, so any origin
+		// may connect
+		upgrader: websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }},
+		clients:  make(map[*streamClient]bool),
+	}
+}
+
+func (h *certstreamHub) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", h.serveStream(streamLite))
+	mux.HandleFunc("/full-stream", h.serveStream(streamFull))
+	mux.HandleFunc("/domains-only", h.serveStream(streamDomains))
+	return mux
+}
+
+// serveStream upgrades the request and writes the stream to the client.
+// The ?target= parameter, comma separated, narrows the feed further.
+func (h *certstreamHub) serveStream(stream string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if stream == streamLite && r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		conn, err := h.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		client := &streamClient{stream: stream, send: make(chan *websocket.PreparedMessage, serveClientBuffer)}
+		for _, target := range strings.Split(r.URL.Query().Get("target"), ",") {
+			if target = strings.TrimSpace(target); target != "" {
+				client.targets = append(client.targets, target)
+			}
+		}
+		h.mu.Lock()
+		h.clients[client] = true
+		h.mu.Unlock()
+		logger.Info("certstream client connected", "remote", r.RemoteAddr, "stream", stream, "targets", len(client.targets))
+
+		// the read loop answers pings and notices when the client leaves
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+
+		defer func() {
+			h.mu.Lock()
+			delete(h.clients, client)
+			dropped := client.dropped
+			h.mu.Unlock()
+			logger.Info("certstream client disconnected", "remote", r.RemoteAddr, "stream", stream, "dropped", dropped)
+		}()
+		for {
+			select {
+			case <-closed:
+				return
+			case msg, ok := <-client.send:
+				if !ok {
+					conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(serveWriteTimeout))
+					return
+				}
+				conn.SetWriteDeadline(time.Now().Add(serveWriteTimeout))
+				if err := conn.WritePreparedMessage(msg); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (h *certstreamHub) broadcast(entry CertEntry) {
+	if len(h.targets) > 0 && !entryMatches(entry, h.targets) {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	messages := make(map[string]*websocket.PreparedMessage)
+	for client := range h.clients {
+		if len(client.targets) > 0 && !entryMatches(entry, client.targets) {
+			continue
+		}
+		msg, ok := messages[client.stream]
+		if !ok {
+			msg = prepareMessage(certstreamPayload(entry, client.stream))
+			messages[client.stream] = msg
+		}
+		h.deliver(client, msg)
+	}
+}
+
+func (h *certstreamHub) heartbeat() {
+	msg := prepareMessage(map[string]any{"message_type": "heartbeat", "timestamp": time.Now().Unix()})
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for client := range h.clients {
+		h.deliver(client, msg)
+	}
+}
+
+// deliver queues a message without blocking; a client that cannot keep up
+// loses messages instead of slowing the feed down. The caller holds h.mu.
+func (h *certstreamHub) deliver(client *streamClient, msg *websocket.PreparedMessage) {
+	if msg == nil {
+		return
+	}
+	select {
+	case client.send <- msg:
+	default:
+		client.dropped++
+	}
+}
+
+func (h *certstreamHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for client := range h.clients {
+		close(client.send)
+		delete(h.clients, client)
+	}
+}
+
+func entryMatches(entry CertEntry, targets []string) bool {
+	for _, domain := range entry.Domains {
+		for _, target := range targets {
+			if domainMatches(domain, target) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func prepareMessage(v any) *websocket.PreparedMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		logger.Error("failed to encode certstream message", "error", err)
+		return nil
+	}
+	msg, err := websocket.NewPreparedMessage(websocket.TextMessage, data)
+	if err != nil {
+		logger.Error("failed to prepare certstream message", "error", err)
+		return nil
+	}
+	return msg
+}
+
+// certstreamPayload builds a message in the certstream format. Fields crtmon
+// does not know, such as the certificate extensions, are left out.
+func certstreamPayload(entry CertEntry, stream string) any {
+	if stream == streamDomains {
+		return map[string]any{"message_type": "dns_entries", "data": entry.Domains}
+	}
+
+	leaf := map[string]any{
+		"subject":             certstreamDN(entry.Subject),
+		"issuer":              certstreamDN(entry.IssuerDN),
+		"all_domains":         entry.Domains,
+		"not_before":          entry.NotBefore.Unix(),
+		"not_after":           entry.NotAfter.Unix(),
+		"serial_number":       strings.ToUpper(entry.SerialNumber),
+		"signature_algorithm": entry.SignatureAlgorithm,
+		"extensions":          map[string]any{"subjectAltName": subjectAltName(entry.Domains)},
+	}
+	if len(entry.der) > 0 {
+		sum1 := sha1.Sum(entry.der)
+		sum256 := sha256.Sum256(entry.der)
+		leaf["fingerprint"] = colonHex(sum1[:])
+		leaf["sha1"] = colonHex(sum1[:])
+		leaf["sha256"] = colonHex(sum256[:])
+	}
+	chain := []any{}
+	if stream == streamFull {
+		if len(entry.der) > 0 {
+			leaf["as_der"] = base64.StdEncoding.EncodeToString(entry.der)
+		}
+		if len(entry.issuer) > 0 {
+			chain = append(chain, map[string]any{
+				"subject": certstreamDN(entry.IssuerDN),
+				"as_der":  base64.StdEncoding.EncodeToString(entry.issuer),
+			})
+		}
+	}
+
+	updateType := "X509LogEntry"
+	if entry.EntryType == entryTypePrecert {
+		updateType = "PrecertLogEntry"
+	}
+	name := entry.LogURL
+	if stats := findLogStats(entry.LogURL); stats != nil && stats.description != "" {
+		name = stats.description
+	}
+
+	return map[string]any{
+		"message_type": "certificate_update",
+		"data": map[string]any{
+			"update_type": updateType,
+			"leaf_cert":   leaf,
+			"chain":       chain,
+			"cert_index":  entry.LogIndex,
+			"cert_link":   "",
+			"seen":        float64(time.Now().UnixMilli()) / 1000,
+			"source": map[string]any{
+				"url":  strings.TrimPrefix(strings.TrimPrefix(entry.LogURL, "https://"), "http://"),
+				"name": name,
+			},
+		},
+	}
+}
+
+// certstreamDN splits an RFC 2253 name such as "CN=Name,O=Org,C=US" into
+// certstream's attribute map with the "/C=US/O=Org/CN=Name" aggregate.
+func certstreamDN(dn string) map[string]any {
+	fields := map[string]any{}
+	if dn == "" {
+		fields["aggregated"] = ""
+		return fields
+	}
+	parts := strings.Split(dn, ",")
+	aggregated := make([]string, 0, len(parts))
+	for i := len(parts) - 1; i >= 0; i-- {
+		key, value, ok := strings.Cut(parts[i], "=")
+		if !ok {
+			continue
+		}
+		if _, exists := fields[key]; !exists {
+			fields[key] = value
+		}
+		aggregated = append(aggregated, parts[i])
+	}
+	fields["aggregated"] = "/" + strings.Join(aggregated, "/")
+	return fields
+}
+
+func subjectAltName(domains []string) string {
+	names := make([]string, len(domains))
+	for i, d := range domains {
+		names[i] = "DNS:" + d
+	}
+	return strings.Join(names, ", ")
+}
+
+func colonHex(b []byte) string {
+	h := strings.ToUpper(hex.EncodeToString(b))
+	var out strings.Builder
+	for i := 0; i < len(h); i += 2 {
+		if i > 0 {
+			out.WriteByte(':')
+		}
+		out.WriteString(h[i : i+2])
+	}
+	return out.String()
+}
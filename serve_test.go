@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ct "github.com/google/certificate-transparency-go"
+)
+
+// TestServeRoundTrip broadcasts an entry through serve and reads it back
+// with the certstream client source, on each of the three streams.
+func TestServeRoundTrip(t *testing.T) {
+	useLogStats(t)
+	der := testCertDER(t, "api.example.com", "www.example.com")
+	entry, err := newCertEntry("https://ct.example.com/log/", rawEntry{
+		index:     42,
+		timestamp: 1748736000000,
+		entryType: ct.X509LogEntryType,
+		cert:      der,
+		der:       der,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path  string
+		check func(t *testing.T, got CertEntry)
+	}{
+		{"/", func(t *testing.T, got CertEntry) {
+			if got.SerialNumber != entry.SerialNumber || got.Fingerprint != entry.Fingerprint || got.IssuerDN != entry.IssuerDN || got.Subject != entry.Subject {
+				t.Errorf("lite entry = %+v, want %+v", got, entry)
+			}
+			if !got.NotBefore.Equal(entry.NotBefore) || !got.NotAfter.Equal(entry.NotAfter) {
+				t.Errorf("validity = %s - %s, want %s - %s", got.NotBefore, got.NotAfter, entry.NotBefore, entry.NotAfter)
+			}
+			if got.LogURL != entry.LogURL || got.LogIndex != entry.LogIndex {
+				t.Errorf("log = %s %d, want %s %d", got.LogURL, got.LogIndex, entry.LogURL, entry.LogIndex)
+			}
+		}},
+		{"/full-stream", func(t *testing.T, got CertEntry) {
+			if got.SerialNumber != entry.SerialNumber || got.Fingerprint != entry.Fingerprint || got.LogIndex != entry.LogIndex {
+				t.Errorf("full entry = %+v, want %+v", got, entry)
+			}
+			if string(got.der) != string(der) {
+				t.Error("full stream lost the certificate DER")
+			}
+		}},
+		{"/domains-only", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			hub := newCertstreamHub(nil)
+			server := httptest.NewServer(hub.handler())
+			defer server.Close()
+			defer hub.close()
+
+			source := newCertstreamSource("ws" + strings.TrimPrefix(server.URL, "http") + tt.path)
+			stream := source.Start()
+			defer source.Stop()
+
+			deadline := time.Now().Add(5 * time.Second)
+			for source.ready() != nil || hub.count() == 0 {
+				if time.Now().After(deadline) {
+					t.Fatal("client did not connect")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			hub.broadcast(entry)
+
+			select {
+			case got := <-stream:
+				if strings.Join(got.Domains, ",") != "api.example.com,www.example.com" {
+					t.Errorf("domains = %q", got.Domains)
+				}
+				if tt.check != nil {
+					tt.check(t, got)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("no entry received")
+			}
+		})
+	}
+}
+
+func (h *certstreamHub) count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.clients)
+}
+
+// TestServeTargets checks that a client's ?target= narrows its feed.
+func TestServeTargets(t *testing.T) {
+	tests := []struct {
+		domains []string
+		targets []string
+		want    bool
+	}{
+		{[]string{"api.example.com"}, []string{"example.com"}, true},
+		{[]string{"example.com"}, []string{"example.com"}, true},
+		{[]string{"example.org"}, []string{"example.com"}, false},
+		{[]string{"a.other.org", "b.example.com"}, []string{"example.net", "example.com"}, true},
+		{nil, []string{"example.com"}, false},
+	}
+	for _, tt := range tests {
+		if got := entryMatches(CertEntry{Domains: tt.domains}, tt.targets); got != tt.want {
+			t.Errorf("entryMatches(%q, %q) = %v, want %v", tt.domains, tt.targets, got, tt.want)
+		}
+	}
+}
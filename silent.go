@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	silentOutput   bool
+	stripWildcards bool
+)
+
+func addSilentFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&silentOutput, "silent", false, "print only matched domains to stdout, one per line and each once; everything else goes to stderr")
+	fs.BoolVar(&stripWildcards, "strip-wildcards", false, "with -silent, print *.foo.example.com as foo.example.com")
+}
+
+// seenDomains holds every domain printed with -silent, so each is printed
+// once per run whichever profile, target or log it matched in.
+var seenDomains = struct {
+	mu      sync.Mutex
+	domains map[string]bool
+}{domains: make(map[string]bool)}
+
+func printDomain(domain string) {
+	domain = strings.ToLower(domain)
+	if stripWildcards {
+		domain = strings.TrimPrefix(domain, "*.")
+	}
+
+	seenDomains.mu.Lock()
+	defer seenDomains.mu.Unlock()
+	if seenDomains.domains[domain] {
+		return
+	}
+	seenDomains.domains[domain] = true
+	os.Stdout.WriteString(domain + "\n")
+}
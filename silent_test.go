@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	charmlog "github.com/charmbracelet/log"
+)
+
+// captureStdout returns what fn writes to os.Stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	done := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- string(data)
+	}()
+	fn()
+	w.Close()
+	return <-done
+}
+
+func TestSilentOutput(t *testing.T) {
+	oldProfiles, oldLogger := profiles, logger
+	oldSilent, oldStrip := silentOutput, stripWildcards
+	t.Cleanup(func() {
+		profiles, logger = oldProfiles, oldLogger
+		silentOutput, stripWildcards = oldSilent, oldStrip
+	})
+
+	entries := []CertEntry{
+		{Domains: []string{"api.example.com", "www.other.org"}},
+		{Domains: []string{"*.dev.example.com", "API.example.com"}},
+		{Domains: []string{"dev.example.com", "shop.acme.test"}},
+	}
+
+	tests := []struct {
+		name  string
+		strip bool
+		want  string
+	}{
+		{name: "wildcards kept", want: "api.example.com\n*.dev.example.com\ndev.example.com\nshop.acme.test\n"},
+		{name: "wildcards stripped", strip: true, want: "api.example.com\ndev.example.com\nshop.acme.test\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useLogStats(t)
+			seenDomains.domains = make(map[string]bool)
+			silentOutput, stripWildcards = true, tt.strip
+			// overlapping targets and profiles match some domains several times
+			profiles = []*profile{
+				{name: "red", targets: []string{"example.com", "api.example"}},
+				{name: "blue", targets: []string{"example.com", "acme.test"}},
+			}
+			var stderr bytes.Buffer
+			logger = charmlog.New(&stderr)
+
+			got := captureStdout(t, func() {
+				for _, entry := range entries {
+					processEntry(entry)
+				}
+			})
+			if got != tt.want {
+				t.Errorf("stdout:\n%s\nwant:\n%s", got, tt.want)
+			}
+			if strings.Contains(stderr.String(), "new subdomain") {
+				t.Errorf("matches were logged as well:\n%s", stderr.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+const (
+	sourceLogs       = "logs"
+	sourceCertstream = "certstream"
+)
+
+// entrySource produces the certificate entries that monitor matches: the CT
+// logs themselves, a certstream server or a recording.
+type entrySource interface {
+	Start() <-chan CertEntry
+	Stop()
+	// healthy and ready back /healthz and /readyz.
+	healthy() error
+	ready() error
+	// queue reports the entries waiting to be matched and the buffer size.
+	queue() (depth, capacity int)
+}
+
+// SourceConfig is the source section of the configuration file.
+type SourceConfig struct {
+	Type string `yaml:"type,omitempty"`
+	URL  string `yaml:"url,omitempty"`
+}
+
+var (
+	sourceFlags  SourceConfig
+	sourceConfig SourceConfig
+)
+
+func addSourceFlags(fs *flag.FlagSet) {
+	fs.StringVar(&sourceFlags.Type, "source", "", "where entries come from: logs, certstream (default: logs)")
+	fs.StringVar(&sourceFlags.URL, "certstream-url", "", "certstream server WebSocket URL, e.g. wss://certstream.example.com/full-stream")
+}
+
+// applySourceConfig merges the source settings from the configuration file
+// with the command line flags, which take precedence.
+func applySourceConfig(cfg *Config) error {
+	var sc SourceConfig
+	if cfg != nil {
+		sc = cfg.Source
+	}
+	if sourceFlags.Type != "" {
+		sc.Type = sourceFlags.Type
+	}
+	if sourceFlags.URL != "" {
+		sc.URL = sourceFlags.URL
+		if sourceFlags.Type == "" {
+			sc.Type = sourceCertstream
+		}
+	}
+
+	sc.Type = strings.ToLower(strings.TrimSpace(sc.Type))
+	switch sc.Type {
+	case "":
+		sc.Type = sourceLogs
+	case sourceLogs:
+	case sourceCertstream:
+		if sc.URL == "" {
+			return fmt.Errorf("the certstream source needs a URL: set -certstream-url or source.url")
+		}
+		if !strings.HasPrefix(sc.URL, "ws://") && !strings.HasPrefix(sc.URL, "wss://") {
+			return fmt.Errorf("invalid certstream URL %q: must start with ws:// or wss://", sc.URL)
+		}
+	default:
+		return fmt.Errorf("invalid source %q. valid options are: logs, certstream", sc.Type)
+	}
+	sourceConfig = sc
+	return nil
+}
+
+// newEntrySource returns the source selected by -replay, -source or the
+// configuration file.
+func newEntrySource() entrySource {
+	switch {
+	case replayPath != "":
+		logger.Info("replaying recorded entries", "file", replayPath, "speed", replaySpeed)
+		return newReplaySource(replayPath, replaySpeed)
+	case sourceConfig.Type == sourceCertstream:
+		logger.Info("connecting to certstream server", "url", sourceConfig.URL)
+		return newCertstreamSource(sourceConfig.URL)
+	}
+	logger.Info("connecting to certificate transparency logs")
+	return NewCTMonitor()
+}
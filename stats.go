@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	statusWriteInterval = 10 * time.Second
+	statusStaleAfter    = time.Minute
+)
+
+var statusInterval time.Duration
+
+// logStats holds the counters of a single monitored log. They are updated by
+// the log worker and the matcher, and read by the status reporter.
+type logStats struct {
+	description   string
+	url           string
+	treeSize      atomic.Int64
+	next          atomic.Int64
+	entries       atomic.Int64
+	parseFailures atomic.Int64
+	matches       atomic.Int64
+	dropped       atomic.Int64
+	restarts      atomic.Int64
+	alerts        atomic.Int64
+	degraded      atomic.Bool
+	active        atomic.Bool
+	lastEntry     atomic.Int64
+}
+
+var logStatsRegistry = struct {
+	mu    sync.Mutex
+	logs  []*logStats
+	byURL map[string]*logStats
+}{byURL: make(map[string]*logStats)}
+
+func registerLogStats(l *ctLog, url string) *logStats {
+	logStatsRegistry.mu.Lock()
+	defer logStatsRegistry.mu.Unlock()
+
+	if s, ok := logStatsRegistry.byURL[url]; ok {
+		return s
+	}
+	s := &logStats{description: l.Description, url: url}
+	s.next.Store(-1)
+	logStatsRegistry.logs = append(logStatsRegistry.logs, s)
+	logStatsRegistry.byURL[url] = s
+	return s
+}
+
+// findLogStats returns the counters of the log with the given URL, or nil
+// for entries that did not come from a monitored log.
+func findLogStats(url string) *logStats {
+	logStatsRegistry.mu.Lock()
+	defer logStatsRegistry.mu.Unlock()
+	return logStatsRegistry.byURL[url]
+}
+
+func allLogStats() []*logStats {
+	logStatsRegistry.mu.Lock()
+	defer logStatsRegistry.mu.Unlock()
+	return append([]*logStats(nil), logStatsRegistry.logs...)
+}
+
+// size returns the last known tree size. The fetcher can see entries before
+// the periodic tree size refresh does, so the position is a lower bound.
+func (s *logStats) size() int64 {
+	return max(s.treeSize.Load(), s.next.Load())
+}
+
+// lag is the number of entries in the log that have not been processed yet.
+func (s *logStats) lag() int64 {
+	next := s.next.Load()
+	if next < 0 {
+		return 0
+	}
+	return s.size() - next
+}
+
+// notificationStats counts notification deliveries for one provider.
+type notificationStats struct {
+	sent     atomic.Int64
+	failures atomic.Int64
+	retries  atomic.Int64
+}
+
+var (
+	discordStats  notificationStats
+	telegramStats notificationStats
+)
+
+type matchKey struct {
+	profile string
+	target  string
+}
+
+var matchCounts = struct {
+	mu     sync.Mutex
+	counts map[matchKey]int64
+}{counts: make(map[matchKey]int64)}
+
+func recordMatch(p *profile, target string, stats *logStats) {
+	if stats != nil {
+		stats.matches.Add(1)
+	}
+	matchCounts.mu.Lock()
+	matchCounts.counts[matchKey{p.name, target}]++
+	matchCounts.mu.Unlock()
+}
+
+type statusSnapshot struct {
+	PID     int         `json:"pid"`
+	Started time.Time   `json:"started"`
+	Updated time.Time   `json:"updated"`
+	Logs    []logStatus `json:"logs"`
+}
+
+type logStatus struct {
+	Description   string  `json:"description"`
+	URL           string  `json:"url"`
+	TreeSize      int64   `json:"tree_size"`
+	Position      int64   `json:"position"`
+	Lag           int64   `json:"lag"`
+	EntriesPerSec float64 `json:"entries_per_sec"`
+	Entries       int64   `json:"entries"`
+	ParseFailures int64   `json:"parse_failures"`
+	Matches       int64   `json:"matches"`
+	Dropped       int64   `json:"dropped"`
+	Restarts      int64   `json:"restarts"`
+	Degraded      bool    `json:"degraded"`
+}
+
+func addStatusFlags(fs *flag.FlagSet) {
+	fs.DurationVar(&statusInterval, "status-interval", 5*time.Minute, "how often to log a status line with lag and throughput, 0 to disable")
+}
+
+func statusPath() (string, error) {
+	dir, err := getCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "status.json"), nil
+}
+
+// statusReporter builds status snapshots, with throughput measured since the
+// previous snapshot.
+type statusReporter struct {
+	started time.Time
+	last    time.Time
+	prev    map[*logStats]int64
+}
+
+func newStatusReporter() *statusReporter {
+	now := time.Now()
+	return &statusReporter{started: now, last: now, prev: make(map[*logStats]int64)}
+}
+
+func (r *statusReporter) snapshot(now time.Time) statusSnapshot {
+	snapshot := statusSnapshot{PID: os.Getpid(), Started: r.started, Updated: now}
+	elapsed := now.Sub(r.last).Seconds()
+	for _, s := range allLogStats() {
+		entries := s.entries.Load()
+		status := logStatus{
+			Description:   s.description,
+			URL:           s.url,
+			TreeSize:      s.size(),
+			Position:      max(0, s.next.Load()),
+			Lag:           s.lag(),
+			Entries:       entries,
+			ParseFailures: s.parseFailures.Load(),
+			Matches:       s.matches.Load(),
+			Dropped:       s.dropped.Load(),
+			Restarts:      s.restarts.Load(),
+			Degraded:      s.degraded.Load(),
+		}
+		if elapsed > 0 {
+			status.EntriesPerSec = float64(entries-r.prev[s]) / elapsed
+		}
+		snapshot.Logs = append(snapshot.Logs, status)
+		r.prev[s] = entries
+	}
+	r.last = now
+	return snapshot
+}
+
+// summary returns a snapshot with throughput averaged over the whole run. It
+// must not be called while run is active.
+func (r *statusReporter) summary(now time.Time) statusSnapshot {
+	r.last = r.started
+	clear(r.prev)
+	return r.snapshot(now)
+}
+
+// run logs a summary line every statusInterval until ctx is cancelled. With
+// publish set it also writes a status snapshot to the cache directory for
+// "crtmon status", which the caller removes with removeStatusSnapshot on
+// exit. Only monitor publishes, so a one-off search or backfill does not
+// replace the snapshot of a running monitor.
+func (r *statusReporter) run(ctx context.Context, publish bool) {
+	var path string
+	if publish {
+		var err error
+		if path, err = statusPath(); err != nil {
+			logger.Warn("status snapshots disabled", "error", err)
+			publish = false
+		}
+	}
+
+	tick := statusWriteInterval
+	if statusInterval > 0 && statusInterval < tick {
+		tick = statusInterval
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	lastLine := r.started
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			snapshot := r.snapshot(now)
+			if publish {
+				if err := writeStatusSnapshot(path, snapshot); err != nil {
+					logger.Debug("failed to write status snapshot", "error", err)
+				}
+			}
+
+			if statusInterval > 0 && now.Sub(lastLine)+tick/2 >= statusInterval {
+				logStatusLine(snapshot)
+				lastLine = now
+			}
+		}
+	}
+}
+
+func writeStatusSnapshot(path string, snapshot statusSnapshot) error {
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeCacheFile(path, data)
+}
+
+// readStatusSnapshot reads the snapshot published by a running monitor,
+// returning it along with the raw file contents.
+func readStatusSnapshot() (statusSnapshot, []byte, error) {
+	var snapshot statusSnapshot
+	path, err := statusPath()
+	if err != nil {
+		return snapshot, nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return snapshot, nil, err
+	}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return snapshot, nil, fmt.Errorf("failed to parse status snapshot: %w", err)
+	}
+	return snapshot, data, nil
+}
+
+// removeStatusSnapshot removes the status snapshot if this process wrote it.
+func removeStatusSnapshot() {
+	path, err := statusPath()
+	if err != nil {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	var snapshot statusSnapshot
+	if json.Unmarshal(data, &snapshot) == nil && snapshot.PID == os.Getpid() {
+		os.Remove(path)
+	}
+}
+
+func logStatusLine(snapshot statusSnapshot) {
+	var rate float64
+	var lag, matches, failures, dropped int64
+	degraded := 0
+	for _, l := range snapshot.Logs {
+		rate += l.EntriesPerSec
+		lag += l.Lag
+		matches += l.Matches
+		failures += l.ParseFailures
+		dropped += l.Dropped
+		if l.Degraded {
+			degraded++
+		}
+	}
+	logger.Info("status", "logs", len(snapshot.Logs), "entries/s", fmt.Sprintf("%.1f", rate), "lag", lag, "matches", matches, "parse_failures", failures, "dropped", dropped, "degraded", degraded)
+}
+
+func runStatus(args []string) {
+	snapshot, data, err := readStatusSnapshot()
+	if errors.Is(err, os.ErrNotExist) {
+		logger.Fatal("no running crtmon instance found")
+	}
+	if err != nil {
+		logger.Fatal("failed to read status snapshot", "error", err)
+	}
+
+	if jsonOutput {
+		fmt.Println(string(data))
+		return
+	}
+
+	age := time.Since(snapshot.Updated)
+	if age > statusStaleAfter {
+		logger.Warn("status snapshot is stale; crtmon may have stopped", "updated", snapshot.Updated.Format(time.RFC3339))
+	}
+	logger.Info("crtmon status", "pid", snapshot.PID, "uptime", snapshot.Updated.Sub(snapshot.Started).Round(time.Second), "updated", age.Round(time.Second).String()+" ago")
+	printLogStatus(os.Stdout, snapshot.Logs)
+}
+
+func printLogStatus(out io.Writer, logs []logStatus) {
+	for _, l := range logs {
+		state := successStyle.Render("ok")
+		if l.Degraded {
+			state = errorStyle.Render("degraded")
+		}
+		fmt.Fprintf(out, "%s  %s  %s\n", cmdStyle.Render(l.Description), l.URL, state)
+		fmt.Fprintf(out, "    %s\n", argStyle.Render(fmt.Sprintf("position %d/%d, lag %d, %.1f entries/s, %d matches, %d parse failures, %d dropped, %d restarts",
+			l.Position, l.TreeSize, l.Lag, l.EntriesPerSec, l.Matches, l.ParseFailures, l.Dropped, l.Restarts)))
+	}
+}
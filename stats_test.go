@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusSnapshotFile(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	path, err := statusPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := readStatusSnapshot(); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("reading a missing snapshot: got %v, want a not-exist error", err)
+	}
+
+	started := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	want := statusSnapshot{
+		PID:     os.Getpid(),
+		Started: started,
+		Updated: started.Add(time.Hour),
+		Logs: []logStatus{
+			{Description: "Argon 2026h2", URL: "https://ct.example/argon/", TreeSize: 100, Position: 90, Lag: 10, EntriesPerSec: 2.5, Entries: 90, Matches: 3, Dropped: 1},
+			{Description: "Xenon 2026h2", URL: "https://ct.example/xenon/", TreeSize: 50, Position: 50, Restarts: 2, Degraded: true},
+		},
+	}
+	if err := writeStatusSnapshot(path, want); err != nil {
+		t.Fatal(err)
+	}
+
+	got, data, err := readStatusSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("read snapshot %+v, want %+v", got, want)
+	}
+	if !strings.Contains(string(data), `"entries_per_sec": 2.5`) {
+		t.Errorf("raw snapshot is missing the throughput:\n%s", data)
+	}
+
+	var out strings.Builder
+	printLogStatus(&out, got.Logs)
+	for _, fragment := range []string{
+		"Argon 2026h2  https://ct.example/argon/  ok",
+		"position 90/100, lag 10, 2.5 entries/s, 3 matches, 0 parse failures, 1 dropped, 0 restarts",
+		"Xenon 2026h2  https://ct.example/xenon/  degraded",
+	} {
+		if !strings.Contains(out.String(), fragment) {
+			t.Errorf("status output is missing %q:\n%s", fragment, out.String())
+		}
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := readStatusSnapshot(); err == nil || errors.Is(err, os.ErrNotExist) {
+		t.Errorf("reading a corrupt snapshot: got %v, want a parse error", err)
+	}
+}
+
+func TestRemoveStatusSnapshot(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	path, err := statusPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeStatusSnapshot(path, statusSnapshot{PID: os.Getpid() + 1}); err != nil {
+		t.Fatal(err)
+	}
+	removeStatusSnapshot()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("removed the snapshot of another process: %v", err)
+	}
+
+	if err := writeStatusSnapshot(path, statusSnapshot{PID: os.Getpid()}); err != nil {
+		t.Fatal(err)
+	}
+	removeStatusSnapshot()
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("snapshot of this process still exists: %v", err)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	ct "github.com/google/certificate-transparency-go"
+	"golang.org/x/crypto/cryptobyte"
+)
+
+const (
+	tileWidth         = 256
+	tiledPollInterval = 5 * time.Second
+)
+
+var errTileNotFound = errors.New("tile not found")
+
+// tiledFetcher reads logs that implement the static-ct-api, where entries
+// are published as data tiles of 256 entries below a signed checkpoint.
+type tiledFetcher struct {
+	logURL  string
+	client  *http.Client
+	mu      sync.Mutex
+	issuers map[string][]byte
+}
+
+// tileEntry is a data tile entry with the fingerprint of its issuer, which
+// tiled logs serve separately.
+type tileEntry struct {
+	rawEntry
+	issuerFingerprint []byte
+}
+
+type checkpoint struct {
+	origin   string
+	size     int64
+	rootHash []byte
+	raw      []byte
+}
+
+func (f *tiledFetcher) url() string {
+	return f.logURL
+}
+
+func (f *tiledFetcher) treeSize(ctx context.Context) (int64, error) {
+	cp, err := f.checkpoint(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return cp.size, nil
+}
+
+func (f *tiledFetcher) checkpoint(ctx context.Context) (*checkpoint, error) {
+	data, err := f.get(ctx, f.logURL+"/checkpoint")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch checkpoint: %w", err)
+	}
+	return parseCheckpoint(data)
+}
+
+func (f *tiledFetcher) fetch(ctx context.Context, start, end int64, fn func(rawEntry)) error {
+	pos := start
+	for {
+		size := end
+		if end == 0 {
+			var err error
+			if size, err = f.treeSize(ctx); err != nil {
+				return err
+			}
+		}
+
+		for pos < size {
+			tile := pos / tileWidth
+			width := min(int64(tileWidth), size-tile*tileWidth)
+
+			entries, err := f.dataTile(ctx, tile, width)
+			if err != nil {
+				return err
+			}
+
+			limit := int64(len(entries))
+			if end != 0 {
+				limit = min(limit, end-tile*tileWidth)
+			}
+			if tile*tileWidth+limit <= pos {
+				break
+			}
+			for i := pos - tile*tileWidth; i < limit; i++ {
+				entry := entries[i].rawEntry
+				if verifySCT && entry.entryType == ct.X509LogEntryType && entries[i].issuerFingerprint != nil {
+					entry.issuer = f.issuer(ctx, entries[i].issuerFingerprint)
+				}
+				fn(entry)
+			}
+			pos = tile*tileWidth + limit
+		}
+
+		if end != 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(tiledPollInterval):
+		}
+	}
+}
+
+// dataTile fetches and parses data tile n holding width entries. A partial
+// tile may already have been replaced by the full tile, so that is tried
+// when the partial one is gone.
+func (f *tiledFetcher) dataTile(ctx context.Context, n, width int64) ([]tileEntry, error) {
+	path := f.logURL + "/tile/data/" + tilePath(n)
+	var data []byte
+	var err error
+	if width < tileWidth {
+		data, err = f.get(ctx, path+".p/"+strconv.FormatInt(width, 10))
+		if errors.Is(err, errTileNotFound) {
+			data, err = f.get(ctx, path)
+		}
+	} else {
+		data, err = f.get(ctx, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch data tile %d: %w", n, err)
+	}
+
+	entries, err := parseDataTile(data, n*tileWidth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse data tile %d: %w", n, err)
+	}
+	return entries, nil
+}
+
+// issuer returns the issuing certificate with the given SHA-256 fingerprint,
+// or nil if it cannot be fetched. Issuers are few, so they are kept in memory.
+func (f *tiledFetcher) issuer(ctx context.Context, fingerprint []byte) []byte {
+	key := hex.EncodeToString(fingerprint)
+
+	f.mu.Lock()
+	cert, ok := f.issuers[key]
+	f.mu.Unlock()
+	if ok {
+		return cert
+	}
+
+	cert, err := f.get(ctx, f.logURL+"/issuer/"+key)
+	if err != nil {
+		logger.Debug("failed to fetch issuer", "log", f.logURL, "fingerprint", key, "error", err)
+		return nil
+	}
+	if sum := sha256.Sum256(cert); !bytes.Equal(sum[:], fingerprint) {
+		logger.Debug("issuer does not match its fingerprint", "log", f.logURL, "fingerprint", key)
+		return nil
+	}
+
+	f.mu.Lock()
+	f.issuers[key] = cert
+	f.mu.Unlock()
+	return cert
+}
+
+func (f *tiledFetcher) get(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return io.ReadAll(resp.Body)
+	case http.StatusNotFound:
+		return nil, errTileNotFound
+	default:
+		return nil, fmt.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+}
+
+// tilePath encodes a tile index as groups of three digits, with every group
+// but the last prefixed by "x", e.g. 1234067 becomes x001/x234/067.
+func tilePath(n int64) string {
+	path := fmt.Sprintf("%03d", n%1000)
+	for n /= 1000; n > 0; n /= 1000 {
+		path = fmt.Sprintf("x%03d/", n%1000) + path
+	}
+	return path
+}
+
+// parseCheckpoint reads the origin, tree size and root hash from the body of
+// a checkpoint note. Signature lines are kept in raw for verification.
+func parseCheckpoint(data []byte) (*checkpoint, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	var lines []string
+	for scanner.Scan() && len(lines) < 3 {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) < 3 {
+		return nil, errors.New("malformed checkpoint")
+	}
+
+	size, err := strconv.ParseInt(lines[1], 10, 64)
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("malformed checkpoint tree size %q", lines[1])
+	}
+	rootHash, err := base64.StdEncoding.DecodeString(lines[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed checkpoint root hash: %w", err)
+	}
+
+	return &checkpoint{origin: lines[0], size: size, rootHash: rootHash, raw: data}, nil
+}
+
+// parseNoteSignature splits a note signature line into the key name and the
+// decoded signature, which starts with the 4-byte key ID.
+func parseNoteSignature(line string) (string, []byte, bool) {
+	rest, ok := strings.CutPrefix(line, "— ")
+	if !ok {
+		return "", nil, false
+	}
+	name, encoded, ok := strings.Cut(rest, " ")
+	if !ok {
+		return "", nil, false
+	}
+	sig, err := base64.StdEncoding.DecodeString(encoded)
+	return name, sig, err == nil
+}
+
+// parseDataTile decodes the TileLeaf entries of a static-ct-api data tile.
+func parseDataTile(data []byte, firstIndex int64) ([]tileEntry, error) {
+	s := cryptobyte.String(data)
+	var entries []tileEntry
+
+	for !s.Empty() {
+		var entry tileEntry
+		entry.index = firstIndex + int64(len(entries))
+		var entryType uint16
+		var cert, extensions, chain cryptobyte.String
+
+		if !s.ReadUint64(&entry.timestamp) || !s.ReadUint16(&entryType) {
+			return nil, errors.New("truncated entry header")
+		}
+
+		switch ct.LogEntryType(entryType) {
+		case ct.X509LogEntryType:
+			if !s.ReadUint24LengthPrefixed(&cert) ||
+				!s.ReadUint16LengthPrefixed(&extensions) ||
+				!s.ReadUint16LengthPrefixed(&chain) {
+				return nil, errors.New("truncated x509 entry")
+			}
+			entry.der = cert
+		case ct.PrecertLogEntryType:
+			var issuerKeyHash, precert cryptobyte.String
+			if !s.ReadBytes((*[]byte)(&issuerKeyHash), 32) ||
+				!s.ReadUint24LengthPrefixed(&cert) ||
+				!s.ReadUint16LengthPrefixed(&extensions) ||
+				!s.ReadUint24LengthPrefixed(&precert) ||
+				!s.ReadUint16LengthPrefixed(&chain) {
+				return nil, errors.New("truncated precert entry")
+			}
+			entry.der = precert
+		default:
+			return nil, fmt.Errorf("unknown entry type %d", entryType)
+		}
+
+		entry.entryType = ct.LogEntryType(entryType)
+		entry.cert = cert
+		if len(chain) >= sha256.Size {
+			entry.issuerFingerprint = chain[:sha256.Size]
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
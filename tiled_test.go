@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	ct "github.com/google/certificate-transparency-go"
+	"golang.org/x/crypto/cryptobyte"
+)
+
+func TestTilePath(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "000"},
+		{7, "007"},
+		{999, "999"},
+		{1000, "x001/000"},
+		{1234067, "x001/x234/067"},
+		{1000000, "x001/x000/000"},
+	}
+	for _, tt := range tests {
+		if got := tilePath(tt.n); got != tt.want {
+			t.Errorf("tilePath(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestParseCheckpoint(t *testing.T) {
+	root := bytes.Repeat([]byte{0xab}, 32)
+	rootB64 := base64.StdEncoding.EncodeToString(root)
+
+	tests := []struct {
+		name     string
+		note     string
+		wantErr  string
+		wantSize int64
+	}{
+		{
+			name:     "signed note",
+			note:     "example.com/log\n1234\n" + rootB64 + "\n\n— example.com/log AAAAAQ==\n",
+			wantSize: 1234,
+		},
+		{
+			name:     "empty tree",
+			note:     "example.com/log\n0\n" + rootB64 + "\n",
+			wantSize: 0,
+		},
+		{name: "missing root hash", note: "example.com/log\n1234\n", wantErr: "malformed checkpoint"},
+		{name: "bad size", note: "example.com/log\nabc\n" + rootB64 + "\n", wantErr: "tree size"},
+		{name: "negative size", note: "example.com/log\n-1\n" + rootB64 + "\n", wantErr: "tree size"},
+		{name: "bad root hash", note: "example.com/log\n12\nnot base64!\n", wantErr: "root hash"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cp, err := parseCheckpoint([]byte(tt.note))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseCheckpoint() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseCheckpoint() error = %v", err)
+			}
+			if cp.origin != "example.com/log" || cp.size != tt.wantSize || !bytes.Equal(cp.rootHash, root) {
+				t.Errorf("parseCheckpoint() = %q %d %x", cp.origin, cp.size, cp.rootHash)
+			}
+			if string(cp.raw) != tt.note {
+				t.Errorf("parseCheckpoint() did not keep the raw note")
+			}
+		})
+	}
+}
+
+func TestParseNoteSignature(t *testing.T) {
+	tests := []struct {
+		line     string
+		wantName string
+		wantSig  []byte
+		wantOK   bool
+	}{
+		{"— example.com/log AAAAAQI=", "example.com/log", []byte{0, 0, 0, 1, 2}, true},
+		{"- example.com/log AAAAAQI=", "", nil, false},
+		{"— example.com/log", "", nil, false},
+		{"— example.com/log not-base64", "example.com/log", nil, false},
+		{"", "", nil, false},
+	}
+	for _, tt := range tests {
+		name, sig, ok := parseNoteSignature(tt.line)
+		if ok != tt.wantOK {
+			t.Errorf("parseNoteSignature(%q) ok = %v, want %v", tt.line, ok, tt.wantOK)
+			continue
+		}
+		if ok && (name != tt.wantName || !bytes.Equal(sig, tt.wantSig)) {
+			t.Errorf("parseNoteSignature(%q) = %q, %x", tt.line, name, sig)
+		}
+	}
+}
+
+// tileLeaf encodes a TileLeaf as served in static-ct-api data tiles.
+func tileLeaf(timestamp uint64, entryType ct.LogEntryType, cert, precert []byte, chain ...[]byte) []byte {
+	var b cryptobyte.Builder
+	b.AddUint64(timestamp)
+	b.AddUint16(uint16(entryType))
+	if entryType == ct.PrecertLogEntryType {
+		b.AddBytes(bytes.Repeat([]byte{0x11}, 32))
+	}
+	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(cert) })
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {})
+	if entryType == ct.PrecertLogEntryType {
+		b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(precert) })
+	}
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		for _, c := range chain {
+			b.AddBytes(c)
+		}
+	})
+	return b.BytesOrPanic()
+}
+
+func TestParseDataTile(t *testing.T) {
+	issuer := bytes.Repeat([]byte{0x22}, 32)
+	x509 := tileLeaf(1000, ct.X509LogEntryType, []byte("cert-der"), nil, issuer)
+	precert := tileLeaf(2000, ct.PrecertLogEntryType, []byte("tbs"), []byte("precert-der"), issuer)
+	noChain := tileLeaf(3000, ct.X509LogEntryType, []byte("self-signed"), nil)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []rawEntry
+		wantErr string
+	}{
+		{name: "empty tile", data: nil},
+		{
+			name: "x509 and precert",
+			data: append(append([]byte{}, x509...), precert...),
+			want: []rawEntry{
+				{index: 512, timestamp: 1000, entryType: ct.X509LogEntryType, cert: []byte("cert-der")},
+				{index: 513, timestamp: 2000, entryType: ct.PrecertLogEntryType, cert: []byte("tbs")},
+			},
+		},
+		{
+			name: "no issuer in chain",
+			data: noChain,
+			want: []rawEntry{
+				{index: 512, timestamp: 3000, entryType: ct.X509LogEntryType, cert: []byte("self-signed")},
+			},
+		},
+		{name: "truncated header", data: x509[:5], wantErr: "truncated entry header"},
+		{name: "truncated x509", data: x509[:len(x509)-1], wantErr: "truncated x509 entry"},
+		{name: "truncated precert", data: precert[:len(precert)-1], wantErr: "truncated precert entry"},
+		{name: "unknown type", data: []byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 9}, wantErr: "unknown entry type 9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDataTile(tt.data, 512)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("parseDataTile() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseDataTile() error = %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseDataTile() returned %d entries, want %d", len(got), len(tt.want))
+			}
+			for i, want := range tt.want {
+				e := got[i]
+				if e.index != want.index || e.timestamp != want.timestamp || e.entryType != want.entryType || !bytes.Equal(e.cert, want.cert) {
+					t.Errorf("entry %d = %+v, want %+v", i, e, want)
+				}
+			}
+		})
+	}
+}